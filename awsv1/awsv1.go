@@ -0,0 +1,251 @@
+// Package awsv1 adapts the AWS SDK for Go v1 to chiv, so archives can be uploaded to, verified in and restored
+// from S3 with a v1 session.
+//
+// Configure an Archiver with an uploader and client sharing a session.
+//
+//	sess := session.Must(session.NewSession())
+//	uploader := awsv1.NewUploader(s3manager.NewUploader(sess))
+//	chiv.Archive(db, uploader, "table", "bucket", chiv.WithVerify(awsv1.NewClient(s3.New(sess)), chiv.VerifySize))
+package awsv1
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+
+	"gavincabbage.com/chiv"
+)
+
+// Uploader uploads objects to S3. *s3manager.Uploader implements Uploader.
+type Uploader interface {
+	UploadWithContext(ctx aws.Context, input *s3manager.UploadInput, opts ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error)
+}
+
+type uploader struct {
+	uploader Uploader
+}
+
+// NewUploader returns a chiv.Uploader uploading objects with an Uploader.
+func NewUploader(u Uploader) chiv.Uploader {
+	return &uploader{uploader: u}
+}
+
+// Upload an object.
+func (u *uploader) Upload(ctx context.Context, bucket, key string, attributes *chiv.Attributes, r io.Reader) (*chiv.ObjectInfo, error) {
+	input := &s3manager.UploadInput{
+		Body:   r,
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	if attributes != nil {
+		input.ContentType, input.ContentEncoding, input.Metadata = convert(attributes)
+	}
+
+	output, err := u.uploader.UploadWithContext(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	return &chiv.ObjectInfo{
+		Location:  output.Location,
+		VersionID: aws.StringValue(output.VersionID),
+		ETag:      aws.StringValue(output.ETag),
+	}, nil
+}
+
+// NewSink returns a chiv.AttributeSink uploading objects to an S3 bucket.
+func NewSink(u Uploader, bucket string) chiv.Sink {
+	return chiv.NewUploaderSink(NewUploader(u), bucket)
+}
+
+// API gets, puts and copies S3 objects. *s3.S3 implements API.
+type API interface {
+	GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error)
+	HeadObjectWithContext(ctx aws.Context, input *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, error)
+	CopyObjectWithContext(ctx aws.Context, input *s3.CopyObjectInput, opts ...request.Option) (*s3.CopyObjectOutput, error)
+	PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error)
+}
+
+// Client adapts an S3 client of the v1 SDK to chiv.Downloader, chiv.Verifier and chiv.ObjectStore.
+type Client struct {
+	api API
+}
+
+// NewClient returns a Client calling an S3 client of the v1 SDK.
+func NewClient(api API) *Client {
+	return &Client{api: api}
+}
+
+// Download an object without transport compression.
+func (c *Client) Download(ctx context.Context, bucket, key string) (io.ReadCloser, *chiv.Attributes, error) {
+	output, err := c.api.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}, identityEncoding)
+	if err != nil {
+		return nil, nil, translate(err)
+	}
+
+	return output.Body, &chiv.Attributes{
+		ContentType:     aws.StringValue(output.ContentType),
+		ContentEncoding: aws.StringValue(output.ContentEncoding),
+		Metadata:        aws.StringValueMap(output.Metadata),
+	}, nil
+}
+
+// identityEncoding requests an object without transport compression, so objects uploaded with a
+// Content-Encoding are not transparently decompressed before chiv decompresses them.
+func identityEncoding(r *request.Request) {
+	r.HTTPRequest.Header.Set("Accept-Encoding", "identity")
+}
+
+// Stat describes an object.
+func (c *Client) Stat(ctx context.Context, bucket, key string) (int64, *chiv.Attributes, error) {
+	output, err := c.api.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return 0, nil, translate(err)
+	}
+
+	return aws.Int64Value(output.ContentLength), &chiv.Attributes{
+		ContentType:     aws.StringValue(output.ContentType),
+		ContentEncoding: aws.StringValue(output.ContentEncoding),
+		Metadata:        aws.StringValueMap(output.Metadata),
+	}, nil
+}
+
+// Annotate an object by copying it in place, replacing its attributes. The copy keeps the object's other
+// headers, storage class, server-side encryption, object lock and tags, and fails if the object changed since
+// they were read.
+func (c *Client) Annotate(ctx context.Context, bucket, key string, attributes *chiv.Attributes) (*chiv.ObjectInfo, error) {
+	head, err := c.api.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	input := &s3.CopyObjectInput{
+		Bucket:                    aws.String(bucket),
+		Key:                       aws.String(key),
+		CopySource:                aws.String((&url.URL{Path: bucket + "/" + key}).EscapedPath()),
+		CopySourceIfMatch:         head.ETag,
+		MetadataDirective:         aws.String(s3.MetadataDirectiveReplace),
+		TaggingDirective:          aws.String(s3.TaggingDirectiveCopy),
+		CacheControl:              head.CacheControl,
+		ContentDisposition:        head.ContentDisposition,
+		ContentLanguage:           head.ContentLanguage,
+		StorageClass:              head.StorageClass,
+		ServerSideEncryption:      head.ServerSideEncryption,
+		SSEKMSKeyId:               head.SSEKMSKeyId,
+		BucketKeyEnabled:          head.BucketKeyEnabled,
+		ObjectLockMode:            head.ObjectLockMode,
+		ObjectLockRetainUntilDate: head.ObjectLockRetainUntilDate,
+		ObjectLockLegalHoldStatus: head.ObjectLockLegalHoldStatus,
+		WebsiteRedirectLocation:   head.WebsiteRedirectLocation,
+	}
+	if expires, err := http.ParseTime(aws.StringValue(head.Expires)); err == nil {
+		input.Expires = aws.Time(expires)
+	}
+	input.ContentType, input.ContentEncoding, input.Metadata = convert(attributes)
+
+	output, err := c.api.CopyObjectWithContext(ctx, input)
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	info := &chiv.ObjectInfo{VersionID: aws.StringValue(output.VersionId)}
+	if output.CopyObjectResult != nil {
+		info.ETag = aws.StringValue(output.CopyObjectResult.ETag)
+	}
+
+	return info, nil
+}
+
+// Upload an object in a single request, buffering it unless it can be read again when signing.
+func (c *Client) Upload(ctx context.Context, bucket, key string, attributes *chiv.Attributes, r io.Reader) (*chiv.ObjectInfo, error) {
+	body, ok := r.(io.ReadSeeker)
+	if !ok {
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	input := &s3.PutObjectInput{
+		Body:   aws.ReadSeekCloser(body),
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	if attributes != nil {
+		input.ContentType, input.ContentEncoding, input.Metadata = convert(attributes)
+	}
+
+	output, err := c.api.PutObjectWithContext(ctx, input)
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	return &chiv.ObjectInfo{
+		VersionID: aws.StringValue(output.VersionId),
+		ETag:      aws.StringValue(output.ETag),
+	}, nil
+}
+
+// convert returns the content type, content encoding and metadata of an object to upload, leaving
+// each unset if empty.
+func convert(attributes *chiv.Attributes) (contentType, contentEncoding *string, metadata map[string]*string) {
+	if attributes.ContentType != "" {
+		contentType = aws.String(attributes.ContentType)
+	}
+	if attributes.ContentEncoding != "" {
+		contentEncoding = aws.String(attributes.ContentEncoding)
+	}
+	if len(attributes.Metadata) > 0 {
+		metadata = aws.StringMap(attributes.Metadata)
+	}
+
+	return contentType, contentEncoding, metadata
+}
+
+// notFound is an error for a missing object, matching chiv.ErrNotFound.
+type notFound struct {
+	err error
+}
+
+func (e *notFound) Error() string {
+	return e.err.Error()
+}
+
+func (e *notFound) Unwrap() error {
+	return e.err
+}
+
+func (e *notFound) Is(target error) bool {
+	return target == chiv.ErrNotFound
+}
+
+// translate returns an error matching chiv.ErrNotFound for a missing object, e.g. a missing checkpoint object.
+// Objects missing from GetObject and HeadObject are reported with different codes.
+func translate(err error) error {
+	var e awserr.Error
+	if errors.As(err, &e) && (e.Code() == s3.ErrCodeNoSuchKey || e.Code() == "NotFound") {
+		return &notFound{err: err}
+	}
+
+	return err
+}
@@ -0,0 +1,261 @@
+// +build unit
+
+package awsv1_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/stretchr/testify/require"
+
+	"gavincabbage.com/chiv"
+	"gavincabbage.com/chiv/awsv1"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestArchive(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`CREATE TABLE events (id INTEGER, name TEXT);
+		INSERT INTO events VALUES (1, 'first'), (2, 'second');`)
+	require.NoError(t, err)
+
+	bucket := newBucket()
+	client := awsv1.NewClient(bucket)
+	store := chiv.NewS3CheckpointStore(client, "bucket", "checkpoints.json")
+
+	subject := chiv.NewArchiver(db, awsv1.NewUploader(infrequent{bucket}))
+	result, err := subject.ArchiveWithResult("events", "bucket",
+		chiv.WithVerify(client, chiv.VerifyContent),
+		chiv.WithAnnotation(),
+		chiv.WithIncremental("id", store),
+		chiv.WithSchemaSidecar(),
+	)
+	require.NoError(t, err)
+
+	require.Len(t, result.Objects, 1)
+	require.Equal(t, "https://bucket.s3.amazonaws.com/events.csv", result.Objects[0].Location)
+	require.Equal(t, "2", result.Objects[0].VersionID)
+
+	object := bucket.objects["events.csv"]
+	require.Equal(t, "id,name\n1,first\n2,second\n", string(object.data))
+	require.Equal(t, "2", aws.StringValue(object.metadata[chiv.MetadataRows]))
+	require.Equal(t, result.Objects[0].Checksum, aws.StringValue(object.metadata[chiv.MetadataChecksum]))
+	require.Equal(t, s3.StorageClassStandardIa, aws.StringValue(object.storageClass))
+	require.Equal(t, "application/json", aws.StringValue(bucket.objects["events.csv.schema.json"].contentType))
+	require.Equal(t, "application/json", aws.StringValue(bucket.objects["checkpoints.json"].contentType))
+
+	checkpoint, ok, err := store.Load(context.Background(), "events")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "2", checkpoint)
+
+	restored, err := chiv.Restore(context.Background(), db, client, "bucket", "events.csv", "restored", chiv.WithCreateTable())
+	require.NoError(t, err)
+	require.Equal(t, int64(2), restored.Rows)
+}
+
+func TestErrors(t *testing.T) {
+	bucket := newBucket()
+	bucket.err = errors.New("uploading")
+
+	w, err := awsv1.NewSink(bucket, "bucket").Open(context.Background(), "events.csv")
+	require.NoError(t, err)
+	_, _ = w.Write([]byte("id\n"))
+	require.EqualError(t, w.Close(), "uploading")
+
+	_, _, err = awsv1.NewClient(bucket).Download(context.Background(), "bucket", "events.csv")
+	require.EqualError(t, err, "uploading")
+
+	bucket.err = nil
+	_, _, err = awsv1.NewClient(bucket).Download(context.Background(), "bucket", "missing.csv")
+	require.True(t, errors.Is(err, chiv.ErrNotFound))
+	var e awserr.Error
+	require.True(t, errors.As(err, &e))
+	require.Equal(t, s3.ErrCodeNoSuchKey, e.Code())
+
+	_, _, err = chiv.NewS3CheckpointStore(awsv1.NewClient(bucket), "bucket", "missing.json").Load(context.Background(), "events")
+	require.NoError(t, err)
+}
+
+type object struct {
+	data            []byte
+	contentType     *string
+	contentEncoding *string
+	metadata        map[string]*string
+	storageClass    *string
+	etag            string
+	version         int
+}
+
+// bucket fakes an S3 bucket for the v1 SDK's uploader and client.
+type bucket struct {
+	mu      sync.Mutex
+	objects map[string]*object
+	version int
+	err     error
+}
+
+func newBucket() *bucket {
+	return &bucket{objects: make(map[string]*object)}
+}
+
+func (b *bucket) put(input *s3manager.UploadInput) (*object, error) {
+	data, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.version++
+	o := &object{
+		data:            data,
+		contentType:     input.ContentType,
+		contentEncoding: input.ContentEncoding,
+		metadata:        input.Metadata,
+		storageClass:    input.StorageClass,
+		etag:            `"etag"`,
+		version:         b.version,
+	}
+	b.objects[aws.StringValue(input.Key)] = o
+
+	return o, nil
+}
+
+func (b *bucket) get(key *string) (*object, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.err != nil {
+		return nil, b.err
+	}
+	o, ok := b.objects[aws.StringValue(key)]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "missing", nil)
+	}
+
+	return o, nil
+}
+
+func (b *bucket) UploadWithContext(_ aws.Context, input *s3manager.UploadInput, _ ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	o, err := b.put(input)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3manager.UploadOutput{
+		Location:  "https://bucket.s3.amazonaws.com/" + aws.StringValue(input.Key),
+		VersionID: aws.String(fmt.Sprint(o.version)),
+		ETag:      aws.String(`"etag"`),
+	}, nil
+}
+
+func (b *bucket) GetObjectWithContext(_ aws.Context, input *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	o, err := b.get(input.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3.GetObjectOutput{
+		Body:            ioutil.NopCloser(bytes.NewReader(o.data)),
+		ContentLength:   aws.Int64(int64(len(o.data))),
+		ContentType:     o.contentType,
+		ContentEncoding: o.contentEncoding,
+		Metadata:        o.metadata,
+	}, nil
+}
+
+func (b *bucket) HeadObjectWithContext(_ aws.Context, input *s3.HeadObjectInput, _ ...request.Option) (*s3.HeadObjectOutput, error) {
+	o, err := b.get(input.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3.HeadObjectOutput{
+		ContentLength:   aws.Int64(int64(len(o.data))),
+		ContentType:     o.contentType,
+		ContentEncoding: o.contentEncoding,
+		Metadata:        o.metadata,
+		StorageClass:    o.storageClass,
+		ETag:            aws.String(o.etag),
+	}, nil
+}
+
+func (b *bucket) CopyObjectWithContext(_ aws.Context, input *s3.CopyObjectInput, _ ...request.Option) (*s3.CopyObjectOutput, error) {
+	o, err := b.get(input.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if aws.StringValue(input.MetadataDirective) != s3.MetadataDirectiveReplace ||
+		aws.StringValue(input.TaggingDirective) != s3.TaggingDirectiveCopy {
+		return nil, errors.New("unexpected directive")
+	}
+	if aws.StringValue(input.CopySource) != "bucket/"+aws.StringValue(input.Key) {
+		return nil, errors.New("unexpected copy source")
+	}
+	if aws.StringValue(input.CopySourceIfMatch) != o.etag {
+		return nil, errors.New("precondition failed")
+	}
+	b.version++
+	o.contentType = input.ContentType
+	o.contentEncoding = input.ContentEncoding
+	o.metadata = input.Metadata
+	o.storageClass = input.StorageClass
+	o.etag = `"copied"`
+	o.version = b.version
+
+	return &s3.CopyObjectOutput{
+		VersionId:        aws.String(fmt.Sprint(o.version)),
+		CopyObjectResult: &s3.CopyObjectResult{ETag: aws.String(o.etag)},
+	}, nil
+}
+
+func (b *bucket) PutObjectWithContext(_ aws.Context, input *s3.PutObjectInput, _ ...request.Option) (*s3.PutObjectOutput, error) {
+	o, err := b.put(&s3manager.UploadInput{
+		Body:            input.Body,
+		Key:             input.Key,
+		ContentType:     input.ContentType,
+		ContentEncoding: input.ContentEncoding,
+		Metadata:        input.Metadata,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3.PutObjectOutput{VersionId: aws.String(fmt.Sprint(o.version))}, nil
+}
+
+// infrequent uploads objects to a bucket in the infrequent access storage class.
+type infrequent struct {
+	*bucket
+}
+
+func (u infrequent) UploadWithContext(ctx aws.Context, input *s3manager.UploadInput, opts ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	input.StorageClass = aws.String(s3.StorageClassStandardIa)
+	return u.bucket.UploadWithContext(ctx, input, opts...)
+}
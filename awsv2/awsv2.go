@@ -0,0 +1,247 @@
+// Package awsv2 adapts the AWS SDK for Go v2 to chiv, so archives can be uploaded to, verified in and restored
+// from S3 without the v1 SDK's session and credential chain.
+//
+// Configure an Archiver with a sink uploading to a bucket.
+//
+//	cfg, _ := config.LoadDefaultConfig(ctx)
+//	client := s3.NewFromConfig(cfg)
+//	sink := awsv2.NewSink(manager.NewUploader(client), "bucket")
+//	chiv.Archive(db, nil, "table", "bucket", chiv.WithSink(sink), chiv.WithVerify(awsv2.NewClient(client), chiv.VerifySize))
+package awsv2
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"gavincabbage.com/chiv"
+)
+
+// Uploader uploads objects to S3. *manager.Uploader implements Uploader.
+type Uploader interface {
+	Upload(ctx context.Context, input *s3.PutObjectInput, opts ...func(*manager.Uploader)) (*manager.UploadOutput, error)
+}
+
+type uploader struct {
+	uploader Uploader
+}
+
+// NewUploader returns a chiv.Uploader uploading objects with an Uploader.
+func NewUploader(u Uploader) chiv.Uploader {
+	return &uploader{uploader: u}
+}
+
+// Upload an object.
+func (u *uploader) Upload(ctx context.Context, bucket, key string, attributes *chiv.Attributes, r io.Reader) (*chiv.ObjectInfo, error) {
+	input := &s3.PutObjectInput{
+		Body:   r,
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	setAttributes(input, attributes)
+
+	output, err := u.uploader.Upload(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	return &chiv.ObjectInfo{
+		Location:  output.Location,
+		VersionID: aws.ToString(output.VersionID),
+		ETag:      aws.ToString(output.ETag),
+	}, nil
+}
+
+// NewSink returns a chiv.AttributeSink uploading objects to an S3 bucket.
+func NewSink(u Uploader, bucket string) chiv.Sink {
+	return chiv.NewUploaderSink(NewUploader(u), bucket)
+}
+
+// API gets, puts and copies S3 objects. *s3.Client implements API.
+type API interface {
+	GetObject(ctx context.Context, input *s3.GetObjectInput, opts ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	HeadObject(ctx context.Context, input *s3.HeadObjectInput, opts ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	CopyObject(ctx context.Context, input *s3.CopyObjectInput, opts ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+	PutObject(ctx context.Context, input *s3.PutObjectInput, opts ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+// Client adapts an S3 client of the v2 SDK to chiv.Downloader, chiv.Verifier and chiv.ObjectStore.
+// Objects are downloaded without transport compression, as the v2 SDK does by default.
+type Client struct {
+	api API
+}
+
+// NewClient returns a Client calling an S3 client of the v2 SDK.
+func NewClient(api API) *Client {
+	return &Client{api: api}
+}
+
+// Download an object.
+func (c *Client) Download(ctx context.Context, bucket, key string) (io.ReadCloser, *chiv.Attributes, error) {
+	output, err := c.api.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, nil, translate(err)
+	}
+
+	return output.Body, &chiv.Attributes{
+		ContentType:     aws.ToString(output.ContentType),
+		ContentEncoding: aws.ToString(output.ContentEncoding),
+		Metadata:        output.Metadata,
+	}, nil
+}
+
+// Stat describes an object.
+func (c *Client) Stat(ctx context.Context, bucket, key string) (int64, *chiv.Attributes, error) {
+	output, err := c.api.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return 0, nil, translate(err)
+	}
+
+	return output.ContentLength, &chiv.Attributes{
+		ContentType:     aws.ToString(output.ContentType),
+		ContentEncoding: aws.ToString(output.ContentEncoding),
+		Metadata:        output.Metadata,
+	}, nil
+}
+
+// Annotate an object by copying it in place, replacing its attributes. The copy keeps the object's other
+// headers, storage class, server-side encryption, object lock and tags, and fails if the object changed since
+// they were read.
+func (c *Client) Annotate(ctx context.Context, bucket, key string, attributes *chiv.Attributes) (*chiv.ObjectInfo, error) {
+	head, err := c.api.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	input := &s3.CopyObjectInput{
+		Bucket:                    aws.String(bucket),
+		Key:                       aws.String(key),
+		CopySource:                aws.String((&url.URL{Path: bucket + "/" + key}).EscapedPath()),
+		CopySourceIfMatch:         head.ETag,
+		MetadataDirective:         types.MetadataDirectiveReplace,
+		TaggingDirective:          types.TaggingDirectiveCopy,
+		Metadata:                  attributes.Metadata,
+		CacheControl:              head.CacheControl,
+		ContentDisposition:        head.ContentDisposition,
+		ContentLanguage:           head.ContentLanguage,
+		Expires:                   head.Expires,
+		StorageClass:              head.StorageClass,
+		ServerSideEncryption:      head.ServerSideEncryption,
+		SSEKMSKeyId:               head.SSEKMSKeyId,
+		BucketKeyEnabled:          head.BucketKeyEnabled,
+		ObjectLockMode:            head.ObjectLockMode,
+		ObjectLockRetainUntilDate: head.ObjectLockRetainUntilDate,
+		ObjectLockLegalHoldStatus: head.ObjectLockLegalHoldStatus,
+		WebsiteRedirectLocation:   head.WebsiteRedirectLocation,
+	}
+	if attributes.ContentType != "" {
+		input.ContentType = aws.String(attributes.ContentType)
+	}
+	if attributes.ContentEncoding != "" {
+		input.ContentEncoding = aws.String(attributes.ContentEncoding)
+	}
+
+	output, err := c.api.CopyObject(ctx, input)
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	info := &chiv.ObjectInfo{VersionID: aws.ToString(output.VersionId)}
+	if output.CopyObjectResult != nil {
+		info.ETag = aws.ToString(output.CopyObjectResult.ETag)
+	}
+
+	return info, nil
+}
+
+// Upload an object in a single request, buffering it unless it can be read again when signing.
+func (c *Client) Upload(ctx context.Context, bucket, key string, attributes *chiv.Attributes, r io.Reader) (*chiv.ObjectInfo, error) {
+	if _, ok := r.(io.ReadSeeker); !ok {
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	input := &s3.PutObjectInput{
+		Body:   r,
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	setAttributes(input, attributes)
+
+	output, err := c.api.PutObject(ctx, input)
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	return &chiv.ObjectInfo{
+		VersionID: aws.ToString(output.VersionId),
+		ETag:      aws.ToString(output.ETag),
+	}, nil
+}
+
+// setAttributes sets the content type, content encoding and metadata of an object to upload.
+func setAttributes(input *s3.PutObjectInput, attributes *chiv.Attributes) {
+	if attributes == nil {
+		return
+	}
+	if attributes.ContentType != "" {
+		input.ContentType = aws.String(attributes.ContentType)
+	}
+	if attributes.ContentEncoding != "" {
+		input.ContentEncoding = aws.String(attributes.ContentEncoding)
+	}
+	if len(attributes.Metadata) > 0 {
+		input.Metadata = attributes.Metadata
+	}
+}
+
+// notFound is an error for a missing object, matching chiv.ErrNotFound.
+type notFound struct {
+	err error
+}
+
+func (e *notFound) Error() string {
+	return e.err.Error()
+}
+
+func (e *notFound) Unwrap() error {
+	return e.err
+}
+
+func (e *notFound) Is(target error) bool {
+	return target == chiv.ErrNotFound
+}
+
+// translate returns an error matching chiv.ErrNotFound for a missing object, e.g. a missing checkpoint object.
+// Objects missing from GetObject and HeadObject are reported with different errors.
+func translate(err error) error {
+	var (
+		noSuchKey *types.NoSuchKey
+		missing   *types.NotFound
+	)
+	if errors.As(err, &noSuchKey) || errors.As(err, &missing) {
+		return &notFound{err: err}
+	}
+
+	return err
+}
@@ -0,0 +1,248 @@
+// +build unit
+
+package awsv2_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/require"
+
+	"gavincabbage.com/chiv"
+	"gavincabbage.com/chiv/awsv2"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestArchive(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`CREATE TABLE events (id INTEGER, name TEXT);
+		INSERT INTO events VALUES (1, 'first'), (2, 'second');`)
+	require.NoError(t, err)
+
+	bucket := newBucket()
+	client := awsv2.NewClient(bucket)
+	store := chiv.NewS3CheckpointStore(client, "bucket", "checkpoints.json")
+
+	subject := chiv.NewArchiver(db, nil, chiv.WithSink(awsv2.NewSink(infrequent{bucket}, "bucket")))
+	result, err := subject.ArchiveWithResult("events", "bucket",
+		chiv.WithVerify(client, chiv.VerifyContent),
+		chiv.WithAnnotation(),
+		chiv.WithIncremental("id", store),
+		chiv.WithSchemaSidecar(),
+	)
+	require.NoError(t, err)
+
+	require.Len(t, result.Objects, 1)
+	require.Equal(t, "https://bucket.s3.amazonaws.com/events.csv", result.Objects[0].Location)
+	require.Equal(t, "2", result.Objects[0].VersionID)
+
+	object := bucket.objects["events.csv"]
+	require.Equal(t, "id,name\n1,first\n2,second\n", string(object.data))
+	require.Equal(t, "2", object.metadata[chiv.MetadataRows])
+	require.Equal(t, result.Objects[0].Checksum, object.metadata[chiv.MetadataChecksum])
+	require.Equal(t, types.StorageClassStandardIa, object.storageClass)
+	require.Equal(t, "application/json", aws.ToString(bucket.objects["events.csv.schema.json"].contentType))
+
+	checkpoint, ok, err := store.Load(context.Background(), "events")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, "2", checkpoint)
+
+	restored, err := chiv.Restore(context.Background(), db, client, "bucket", "events.csv", "restored", chiv.WithCreateTable())
+	require.NoError(t, err)
+	require.Equal(t, int64(2), restored.Rows)
+}
+
+func TestErrors(t *testing.T) {
+	bucket := newBucket()
+	bucket.err = errors.New("uploading")
+
+	w, err := awsv2.NewSink(bucket, "bucket").Open(context.Background(), "events.csv")
+	require.NoError(t, err)
+	_, _ = w.Write([]byte("id\n"))
+	require.EqualError(t, w.Close(), "uploading")
+
+	_, _, err = awsv2.NewClient(bucket).Download(context.Background(), "bucket", "events.csv")
+	require.EqualError(t, err, "uploading")
+
+	bucket.err = nil
+	_, _, err = awsv2.NewClient(bucket).Download(context.Background(), "bucket", "missing.csv")
+	require.True(t, errors.Is(err, chiv.ErrNotFound))
+	var noSuchKey *types.NoSuchKey
+	require.True(t, errors.As(err, &noSuchKey))
+
+	_, _, err = chiv.NewS3CheckpointStore(awsv2.NewClient(bucket), "bucket", "missing.json").Load(context.Background(), "events")
+	require.NoError(t, err)
+}
+
+type object struct {
+	data            []byte
+	contentType     *string
+	contentEncoding *string
+	metadata        map[string]string
+	storageClass    types.StorageClass
+	etag            string
+	version         int
+}
+
+// bucket fakes an S3 bucket for the v2 SDK's uploader and client.
+type bucket struct {
+	mu      sync.Mutex
+	objects map[string]*object
+	version int
+	err     error
+}
+
+func newBucket() *bucket {
+	return &bucket{objects: make(map[string]*object)}
+}
+
+func (b *bucket) put(input *s3.PutObjectInput) (*object, error) {
+	data, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.version++
+	o := &object{
+		data:            data,
+		contentType:     input.ContentType,
+		contentEncoding: input.ContentEncoding,
+		metadata:        input.Metadata,
+		storageClass:    input.StorageClass,
+		etag:            `"etag"`,
+		version:         b.version,
+	}
+	b.objects[aws.ToString(input.Key)] = o
+
+	return o, nil
+}
+
+func (b *bucket) get(key *string) (*object, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.err != nil {
+		return nil, b.err
+	}
+	o, ok := b.objects[aws.ToString(key)]
+	if !ok {
+		return nil, &types.NoSuchKey{Message: aws.String("missing")}
+	}
+
+	return o, nil
+}
+
+func (b *bucket) Upload(_ context.Context, input *s3.PutObjectInput, _ ...func(*manager.Uploader)) (*manager.UploadOutput, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	o, err := b.put(input)
+	if err != nil {
+		return nil, err
+	}
+
+	return &manager.UploadOutput{
+		Location:  "https://bucket.s3.amazonaws.com/" + aws.ToString(input.Key),
+		VersionID: aws.String(fmt.Sprint(o.version)),
+		ETag:      aws.String(`"etag"`),
+	}, nil
+}
+
+func (b *bucket) GetObject(_ context.Context, input *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	o, err := b.get(input.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3.GetObjectOutput{
+		Body:            ioutil.NopCloser(bytes.NewReader(o.data)),
+		ContentLength:   int64(len(o.data)),
+		ContentType:     o.contentType,
+		ContentEncoding: o.contentEncoding,
+		Metadata:        o.metadata,
+	}, nil
+}
+
+func (b *bucket) HeadObject(_ context.Context, input *s3.HeadObjectInput, _ ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	o, err := b.get(input.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3.HeadObjectOutput{
+		ContentLength:   int64(len(o.data)),
+		ContentType:     o.contentType,
+		ContentEncoding: o.contentEncoding,
+		Metadata:        o.metadata,
+		StorageClass:    o.storageClass,
+		ETag:            aws.String(o.etag),
+	}, nil
+}
+
+func (b *bucket) CopyObject(_ context.Context, input *s3.CopyObjectInput, _ ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	o, err := b.get(input.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if input.MetadataDirective != types.MetadataDirectiveReplace || input.TaggingDirective != types.TaggingDirectiveCopy {
+		return nil, errors.New("unexpected directive")
+	}
+	if aws.ToString(input.CopySourceIfMatch) != o.etag {
+		return nil, errors.New("precondition failed")
+	}
+	b.version++
+	o.contentType = input.ContentType
+	o.contentEncoding = input.ContentEncoding
+	o.metadata = input.Metadata
+	o.storageClass = input.StorageClass
+	o.etag = `"copied"`
+	o.version = b.version
+
+	return &s3.CopyObjectOutput{
+		VersionId:        aws.String(fmt.Sprint(o.version)),
+		CopyObjectResult: &types.CopyObjectResult{ETag: aws.String(o.etag)},
+	}, nil
+}
+
+func (b *bucket) PutObject(_ context.Context, input *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	o, err := b.put(input)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3.PutObjectOutput{VersionId: aws.String(fmt.Sprint(o.version))}, nil
+}
+
+// infrequent uploads objects to a bucket in the infrequent access storage class.
+type infrequent struct {
+	*bucket
+}
+
+func (u infrequent) Upload(ctx context.Context, input *s3.PutObjectInput, opts ...func(*manager.Uploader)) (*manager.UploadOutput, error) {
+	input.StorageClass = types.StorageClassStandardIa
+	return u.bucket.Upload(ctx, input, opts...)
+}
@@ -3,13 +3,18 @@ package chiv
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"path"
 	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -18,11 +23,33 @@ type Database interface {
 	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
 }
 
-// Uploader uploads input to S3.
+// Uploader uploads objects to S3 buckets. The awsv1 and awsv2 packages adapt the uploaders of the
+// v1 and v2 AWS SDKs to Uploader.
 type Uploader interface {
-	UploadWithContext(ctx aws.Context, input *s3manager.UploadInput, opts ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error)
+	// Upload an object read from r until EOF with the given attributes, and describe it.
+	Upload(ctx context.Context, bucket, key string, attributes *Attributes, r io.Reader) (*ObjectInfo, error)
 }
 
+// Downloader downloads objects from S3 buckets. The awsv1 and awsv2 packages adapt the S3 clients of the
+// v1 and v2 AWS SDKs to Downloader.
+type Downloader interface {
+	// Download an object as it is stored, without any transport compression, returning its body and
+	// attributes. Errors for objects that do not exist must match ErrNotFound with errors.Is.
+	Download(ctx context.Context, bucket, key string) (io.ReadCloser, *Attributes, error)
+}
+
+// Verifier reads back uploaded objects to verify them, and annotates them if configured WithAnnotation.
+type Verifier interface {
+	Downloader
+	// Stat returns the size and attributes of an object.
+	Stat(ctx context.Context, bucket, key string) (int64, *Attributes, error)
+	// Annotate replaces the attributes of an object, keeping its content and other properties, and describes it.
+	Annotate(ctx context.Context, bucket, key string, attributes *Attributes) (*ObjectInfo, error)
+}
+
+// ErrNotFound is matched by the errors of Downloaders for objects that do not exist.
+var ErrNotFound = errors.New("object not found")
+
 // Archive a database table to S3.
 func Archive(db Database, s3 Uploader, table, bucket string, options ...Option) error {
 	return ArchiveWithContext(context.Background(), db, s3, table, bucket, options...)
@@ -53,27 +80,71 @@ func ArchiveRowsWithContext(ctx context.Context, rows Rows, s3 Uploader, bucket
 
 // Archiver archives database tables to Amazon S3.
 type Archiver struct {
-	db        Database
-	s3        Uploader
-	format    FormatterFunc
-	key       string
-	extension string
-	null      []byte
-	columns   []string
+	db          Database
+	s3          Uploader
+	format      FormatterFunc
+	key         string
+	extension   string
+	null        []byte
+	columns     []string
+	codec       Codec
+	level       int
+	wrapper     KeyWrapper
+	maxRows     int64
+	maxBytes    int64
+	partition   []string
+	maxOpen     int
+	keyTemplate string
+	template    *template.Template
+	formatExt   string
+	start       time.Time
+	runID       string
+	incremental string
+	checkpoints CheckpointStore
+	checkpoint  []byte
+	where       string
+	whereArgs   []interface{}
+	orderBy     []string
+	limit       int64
+	dialect     Dialect
+	concurrency int
+	spool       *spool
+	parallelism int
+	splitColumn string
+	parts       bool
+	numbers     *partNumbers
+	batchSize   int64
+	batchKeys   []string
+	results     *results
+	hooks       Hooks
+	parser      ParserFunc
+	createTable bool
+	sidecar     bool
+	sink        Sink
+	verifier    Verifier
+	verifyBy    Verification
+	annotation  bool
 }
 
 // NewArchiver constructs an archiver with the given Database, S3 uploader and options.
 // Options set on creation apply to all calls to Archive unless overridden.
+// The uploader may be nil if objects are written to a Sink configured WithSink.
 func NewArchiver(db Database, s3 Uploader, options ...Option) *Archiver {
 	a := Archiver{
-		db:     db,
-		s3:     s3,
-		format: CSV,
+		db:          db,
+		s3:          s3,
+		format:      CSV,
+		level:       DefaultCompression,
+		maxOpen:     defaultMaxOpenPartitions,
+		concurrency: defaultTableConcurrency,
 	}
 
 	for _, option := range options {
 		option(&a)
 	}
+	if a.dialect == nil {
+		a.dialect = dialectOf(db)
+	}
 
 	return &a
 }
@@ -84,23 +155,55 @@ func (a *Archiver) Archive(table, bucket string, options ...Option) error {
 }
 
 // ArchiveWithContext is like Archive, with context. Any options provided override those set on creation.
-func (a *Archiver) ArchiveWithContext(ctx context.Context, table, bucket string, options ...Option) (err error) {
+func (a *Archiver) ArchiveWithContext(ctx context.Context, table, bucket string, options ...Option) error {
+	_, err := a.ArchiveWithResultWithContext(ctx, table, bucket, options...)
+	return err
+}
+
+// ArchiveWithResult is like Archive, returning a Result reporting the rows archived and objects uploaded.
+func (a *Archiver) ArchiveWithResult(table, bucket string, options ...Option) (*Result, error) {
+	return a.ArchiveWithResultWithContext(context.Background(), table, bucket, options...)
+}
+
+// ArchiveWithResultWithContext is like ArchiveWithResult, with context.
+func (a *Archiver) ArchiveWithResultWithContext(ctx context.Context, table, bucket string, options ...Option) (result *Result, err error) {
 	b := *a
 	for _, option := range options {
 		option(&b)
 	}
+	b.results = newResults(table)
+	if err := b.parseKeyTemplate(); err != nil {
+		return nil, errorf("parsing key template: %w", err)
+	}
 
-	rows, err := b.query(ctx, table)
+	if b.parallelism > 1 {
+		if err := b.archiveParallel(ctx, table, bucket); err != nil {
+			return nil, err
+		}
+		return b.complete(ctx), nil
+	}
+
+	rows, err := b.query(b.hooks.query(ctx, table, bucket), table)
 	if err != nil {
-		return errorf("querying '%s': %w", table, err)
+		return nil, b.fail(ctx, table, StageQuery, errorf("querying '%s': %w", table, err))
 	}
 	defer func() {
 		if e := rows.Close(); e != nil && err == nil {
-			err = errorf("closing rows: %w", e)
+			result, err = nil, b.fail(ctx, table, StageQuery, errorf("closing rows: %w", e))
 		}
 	}()
 
-	return b.archive(ctx, rows, table, bucket)
+	if err := b.archive(ctx, rows, table, bucket); err != nil {
+		return nil, err
+	}
+
+	if b.checkpoints != nil && b.checkpoint != nil {
+		if err := b.checkpoints.Save(ctx, table, string(b.checkpoint)); err != nil {
+			return nil, b.fail(ctx, table, StageCheckpoint, errorf("saving checkpoint: %w", err))
+		}
+	}
+
+	return b.complete(ctx), nil
 }
 
 // ArchiveRows to S3.
@@ -109,54 +212,245 @@ func (a *Archiver) ArchiveRows(rows Rows, bucket string, options ...Option) (err
 }
 
 // ArchiveRowsWithContext is like ArchiveRows, with context.
-func (a *Archiver) ArchiveRowsWithContext(ctx context.Context, rows Rows, bucket string, options ...Option) (err error) {
+func (a *Archiver) ArchiveRowsWithContext(ctx context.Context, rows Rows, bucket string, options ...Option) error {
+	_, err := a.ArchiveRowsWithResultWithContext(ctx, rows, bucket, options...)
+	return err
+}
+
+// ArchiveRowsWithResult is like ArchiveRows, returning a Result reporting the rows archived and objects uploaded.
+func (a *Archiver) ArchiveRowsWithResult(rows Rows, bucket string, options ...Option) (*Result, error) {
+	return a.ArchiveRowsWithResultWithContext(context.Background(), rows, bucket, options...)
+}
+
+// ArchiveRowsWithResultWithContext is like ArchiveRowsWithResult, with context.
+func (a *Archiver) ArchiveRowsWithResultWithContext(ctx context.Context, rows Rows, bucket string, options ...Option) (*Result, error) {
 	b := *a
 	for _, option := range options {
 		option(&b)
 	}
+	b.results = newResults("")
 
-	return b.archive(ctx, rows, "", bucket)
+	if err := b.archive(ctx, rows, "", bucket); err != nil {
+		return nil, err
+	}
+
+	return b.complete(ctx), nil
+}
+
+func (a *Archiver) archive(ctx context.Context, rows Rows, table, bucket string) error {
+	columns, err := a.prepare(rows)
+	if err != nil {
+		return a.fail(ctx, table, StageDownload, err)
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		return a.download(gctx, g, rows, columns, table, bucket)
+	})
+
+	return g.Wait()
 }
 
-func (a *Archiver) archive(ctx context.Context, rows Rows, table, bucket string) (err error) {
-	columns, err := interfaced(rows.ColumnTypes())
+// prepare an archive of rows, returning their columns.
+func (a *Archiver) prepare(rows Rows) (columns []Column, err error) {
+	columns, err = interfaced(rows.ColumnTypes())
 	if err != nil {
-		return errorf("getting column types from rows: %w", err)
+		return nil, errorf("getting column types from rows: %w", err)
+	}
+	for i := range columns {
+		columns[i] = dialectColumn{Column: columns[i], dialect: a.dialect}
+	}
+
+	if err := a.parseKeyTemplate(); err != nil {
+		return nil, errorf("parsing key template: %w", err)
+	}
+	if a.runID, err = newRunID(); err != nil {
+		return nil, errorf("generating run ID: %w", err)
 	}
+	a.start = time.Now().UTC()
+
+	return columns, nil
+}
+
+// object is a single archive object being formatted and uploaded.
+type object struct {
+	formatter Formatter
+	w         io.WriteCloser
+	pipe      *io.PipeWriter
+	counter   *counter
+	formatted *counter
+	null      []byte
+	rows      int64
+	result    *ObjectResult
+}
 
+// open an object, starting its upload in the archive's group. Formatted data is written through
+// the compressor and encrypter, if configured, to a pipe read by the upload.
+func (s *objects) open(partition string, part int) (*object, error) {
 	var (
-		r, w      = io.Pipe()
-		formatter = a.format(w, columns)
-		g, gctx   = errgroup.WithContext(ctx)
+		a        = s.archiver
+		r, pw    = io.Pipe()
+		uploaded = &counter{WriteCloser: pw}
 	)
-	if extensioner, ok := formatter.(Extensioner); ok && a.extension == "" {
-		a.extension = extensioner.Extension()
+	w, metadata, err := a.encrypt(s.ctx, uploaded)
+	if err != nil {
+		return nil, errorf("opening encrypter: %w", err)
 	}
-	g.Go(func() error {
-		return a.download(gctx, rows, columns, formatter, w)
-	})
-	g.Go(func() error {
-		return a.upload(gctx, r, table, bucket)
+	w, err = a.compress(w)
+	if err != nil {
+		return nil, errorf("opening compressor: %w", err)
+	}
+
+	formatted := &counter{WriteCloser: w, total: &a.results.bytes}
+	o := &object{
+		formatter: a.format(formatted, s.columns),
+		w:         w,
+		pipe:      pw,
+		counter:   uploaded,
+		formatted: formatted,
+		null:      a.null,
+	}
+	if preserver, ok := o.formatter.(NullPreserver); ok && preserver.PreserveNull() {
+		o.null = nil
+	}
+	if extensioner, ok := o.formatter.(Extensioner); ok && a.formatExt == "" {
+		a.formatExt = extensioner.Extension()
+		if a.extension == "" {
+			a.extension = a.formatExt
+		}
+	}
+
+	key, err := a.objectKey(s.table, partition, part)
+	if err != nil {
+		o.abort(err)
+		return nil, errorf("executing key template: %w", err)
+	}
+	o.result = a.results.add(s.bucket, key, a.extension)
+	ctx := a.hooks.formatterOpen(s.ctx, s.table, key)
+	s.g.Go(func() error {
+		if err := a.upload(ctx, r, o.result, metadata); err != nil {
+			return err
+		}
+		if a.verifier != nil {
+			if err := a.verify(ctx, o.result); err != nil {
+				return err
+			}
+		}
+		if a.sidecar {
+			return a.uploadSchema(ctx, s.table, s.columns, o.result)
+		}
+		return nil
 	})
 
-	return g.Wait()
+	if err := o.formatter.Open(); err != nil {
+		o.abort(err)
+		return nil, errorf("downloading: opening formatter: %w", err)
+	}
+
+	return o, nil
+}
+
+// close the object's formatter and writers, completing its upload.
+func (o *object) close() error {
+	if err := o.formatter.Close(); err != nil {
+		o.abort(err)
+		return errorf("downloading: closing formatter: %w", err)
+	}
+	o.result.Rows = o.rows
+	o.result.Bytes = o.formatted.n
+
+	if err := o.w.Close(); err != nil {
+		o.abort(err)
+		return errorf("downloading: closing writer: %w", err)
+	}
+
+	return nil
 }
 
-func (a *Archiver) download(ctx context.Context, rows Rows, columns []Column, formatter Formatter, w io.WriteCloser) (err error) {
+// abort the object's upload with the given error.
+func (o *object) abort(err error) {
+	_ = o.pipe.CloseWithError(err)
+}
+
+// full reports whether the object has reached the configured row or byte limits.
+func (a *Archiver) full(o *object) bool {
+	return (a.maxRows > 0 && o.rows >= a.maxRows) || (a.maxBytes > 0 && o.counter.n >= a.maxBytes)
+}
+
+func (a *Archiver) encrypt(ctx context.Context, w io.WriteCloser) (io.WriteCloser, map[string]string, error) {
+	if a.wrapper == nil {
+		return w, nil, nil
+	}
+
+	e, metadata, err := newEncrypter(ctx, w, a.wrapper)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &chain{WriteCloser: e, next: w}, metadata, nil
+}
+
+func (a *Archiver) compress(w io.WriteCloser) (io.WriteCloser, error) {
+	if a.codec == nil {
+		return w, nil
+	}
+
+	c, err := a.codec.NewWriter(w, a.level)
+	if err != nil {
+		return nil, err
+	}
+
+	return &chain{WriteCloser: c, next: w}, nil
+}
+
+func (a *Archiver) download(ctx context.Context, g *errgroup.Group, rows Rows, columns []Column, table, bucket string) (err error) {
+	stage := StageDownload
 	defer func() {
-		if e := w.Close(); e != nil && err == nil {
-			err = errorf("downloading: closing writer: %w", e)
+		a.results.downloaded()
+		if err != nil {
+			a.fail(ctx, table, stage, err)
 		}
 	}()
+	a.results.starting(func() {
+		a.hooks.start(ctx, table, columns)
+	})
+
+	partitioned, data, err := a.partitionColumns(columns)
+	if err != nil {
+		return errorf("downloading: %w", err)
+	}
 
-	if err := formatter.Open(); err != nil {
-		return errorf("downloading: opening formatter: %w", err)
+	if a.spool != nil {
+		if err := a.spool.resolve(columns); err != nil {
+			return errorf("downloading: %w", err)
+		}
+	}
+
+	incremental := -1
+	if a.checkpoints != nil {
+		if incremental = columnIndex(columns, a.incremental); incremental < 0 {
+			return errorf("downloading: incremental column '%s' not found", a.incremental)
+		}
+	}
+
+	objects := a.newObjects(ctx, g, project(columns, data), table, bucket)
+	defer func() {
+		if err != nil {
+			objects.abort(err)
+		}
+	}()
+	if len(partitioned) == 0 {
+		if _, err := objects.get(""); err != nil {
+			stage = StageFormat
+			return err
+		}
 	}
 
 	var (
 		rawBytes = make([]sql.RawBytes, len(columns))
 		scanned  = make([]interface{}, len(columns))
-		record   = make([][]byte, len(columns))
+		record   = make([][]byte, len(data))
+		progress = a.newProgress(ctx, table)
 	)
 	for i := range rawBytes {
 		scanned[i] = &rawBytes[i]
@@ -165,82 +459,322 @@ func (a *Archiver) download(ctx context.Context, rows Rows, columns []Column, fo
 	for rows.Next() {
 		select {
 		case <-ctx.Done():
+			objects.abort(ctx.Err())
 			return nil
 		default:
 			err = rows.Scan(scanned...)
 			if err != nil {
+				stage = StageScan
 				return errorf("downloading: scanning row: %w", err)
 			}
 
-			for i, raw := range rawBytes {
-				if raw == nil && a.null != nil {
-					record[i] = a.null
+			o, err := objects.get(partition(columns, partitioned, rawBytes))
+			if err != nil {
+				stage = StageFormat
+				return err
+			}
+
+			for i, j := range data {
+				if raw := rawBytes[j]; raw == nil && o.null != nil {
+					record[i] = o.null
 				} else {
 					record[i] = raw
 				}
 			}
 
-			if err := formatter.Format(record); err != nil {
+			if err := o.formatter.Format(record); err != nil {
+				stage = StageFormat
 				return errorf("downloading: formatting row: %w", err)
 			}
+			o.rows++
+			progress.row()
+
+			if incremental >= 0 && rawBytes[incremental] != nil {
+				a.checkpoint = append(a.checkpoint[:0], rawBytes[incremental]...)
+			}
+
+			if a.spool != nil {
+				if err := a.spool.write(rawBytes); err != nil {
+					return errorf("downloading: spooling purge keys: %w", err)
+				}
+			}
 		}
 	}
 
 	if err := rows.Err(); err != nil {
+		stage = StageScan
 		return errorf("downloading: scanning rows: %w", err)
 	}
 
-	if err := formatter.Close(); err != nil {
-		return errorf("downloading: closing formatter: %w", err)
+	if err := objects.close(); err != nil {
+		stage = StageFormat
+		return err
 	}
+	progress.report()
 
 	return nil
 }
 
-func (a *Archiver) query(ctx context.Context, table string) (*sql.Rows, error) {
-	columns := "*"
-	if len(a.columns) > 0 {
-		var b strings.Builder
-		for i, column := range a.columns {
-			b.WriteString(column)
-			if i < len(a.columns)-1 {
-				b.WriteString(", ")
+func (a *Archiver) query(ctx context.Context, table string) (cursor, error) {
+	source, err := a.validate(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.queryRange(ctx, source, nil)
+}
+
+// queryRange queries the rows of a table, restricted to a range of the split column if given.
+func (a *Archiver) queryRange(ctx context.Context, table string, r *splitRange) (cursor, error) {
+	var (
+		conditions []string
+		order      []string
+		args       = append([]interface{}{}, a.whereArgs...)
+	)
+	if a.where != "" {
+		conditions = append(conditions, fmt.Sprintf("(%s)", a.bind(a.where)))
+	}
+	if a.checkpoints != nil {
+		checkpoint, ok, err := a.checkpoints.Load(ctx, table)
+		if err != nil {
+			return nil, fmt.Errorf("loading checkpoint: %w", err)
+		}
+		if ok {
+			args = append(args, checkpoint)
+			conditions = append(conditions, fmt.Sprintf("%s > %s", a.dialect.Quote(a.incremental), a.dialect.Placeholder(len(args))))
+		}
+		order = append(order, a.dialect.Quote(a.incremental))
+	}
+	if r != nil {
+		var condition string
+		if condition, args = a.rangeCondition(r, args); condition != "" {
+			conditions = append(conditions, condition)
+		}
+	}
+	order = append(order, a.orderBy...)
+
+	if a.batchSize > 0 {
+		return a.batches(ctx, table, conditions, args)
+	}
+
+	query := a.selectQuery(table, a.columns, conditions, order)
+	if a.limit > 0 {
+		args = append(args, a.limit)
+		query = a.dialect.Limit(query, a.dialect.Placeholder(len(args)))
+	}
+
+	return a.db.QueryContext(ctx, query+";", args...)
+}
+
+// selectQuery builds a query selecting columns of a table, or all columns if none are given.
+func (a *Archiver) selectQuery(table string, columns, conditions, order []string) string {
+	selected := "*"
+	if len(columns) > 0 {
+		quoted := make([]string, len(columns))
+		for i, column := range columns {
+			quoted[i] = a.dialect.Quote(column)
+		}
+		selected = strings.Join(quoted, ", ")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "SELECT %s FROM %s", selected, a.dialect.Quote(table))
+	if len(conditions) > 0 {
+		fmt.Fprintf(&b, " WHERE %s", strings.Join(conditions, " AND "))
+	}
+	if len(order) > 0 {
+		fmt.Fprintf(&b, " ORDER BY %s", strings.Join(order, ", "))
+	}
+
+	return b.String()
+}
+
+// cursor is Rows that must be closed, such as *sql.Rows.
+type cursor interface {
+	Rows
+	Close() error
+}
+
+// bind rewrites the ? placeholders of a clause to the driver's placeholder style,
+// ignoring any within quoted strings and identifiers.
+func (a *Archiver) bind(clause string) string {
+	var (
+		b     strings.Builder
+		n     int
+		quote rune
+	)
+	for _, r := range clause {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
 			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '?':
+			n++
+			b.WriteString(a.dialect.Placeholder(n))
+			continue
 		}
-		columns = b.String()
+		b.WriteRune(r)
 	}
 
-	query := fmt.Sprintf(`SELECT %s FROM %s;`, columns, table)
-	return a.db.QueryContext(ctx, query)
+	return b.String()
 }
 
-func (a *Archiver) upload(ctx context.Context, r io.ReadCloser, table string, bucket string) (err error) {
+func (a *Archiver) upload(ctx context.Context, r io.ReadCloser, result *ObjectResult, metadata map[string]string) (err error) {
+	body := &abortReader{Reader: r}
 	defer func() {
+		// The failure is reported before the reader is closed, which fails the download writing to it.
+		if err != nil && body.err == nil {
+			a.fail(ctx, a.results.table, StageUpload, err)
+		}
 		if e := r.Close(); e != nil && err == nil {
-			err = errorf("uploading: closing reader: %w", e)
+			err = a.fail(ctx, a.results.table, StageUpload, errorf("uploading: closing reader: %w", e))
 		}
 	}()
 
+	attributes := &Attributes{Metadata: metadata}
+	if a.codec != nil && a.wrapper == nil {
+		attributes.ContentEncoding = a.codec.ContentEncoding()
+	}
+
+	h := sha256.New()
+	a.results.uploading()
+	info, n, err := a.write(ctx, result.Bucket, result.Key, attributes, io.TeeReader(body, h))
+	if body.err != nil {
+		// The object was aborted by its download, which reports the error.
+		return body.err
+	} else if err != nil {
+		return errorf("uploading: %w", err)
+	}
+	result.UploadedBytes = n
+	result.Checksum = hex.EncodeToString(h.Sum(nil))
+	a.results.uploaded(result, info)
+	a.hooks.uploadPart(ctx, a.results.table, result)
+
+	return nil
+}
+
+// write an object read from r to the configured sink, or else upload it to the bucket, returning its
+// description and size. The object is discarded if it can't be read completely.
+func (a *Archiver) write(ctx context.Context, bucket, key string, attributes *Attributes, r io.Reader) (*ObjectInfo, int64, error) {
+	sink := a.sink
+	if sink == nil && a.s3 == nil {
+		return nil, 0, fmt.Errorf("no uploader or sink configured")
+	} else if sink == nil {
+		sink = NewUploaderSink(a.s3, bucket)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w, err := openObject(ctx, sink, key, attributes)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	n, err := io.Copy(w, r)
+	if err != nil {
+		cancel()
+		_ = w.Close()
+		return nil, n, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, n, err
+	}
+
+	var info ObjectInfo
+	if i, ok := w.(InfoWriter); ok {
+		info = i.Info()
+	}
+
+	return &info, n, nil
+}
+
+// objectKey returns the key of the given part of a partition. Unpartitioned archives
+// are only numbered when they may be split into multiple objects.
+func (a *Archiver) objectKey(table, partition string, part int) (string, error) {
 	if table == "" {
 		table = "table"
 	}
-	if a.key == "" {
-		if a.extension != "" {
-			a.key = fmt.Sprintf("%s.%s", table, a.extension)
+	if a.template != nil {
+		return a.templateKey(table, partition, part)
+	}
+
+	extension := ""
+	if a.extension != "" {
+		extension = "." + a.extension
+	}
+	if a.codec != nil {
+		extension = fmt.Sprintf("%s.%s", extension, a.codec.Extension())
+	}
+
+	if partition != "" {
+		prefix := a.key
+		if prefix == "" {
+			prefix = table
+		}
+		return fmt.Sprintf("%s/%s/part-%04d%s", strings.TrimSuffix(prefix, "/"), partition, part, extension), nil
+	}
+
+	key := a.key
+	if key == "" {
+		key = table + extension
+	}
+
+	if a.maxRows > 0 || a.maxBytes > 0 || a.numbers != nil {
+		dir, file := path.Split(key)
+		if i := strings.Index(file, "."); i >= 0 {
+			key = fmt.Sprintf("%s%s-%05d%s", dir, file[:i], part, file[i:])
 		} else {
-			a.key = table
+			key = fmt.Sprintf("%s-%05d", key, part)
 		}
 	}
 
-	if _, err := a.s3.UploadWithContext(ctx, &s3manager.UploadInput{
-		Body:   r,
-		Bucket: aws.String(bucket),
-		Key:    aws.String(a.key),
-	}); err != nil {
-		return errorf("uploading: %w", err)
+	return key, nil
+}
+
+// counter counts bytes written to the underlying writer, adding them to a shared total if set.
+type counter struct {
+	io.WriteCloser
+	n     int64
+	total *int64
+}
+
+func (c *counter) Write(p []byte) (int, error) {
+	n, err := c.WriteCloser.Write(p)
+	c.n += int64(n)
+	if c.total != nil {
+		atomic.AddInt64(c.total, int64(n))
 	}
+	return n, err
+}
 
-	return nil
+// readCounter counts bytes read from the underlying reader.
+type readCounter struct {
+	io.Reader
+	n int64
+}
+
+func (c *readCounter) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// abortReader records the error aborting the object being read, if any.
+type abortReader struct {
+	io.Reader
+	err error
+}
+
+func (r *abortReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
 }
 
 func interfaced(in []*sql.ColumnType, err error) ([]Column, error) {
@@ -0,0 +1,277 @@
+package chiv
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"regexp"
+	"strconv"
+
+	"github.com/golang/snappy"
+)
+
+// AvroCodec is an Avro object container file block compression codec.
+type AvroCodec string
+
+// Avro codecs supported by the Avro formatter.
+const (
+	AvroNull    AvroCodec = "null"
+	AvroDeflate AvroCodec = "deflate"
+	AvroSnappy  AvroCodec = "snappy"
+)
+
+const (
+	avroMagic     = "Obj\x01"
+	avroBlockSize = 64 << 10
+)
+
+type avroField struct {
+	Name string      `json:"name"`
+	Type interface{} `json:"type"`
+}
+
+type avroSchema struct {
+	Type   string      `json:"type"`
+	Name   string      `json:"name"`
+	Fields []avroField `json:"fields"`
+}
+
+var avroInvalid = regexp.MustCompile("[^A-Za-z0-9_]")
+
+// avroName sanitizes s into a valid Avro name.
+func avroName(s string) string {
+	s = avroInvalid.ReplaceAllString(s, "_")
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		s = "_" + s
+	}
+
+	return s
+}
+
+// avroNames sanitizes column names into unique Avro names. Valid names are kept where possible,
+// and sanitized names that would collide are given a numeric suffix, e.g. "a-b" and "a_b" become
+// "a_b_2" and "a_b".
+func avroNames(columns []Column) []string {
+	var (
+		names = make([]string, len(columns))
+		taken = make(map[string]bool)
+	)
+	for i, column := range columns {
+		if name := column.Name(); avroName(name) == name && !taken[name] {
+			names[i], taken[name] = name, true
+		}
+	}
+	for i, column := range columns {
+		if names[i] != "" {
+			continue
+		}
+		base := avroName(column.Name())
+		name := base
+		for n := 2; taken[name]; n++ {
+			name = fmt.Sprintf("%s_%d", base, n)
+		}
+		names[i], taken[name] = name, true
+	}
+
+	return names
+}
+
+// validAvroCodec reports whether the Avro formatter implements a codec.
+func validAvroCodec(codec AvroCodec) bool {
+	switch codec {
+	case AvroNull, AvroDeflate, AvroSnappy:
+		return true
+	}
+
+	return false
+}
+
+// avroDecimal is the Avro decimal logical type, annotating bytes holding an unscaled value.
+type avroDecimal struct {
+	Type        string `json:"type"`
+	LogicalType string `json:"logicalType"`
+	Precision   int    `json:"precision"`
+	Scale       int    `json:"scale"`
+}
+
+// avroColumn describes how one column is encoded. Decimal columns have a precision, and store
+// their unscaled values.
+type avroColumn struct {
+	kind      kind
+	nullable  bool
+	precision int
+	scale     int
+}
+
+func avroColumns(columns []Column) []avroColumn {
+	out := make([]avroColumn, len(columns))
+	for i, column := range columns {
+		out[i] = avroColumn{
+			kind:     kindOf(column),
+			nullable: true,
+		}
+		if precision, scale, ok := decimalOf(column); ok {
+			out[i].kind = text
+			out[i].precision, out[i].scale = precision, scale
+		}
+		if n, ok := column.(interface{ Nullable() (bool, bool) }); ok {
+			if nullable, ok := n.Nullable(); ok {
+				out[i].nullable = nullable
+			}
+		}
+	}
+
+	return out
+}
+
+func buildAvroSchema(columns []Column, types []avroColumn) ([]byte, error) {
+	var (
+		schema = avroSchema{
+			Type:   "record",
+			Name:   "chiv",
+			Fields: make([]avroField, len(columns)),
+		}
+		names = avroNames(columns)
+	)
+	for i := range columns {
+		var t interface{}
+		switch types[i].kind {
+		case boolean:
+			t = "boolean"
+		case integer:
+			t = "long"
+		case float:
+			t = "double"
+		default:
+			t = "string"
+		}
+		if types[i].precision > 0 {
+			t = avroDecimal{
+				Type:        "bytes",
+				LogicalType: "decimal",
+				Precision:   types[i].precision,
+				Scale:       types[i].scale,
+			}
+		}
+
+		schema.Fields[i] = avroField{
+			Name: names[i],
+			Type: t,
+		}
+		if types[i].nullable {
+			schema.Fields[i].Type = []interface{}{"null", t}
+		}
+	}
+
+	return json.Marshal(schema)
+}
+
+// encode appends the Avro binary encoding of v to b.
+func (c avroColumn) encode(b *bytes.Buffer, v []byte) error {
+	if c.nullable {
+		if v == nil {
+			writeVarint(b, 0)
+			return nil
+		}
+		writeVarint(b, 1)
+	} else if v == nil {
+		return fmt.Errorf("null value in non-nullable column")
+	}
+
+	s := string(v)
+	if c.precision > 0 {
+		n, err := unscaled(s, c.scale)
+		if err != nil {
+			return err
+		}
+		v = twosComplement(n)
+	}
+
+	switch c.kind {
+	case boolean:
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		if v {
+			b.WriteByte(1)
+		} else {
+			b.WriteByte(0)
+		}
+	case integer:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		writeVarint(b, n)
+	case float:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(n))
+		b.Write(buf[:])
+	default:
+		writeVarint(b, int64(len(v)))
+		b.Write(v)
+	}
+
+	return nil
+}
+
+func avroHeader(schema []byte, codec AvroCodec, sync []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString(avroMagic)
+	writeVarint(&b, 2)
+	for _, kv := range [][2][]byte{
+		{[]byte("avro.schema"), schema},
+		{[]byte("avro.codec"), []byte(codec)},
+	} {
+		writeVarint(&b, int64(len(kv[0])))
+		b.Write(kv[0])
+		writeVarint(&b, int64(len(kv[1])))
+		b.Write(kv[1])
+	}
+	writeVarint(&b, 0)
+	b.Write(sync)
+
+	return b.Bytes()
+}
+
+func avroCompress(codec AvroCodec, data []byte) ([]byte, error) {
+	switch codec {
+	case AvroNull:
+		return data, nil
+	case AvroDeflate:
+		var b bytes.Buffer
+		w, err := flate.NewWriter(&b, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	case AvroSnappy:
+		out := snappy.Encode(nil, data)
+		var checksum [4]byte
+		binary.BigEndian.PutUint32(checksum[:], crc32.ChecksumIEEE(data))
+		return append(out, checksum[:]...), nil
+	}
+
+	return nil, fmt.Errorf("unsupported avro codec '%s'", codec)
+}
+
+func writeVarint(b *bytes.Buffer, v int64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], v)
+	b.Write(buf[:n])
+}
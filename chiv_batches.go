@@ -0,0 +1,225 @@
+package chiv
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// batches reads the rows of a table in keyset-paginated batches, each read by its own query
+// ordered by the batch key columns and starting after the last key of the previous batch.
+// Key columns that are not archived are selected after the archived columns and hidden.
+type batches struct {
+	archiver   *Archiver
+	ctx        context.Context
+	table      string
+	columns    []string
+	conditions []string
+	args       []interface{}
+	types      []*sql.ColumnType
+	keys       []int
+	rows       *sql.Rows
+	raw        []sql.RawBytes
+	scanned    []interface{}
+	after      []interface{}
+	size       int64
+	n          int64
+	total      int64
+	err        error
+}
+
+// batches queries the first batch of a table's rows with the given conditions and their args.
+func (a *Archiver) batches(ctx context.Context, table string, conditions []string, args []interface{}) (*batches, error) {
+	if len(a.batchKeys) == 0 {
+		return nil, fmt.Errorf("batching requires key columns")
+	}
+	if len(a.orderBy) > 0 {
+		return nil, fmt.Errorf("batching cannot be used with an order")
+	}
+	if a.checkpoints != nil && a.batchKeys[0] != a.incremental {
+		return nil, fmt.Errorf("batching with incremental archival requires the incremental column '%s' as the first key", a.incremental)
+	}
+
+	var (
+		columns = a.columns
+		hidden  int
+	)
+	if len(columns) > 0 {
+		for _, key := range a.batchKeys {
+			if !contains(columns, key) {
+				columns = append(columns[:len(columns):len(columns)], key)
+				hidden++
+			}
+		}
+	}
+
+	b := &batches{
+		archiver:   a,
+		ctx:        ctx,
+		table:      table,
+		columns:    columns,
+		conditions: conditions,
+		args:       args,
+	}
+	if err := b.query(); err != nil {
+		return nil, err
+	}
+
+	types, err := b.rows.ColumnTypes()
+	if err != nil {
+		_ = b.rows.Close()
+		return nil, err
+	}
+	b.types = types[:len(types)-hidden]
+
+	b.keys = make([]int, len(a.batchKeys))
+	for i, key := range a.batchKeys {
+		b.keys[i] = -1
+		for j, t := range types {
+			if t.Name() == key {
+				b.keys[i] = j
+			}
+		}
+		if b.keys[i] < 0 {
+			_ = b.rows.Close()
+			return nil, fmt.Errorf("batch key column '%s' not found", key)
+		}
+	}
+
+	b.raw = make([]sql.RawBytes, len(types))
+	b.scanned = make([]interface{}, len(types))
+	for i := range b.raw {
+		b.scanned[i] = &b.raw[i]
+	}
+
+	return b, nil
+}
+
+// query the next batch, after the last key of the previous batch if any.
+func (b *batches) query() (err error) {
+	var (
+		a          = b.archiver
+		conditions = b.conditions
+		args       = append([]interface{}{}, b.args...)
+		order      = make([]string, len(a.batchKeys))
+	)
+	for i, key := range a.batchKeys {
+		order[i] = a.dialect.Quote(key)
+	}
+	if b.after != nil {
+		var condition string
+		condition, args = a.keysetCondition(b.after, args)
+		conditions = append(conditions[:len(conditions):len(conditions)], condition)
+	}
+
+	b.size = a.batchSize
+	if a.limit > 0 && a.limit-b.total < b.size {
+		b.size = a.limit - b.total
+	}
+	args = append(args, b.size)
+	query := a.dialect.Limit(a.selectQuery(b.table, b.columns, conditions, order), a.dialect.Placeholder(len(args)))
+
+	b.n = 0
+	b.rows, err = a.db.QueryContext(b.ctx, query+";", args...)
+	return err
+}
+
+// keysetCondition returns the condition selecting rows after the given key values, appending its args,
+// e.g. (a > ? OR (a = ? AND b > ?)).
+func (a *Archiver) keysetCondition(after []interface{}, args []interface{}) (string, []interface{}) {
+	terms := make([]string, len(a.batchKeys))
+	for i := range a.batchKeys {
+		comparisons := make([]string, i+1)
+		for j := 0; j <= i; j++ {
+			operator := "="
+			if j == i {
+				operator = ">"
+			}
+			args = append(args, after[j])
+			comparisons[j] = fmt.Sprintf("%s %s %s", a.dialect.Quote(a.batchKeys[j]), operator, a.dialect.Placeholder(len(args)))
+		}
+		terms[i] = strings.Join(comparisons, " AND ")
+		if i > 0 {
+			terms[i] = "(" + terms[i] + ")"
+		}
+	}
+
+	return "(" + strings.Join(terms, " OR ") + ")", args
+}
+
+func (b *batches) ColumnTypes() ([]*sql.ColumnType, error) {
+	return b.types, nil
+}
+
+// Next advances to the next row, querying the next batch when the current batch is full.
+func (b *batches) Next() bool {
+	if b.err != nil {
+		return false
+	}
+
+	for {
+		if b.rows.Next() {
+			if b.err = b.rows.Scan(b.scanned...); b.err != nil {
+				return false
+			}
+			if b.after == nil {
+				b.after = make([]interface{}, len(b.keys))
+			}
+			for i, j := range b.keys {
+				if b.raw[j] == nil {
+					b.err = fmt.Errorf("null value in batch key column '%s'", b.archiver.batchKeys[i])
+					return false
+				}
+				b.after[i] = string(b.raw[j])
+			}
+			b.n++
+			return true
+		}
+
+		if b.err = b.rows.Err(); b.err != nil {
+			return false
+		}
+		if b.err = b.rows.Close(); b.err != nil {
+			return false
+		}
+
+		b.total += b.n
+		if b.n < b.size || (b.archiver.limit > 0 && b.total >= b.archiver.limit) {
+			return false
+		}
+		if b.err = b.query(); b.err != nil {
+			return false
+		}
+	}
+}
+
+func (b *batches) Scan(dest ...interface{}) error {
+	for i, d := range dest {
+		raw, ok := d.(*sql.RawBytes)
+		if !ok {
+			return fmt.Errorf("unsupported scan type %T", d)
+		}
+		*raw = b.raw[i]
+	}
+
+	return nil
+}
+
+func (b *batches) Err() error {
+	return b.err
+}
+
+func (b *batches) Close() error {
+	return b.rows.Close()
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,135 @@
+// +build unit
+
+package chiv_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gavincabbage.com/chiv"
+)
+
+func TestArchiveBatches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chiv")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db := newSQLite(t, `
+		CREATE TABLE events (id INTEGER, name TEXT);
+		INSERT INTO events VALUES (4, 'fourth'), (1, 'first'), (7, 'seventh'), (3, 'third'), (10, 'tenth'),
+			(2, 'second'), (9, 'ninth'), (5, 'fifth'), (8, 'eighth'), (6, 'sixth');
+		CREATE TABLE readings (sensor TEXT, seq INTEGER, value INTEGER);
+		INSERT INTO readings VALUES ('b', 1, 21), ('a', 2, 12), ('a', 1, 11), ('b', 2, 22), ('a', 3, 13);
+		CREATE TABLE nulls (id INTEGER);
+		INSERT INTO nulls VALUES (1), (NULL);
+	`)
+
+	cases := []struct {
+		name    string
+		table   string
+		batched []chiv.Option
+		single  []chiv.Option
+		err     string
+	}{
+		{
+			name:    "batches",
+			table:   "events",
+			batched: []chiv.Option{chiv.WithBatchSize(3, "id")},
+			single:  []chiv.Option{chiv.WithOrderBy("id")},
+		},
+		{
+			name:    "exact batches",
+			table:   "events",
+			batched: []chiv.Option{chiv.WithBatchSize(5, "id")},
+			single:  []chiv.Option{chiv.WithOrderBy("id")},
+		},
+		{
+			name:    "composite key",
+			table:   "readings",
+			batched: []chiv.Option{chiv.WithBatchSize(2, "sensor", "seq")},
+			single:  []chiv.Option{chiv.WithOrderBy("sensor", "seq")},
+		},
+		{
+			name:    "hidden key",
+			table:   "events",
+			batched: []chiv.Option{chiv.WithBatchSize(4, "id"), chiv.WithColumns("name")},
+			single:  []chiv.Option{chiv.WithOrderBy("id"), chiv.WithColumns("name")},
+		},
+		{
+			name:    "where and limit",
+			table:   "events",
+			batched: []chiv.Option{chiv.WithBatchSize(2, "id"), chiv.WithWhere("id > ?", 2), chiv.WithLimit(5)},
+			single:  []chiv.Option{chiv.WithOrderBy("id"), chiv.WithWhere("id > ?", 2), chiv.WithLimit(5)},
+		},
+		{
+			name:    "order",
+			table:   "events",
+			batched: []chiv.Option{chiv.WithBatchSize(2, "id"), chiv.WithOrderBy("name")},
+			err:     "chiv: querying 'events': batching cannot be used with an order",
+		},
+		{
+			name:    "incremental",
+			table:   "events",
+			batched: []chiv.Option{chiv.WithBatchSize(2, "name"), chiv.WithIncremental("id", chiv.NewFileCheckpointStore(filepath.Join(dir, "checkpoints.json")))},
+			err:     "chiv: querying 'events': batching with incremental archival requires the incremental column 'id' as the first key",
+		},
+		{
+			name:    "no keys",
+			table:   "events",
+			batched: []chiv.Option{chiv.WithBatchSize(2)},
+			err:     "chiv: querying 'events': batching requires key columns",
+		},
+		{
+			name:    "null key",
+			table:   "nulls",
+			batched: []chiv.Option{chiv.WithBatchSize(2, "id")},
+			err:     "chiv: downloading: scanning rows: null value in batch key column 'id'",
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			batched := &uploader{}
+			err := chiv.NewArchiver(db, batched, chiv.WithFormat(writing)).Archive(test.table, "bucket", test.batched...)
+			if test.err != "" {
+				require.EqualError(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+
+			single := &uploader{}
+			require.NoError(t, chiv.NewArchiver(db, single, chiv.WithFormat(writing)).Archive(test.table, "bucket", test.single...))
+			require.Equal(t, single.uploads, batched.uploads)
+		})
+	}
+}
+
+func TestArchiveBatchQueries(t *testing.T) {
+	f := &fixture{
+		columns: []string{"id", "seq", "name"},
+		rows:    [][]interface{}{{"1", "1", "first"}, {"1", "2", "second"}},
+	}
+	db := newFixture(t, f)
+	defer db.Close()
+
+	subject := chiv.NewArchiver(db, &uploader{})
+	require.NoError(t, subject.Archive("events", "bucket",
+		chiv.WithColumns("name"),
+		chiv.WithWhere("name <> ?", "none"),
+		chiv.WithBatchSize(2, "id", "seq"),
+		chiv.WithLimit(4),
+	))
+
+	require.Equal(t, []string{
+		`SELECT "name", "id", "seq" FROM "events" WHERE (name <> ?) ORDER BY "id", "seq" LIMIT ?;`,
+		`SELECT "name", "id", "seq" FROM "events" WHERE (name <> ?) AND ("id" > ? OR ("id" = ? AND "seq" > ?)) ORDER BY "id", "seq" LIMIT ?;`,
+	}, f.queries)
+	require.Equal(t, [][]interface{}{
+		{"none", int64(2)},
+		{"none", "1", "1", "2", int64(2)},
+	}, f.args)
+}
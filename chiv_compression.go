@@ -0,0 +1,166 @@
+package chiv
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+
+	"github.com/dsnet/compress/bzip2"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+)
+
+// DefaultCompression selects a Codec's default compression level.
+const DefaultCompression = -1
+
+// Codec compresses formatted data as it is streamed to S3.
+type Codec interface {
+	// NewWriter returns a compressing writer at the given level wrapping w.
+	NewWriter(w io.Writer, level int) (io.WriteCloser, error)
+	// Extension returns the extension appended to default object keys.
+	Extension() string
+	// ContentEncoding returns the Content-Encoding of uploaded objects.
+	ContentEncoding() string
+}
+
+// Decompressor is a Codec that decompresses the data it compresses, used by Restore.
+// The built-in codecs implement Decompressor.
+type Decompressor interface {
+	// NewReader returns a decompressing reader wrapping r.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// Built-in compression codecs.
+var (
+	Gzip   Codec = gzipCodec{}
+	Zstd   Codec = zstdCodec{}
+	Bzip2  Codec = bzip2Codec{}
+	LZ4    Codec = lz4Codec{}
+	Snappy Codec = snappyCodec{}
+)
+
+type gzipCodec struct{}
+
+func (gzipCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, level)
+}
+
+func (gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func (gzipCodec) Extension() string {
+	return "gz"
+}
+
+func (gzipCodec) ContentEncoding() string {
+	return "gzip"
+}
+
+type zstdCodec struct{}
+
+func (zstdCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	l := zstd.SpeedDefault
+	if level != DefaultCompression {
+		l = zstd.EncoderLevelFromZstd(level)
+	}
+
+	return zstd.NewWriter(w, zstd.WithEncoderLevel(l))
+}
+
+func (zstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	d, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.IOReadCloser(), nil
+}
+
+func (zstdCodec) Extension() string {
+	return "zst"
+}
+
+func (zstdCodec) ContentEncoding() string {
+	return "zstd"
+}
+
+type bzip2Codec struct{}
+
+func (bzip2Codec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level == DefaultCompression {
+		level = bzip2.DefaultCompression
+	}
+
+	return bzip2.NewWriter(w, &bzip2.WriterConfig{Level: level})
+}
+
+func (bzip2Codec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return bzip2.NewReader(r, nil)
+}
+
+func (bzip2Codec) Extension() string {
+	return "bz2"
+}
+
+func (bzip2Codec) ContentEncoding() string {
+	return "bzip2"
+}
+
+type lz4Codec struct{}
+
+func (lz4Codec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	z := lz4.NewWriter(w)
+	if level != DefaultCompression {
+		z.Header.CompressionLevel = level
+	}
+
+	return z, nil
+}
+
+func (lz4Codec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(lz4.NewReader(r)), nil
+}
+
+func (lz4Codec) Extension() string {
+	return "lz4"
+}
+
+func (lz4Codec) ContentEncoding() string {
+	return "lz4"
+}
+
+type snappyCodec struct{}
+
+// NewWriter returns a framed snappy writer. Snappy has no compression levels so level is ignored.
+func (snappyCodec) NewWriter(w io.Writer, _ int) (io.WriteCloser, error) {
+	return snappy.NewBufferedWriter(w), nil
+}
+
+func (snappyCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(snappy.NewReader(r)), nil
+}
+
+func (snappyCodec) Extension() string {
+	return "sz"
+}
+
+func (snappyCodec) ContentEncoding() string {
+	return "snappy"
+}
+
+// chain closes a writer before closing the writer it wraps.
+type chain struct {
+	io.WriteCloser
+	next io.Closer
+}
+
+func (c *chain) Close() error {
+	if err := c.WriteCloser.Close(); err != nil {
+		_ = c.next.Close()
+		return err
+	}
+
+	return c.next.Close()
+}
@@ -0,0 +1,127 @@
+// +build unit
+
+package chiv_test
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+	"github.com/stretchr/testify/require"
+
+	"gavincabbage.com/chiv"
+)
+
+func TestArchiveRowsCompression(t *testing.T) {
+	cases := []struct {
+		name                    string
+		codec                   chiv.Codec
+		level                   int
+		options                 []chiv.Option
+		expectedKey             string
+		expectedContentEncoding string
+		reader                  func(io.Reader) (io.Reader, error)
+	}{
+		{
+			name:                    "gzip",
+			codec:                   chiv.Gzip,
+			level:                   chiv.DefaultCompression,
+			expectedKey:             "table.gz",
+			expectedContentEncoding: "gzip",
+			reader: func(r io.Reader) (io.Reader, error) {
+				return gzip.NewReader(r)
+			},
+		},
+		{
+			name:                    "gzip best compression with extension",
+			codec:                   chiv.Gzip,
+			level:                   gzip.BestCompression,
+			options:                 []chiv.Option{chiv.WithExtension("csv")},
+			expectedKey:             "table.csv.gz",
+			expectedContentEncoding: "gzip",
+			reader: func(r io.Reader) (io.Reader, error) {
+				return gzip.NewReader(r)
+			},
+		},
+		{
+			name:                    "zstd",
+			codec:                   chiv.Zstd,
+			level:                   3,
+			expectedKey:             "table.zst",
+			expectedContentEncoding: "zstd",
+			reader: func(r io.Reader) (io.Reader, error) {
+				return zstd.NewReader(r)
+			},
+		},
+		{
+			name:                    "bzip2",
+			codec:                   chiv.Bzip2,
+			level:                   chiv.DefaultCompression,
+			expectedKey:             "table.bz2",
+			expectedContentEncoding: "bzip2",
+			reader: func(r io.Reader) (io.Reader, error) {
+				return bzip2.NewReader(r), nil
+			},
+		},
+		{
+			name:                    "lz4",
+			codec:                   chiv.LZ4,
+			level:                   chiv.DefaultCompression,
+			expectedKey:             "table.lz4",
+			expectedContentEncoding: "lz4",
+			reader: func(r io.Reader) (io.Reader, error) {
+				return lz4.NewReader(r), nil
+			},
+		},
+		{
+			name:                    "snappy",
+			codec:                   chiv.Snappy,
+			level:                   chiv.DefaultCompression,
+			expectedKey:             "table.sz",
+			expectedContentEncoding: "snappy",
+			reader: func(r io.Reader) (io.Reader, error) {
+				return snappy.NewReader(r), nil
+			},
+		},
+		{
+			name:                    "key override",
+			codec:                   chiv.Gzip,
+			level:                   chiv.DefaultCompression,
+			options:                 []chiv.Option{chiv.WithKey("archive")},
+			expectedKey:             "archive",
+			expectedContentEncoding: "gzip",
+			reader: func(r io.Reader) (io.Reader, error) {
+				return gzip.NewReader(r)
+			},
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				rows = &rows{
+					columns: []string{"first_column", "second_column"},
+					scan:    [][]string{{"first", "second"}, {"third", "fourth"}},
+				}
+				uploader = &uploader{}
+				options  = append(test.options, chiv.WithFormat(writing), chiv.WithCompression(test.codec, test.level))
+			)
+
+			require.NoError(t, chiv.ArchiveRows(rows, uploader, "bucket", options...))
+			require.Equal(t, test.expectedKey, uploader.uploadKey)
+			require.Equal(t, test.expectedContentEncoding, uploader.uploadAttributes.ContentEncoding)
+
+			r, err := test.reader(bytes.NewReader(uploader.uploadBody))
+			require.NoError(t, err)
+			actual, err := ioutil.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, "first,second\nthird,fourth\n", string(actual))
+		})
+	}
+}
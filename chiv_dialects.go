@@ -0,0 +1,397 @@
+package chiv
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Dialect describes the SQL of a database. The dialect of an Archiver's Database is selected
+// automatically from its driver, or may be configured with WithDialect.
+type Dialect interface {
+	// Quote quotes an identifier, optionally schema-qualified, e.g. "schema"."table".
+	Quote(identifier string) string
+	// Placeholder returns the n-th query placeholder, counting from one, e.g. $1 or ?.
+	Placeholder(n int) string
+	// Limit limits the results of a query to the given number of rows, given as a placeholder.
+	Limit(query, n string) string
+	// Catalog returns a query and its arguments listing the column names of a table.
+	// An empty schema selects the connection's default schema.
+	Catalog(schema, table string) (string, []interface{})
+	// Tables returns a query and its arguments listing the tables of a schema.
+	// An empty schema selects the connection's default schema.
+	Tables(schema string) (string, []interface{})
+	// TypeName normalizes a database type name, e.g. INT4 to INTEGER.
+	TypeName(name string) string
+	// Snapshot returns the options of a read-only transaction reading a consistent snapshot
+	// of the database, or nil if snapshots are not supported.
+	Snapshot() *sql.TxOptions
+}
+
+// Built-in SQL dialects.
+var (
+	Postgres   Dialect = postgresDialect{}
+	MySQL      Dialect = mysqlDialect{}
+	SQLite     Dialect = sqliteDialect{}
+	SQLServer  Dialect = sqlServerDialect{}
+	ClickHouse Dialect = clickHouseDialect{}
+)
+
+// dialects maps driver package paths to their dialects.
+var dialects = []struct {
+	pkg     string
+	dialect Dialect
+}{
+	{"lib/pq", Postgres},
+	{"jackc/pgx", Postgres},
+	{"go-sql-driver/mysql", MySQL},
+	{"modernc.org/sqlite", SQLite},
+	{"mattn/go-sqlite3", SQLite},
+	{"go-mssqldb", SQLServer},
+	{"clickhouse-go", ClickHouse},
+}
+
+// dialectOf returns the dialect of a database from its driver. Databases that don't expose
+// their driver, or whose driver isn't recognized, are assumed to follow ANSI SQL.
+func dialectOf(db Database) Dialect {
+	path := driverPath(db)
+	for _, candidate := range dialects {
+		if path != "" && strings.Contains(path, candidate.pkg) {
+			return candidate.dialect
+		}
+	}
+
+	return ansiDialect{}
+}
+
+// driverPath returns the package path of a database's driver, or an empty string if it is not exposed.
+func driverPath(db Database) string {
+	d, ok := db.(interface{ Driver() driver.Driver })
+	if !ok {
+		return ""
+	}
+
+	t := reflect.TypeOf(d.Driver())
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return t.PkgPath()
+}
+
+// normalize looks up a type name, ignoring case and any length or precision, returning it unchanged if unknown.
+func normalize(name string, names map[string]string) string {
+	base := name
+	if i := strings.Index(base, "("); i >= 0 {
+		base = base[:i]
+	}
+	if normalized, ok := names[strings.ToUpper(strings.TrimSpace(base))]; ok {
+		return normalized
+	}
+
+	return name
+}
+
+// informationSchema returns a catalog query of the ANSI information schema.
+func informationSchema(d Dialect, current, schema, table string) (string, []interface{}) {
+	const query = "SELECT column_name FROM information_schema.columns WHERE table_schema = %s AND table_name = %s;"
+	if schema == "" {
+		return fmt.Sprintf(query, current, d.Placeholder(1)), []interface{}{table}
+	}
+
+	return fmt.Sprintf(query, d.Placeholder(1), d.Placeholder(2)), []interface{}{schema, table}
+}
+
+// informationSchemaTables returns a table listing query of the ANSI information schema.
+func informationSchemaTables(d Dialect, current, schema string) (string, []interface{}) {
+	const query = "SELECT table_name FROM information_schema.tables WHERE table_schema = %s AND table_type = 'BASE TABLE' ORDER BY table_name;"
+	if schema == "" {
+		return fmt.Sprintf(query, current), nil
+	}
+
+	return fmt.Sprintf(query, d.Placeholder(1)), []interface{}{schema}
+}
+
+type ansiDialect struct{}
+
+func (ansiDialect) Quote(identifier string) string {
+	return quote(identifier, `"`, `"`)
+}
+
+func (ansiDialect) Placeholder(int) string {
+	return "?"
+}
+
+func (ansiDialect) Limit(query, n string) string {
+	return fmt.Sprintf("%s LIMIT %s", query, n)
+}
+
+// Catalog returns no query, as databases without a known dialect may not provide an information schema.
+func (ansiDialect) Catalog(string, string) (string, []interface{}) {
+	return "", nil
+}
+
+// Tables returns no query, as databases without a known dialect may not provide an information schema.
+func (ansiDialect) Tables(string) (string, []interface{}) {
+	return "", nil
+}
+
+func (ansiDialect) TypeName(name string) string {
+	return name
+}
+
+func (ansiDialect) Snapshot() *sql.TxOptions {
+	return &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}
+}
+
+type postgresDialect struct {
+	ansiDialect
+}
+
+func (postgresDialect) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func (d postgresDialect) Catalog(schema, table string) (string, []interface{}) {
+	return informationSchema(d, "current_schema()", schema, table)
+}
+
+func (d postgresDialect) Tables(schema string) (string, []interface{}) {
+	return informationSchemaTables(d, "current_schema()", schema)
+}
+
+var postgresTypes = map[string]string{
+	"BOOL":             "BOOLEAN",
+	"INT2":             "SMALLINT",
+	"INT4":             "INTEGER",
+	"INT8":             "BIGINT",
+	"SERIAL":           "INTEGER",
+	"BIGSERIAL":        "BIGINT",
+	"FLOAT4":           "FLOAT",
+	"REAL":             "FLOAT",
+	"FLOAT8":           "DOUBLE",
+	"DOUBLE PRECISION": "DOUBLE",
+	"BPCHAR":           "CHAR",
+}
+
+func (postgresDialect) TypeName(name string) string {
+	return normalize(name, postgresTypes)
+}
+
+type mysqlDialect struct {
+	ansiDialect
+}
+
+func (mysqlDialect) Quote(identifier string) string {
+	return quote(identifier, "`", "`")
+}
+
+func (d mysqlDialect) Catalog(schema, table string) (string, []interface{}) {
+	return informationSchema(d, "DATABASE()", schema, table)
+}
+
+func (d mysqlDialect) Tables(schema string) (string, []interface{}) {
+	return informationSchemaTables(d, "DATABASE()", schema)
+}
+
+var mysqlTypes = map[string]string{
+	"BOOL":      "BOOLEAN",
+	"MEDIUMINT": "INTEGER",
+	"REAL":      "DOUBLE",
+	"BINARY":    "BLOB",
+	"VARBINARY": "BLOB",
+}
+
+func (mysqlDialect) TypeName(name string) string {
+	return normalize(name, mysqlTypes)
+}
+
+type sqliteDialect struct {
+	ansiDialect
+}
+
+// Catalog lists the table's columns using the table_info pragma, which takes the schema as its second argument.
+func (sqliteDialect) Catalog(schema, table string) (string, []interface{}) {
+	if schema == "" {
+		return "SELECT name FROM pragma_table_info(?);", []interface{}{table}
+	}
+
+	return "SELECT name FROM pragma_table_info(?, ?);", []interface{}{table, schema}
+}
+
+// Tables lists the tables of the schema's sqlite_master table, excluding SQLite's internal tables.
+func (d sqliteDialect) Tables(schema string) (string, []interface{}) {
+	master := "sqlite_master"
+	if schema != "" {
+		master = d.Quote(schema) + "." + master
+	}
+
+	return fmt.Sprintf("SELECT name FROM %s WHERE type = 'table' AND name NOT LIKE 'sqlite_%%' ORDER BY name;", master), nil
+}
+
+var sqliteTypes = map[string]string{
+	"BOOL":             "BOOLEAN",
+	"INT":              "INTEGER",
+	"REAL":             "DOUBLE",
+	"DOUBLE PRECISION": "DOUBLE",
+}
+
+func (sqliteDialect) TypeName(name string) string {
+	return normalize(name, sqliteTypes)
+}
+
+// Snapshot returns default options, as SQLite transactions are serializable and drivers may not accept others.
+func (sqliteDialect) Snapshot() *sql.TxOptions {
+	return &sql.TxOptions{}
+}
+
+type sqlServerDialect struct{}
+
+func (sqlServerDialect) Quote(identifier string) string {
+	return quote(identifier, "[", "]")
+}
+
+func (sqlServerDialect) Placeholder(n int) string {
+	return "@p" + strconv.Itoa(n)
+}
+
+// Limit limits a query with TOP, as OFFSET and FETCH require an ORDER BY clause.
+func (sqlServerDialect) Limit(query, n string) string {
+	return strings.Replace(query, "SELECT ", fmt.Sprintf("SELECT TOP (%s) ", n), 1)
+}
+
+func (d sqlServerDialect) Catalog(schema, table string) (string, []interface{}) {
+	return informationSchema(d, "SCHEMA_NAME()", schema, table)
+}
+
+func (d sqlServerDialect) Tables(schema string) (string, []interface{}) {
+	return informationSchemaTables(d, "SCHEMA_NAME()", schema)
+}
+
+var sqlServerTypes = map[string]string{
+	"BIT":        "BOOLEAN",
+	"REAL":       "FLOAT",
+	"MONEY":      "DECIMAL",
+	"SMALLMONEY": "DECIMAL",
+	"NCHAR":      "CHAR",
+	"NVARCHAR":   "VARCHAR",
+	"NTEXT":      "TEXT",
+	"BINARY":     "BLOB",
+	"VARBINARY":  "BLOB",
+	"IMAGE":      "BLOB",
+}
+
+func (sqlServerDialect) TypeName(name string) string {
+	return normalize(name, sqlServerTypes)
+}
+
+// Snapshot uses snapshot isolation, which must be enabled with ALLOW_SNAPSHOT_ISOLATION.
+// SQL Server does not support read-only transactions.
+func (sqlServerDialect) Snapshot() *sql.TxOptions {
+	return &sql.TxOptions{Isolation: sql.LevelSnapshot}
+}
+
+type clickHouseDialect struct {
+	ansiDialect
+}
+
+func (clickHouseDialect) Quote(identifier string) string {
+	return quote(identifier, "`", "`")
+}
+
+func (clickHouseDialect) Catalog(schema, table string) (string, []interface{}) {
+	if schema == "" {
+		return "SELECT name FROM system.columns WHERE database = currentDatabase() AND table = ?;", []interface{}{table}
+	}
+
+	return "SELECT name FROM system.columns WHERE database = ? AND table = ?;", []interface{}{schema, table}
+}
+
+func (clickHouseDialect) Tables(schema string) (string, []interface{}) {
+	if schema == "" {
+		return "SELECT name FROM system.tables WHERE database = currentDatabase() ORDER BY name;", nil
+	}
+
+	return "SELECT name FROM system.tables WHERE database = ? ORDER BY name;", []interface{}{schema}
+}
+
+var clickHouseTypes = map[string]string{
+	"BOOL":        "BOOLEAN",
+	"INT8":        "TINYINT",
+	"INT16":       "SMALLINT",
+	"INT32":       "INTEGER",
+	"INT64":       "BIGINT",
+	"INT128":      "BIGINT",
+	"INT256":      "BIGINT",
+	"UINT8":       "TINYINT",
+	"UINT16":      "SMALLINT",
+	"UINT32":      "INTEGER",
+	"UINT64":      "BIGINT",
+	"UINT128":     "BIGINT",
+	"UINT256":     "BIGINT",
+	"FLOAT32":     "FLOAT",
+	"FLOAT64":     "DOUBLE",
+	"DECIMAL":     "DECIMAL",
+	"DECIMAL32":   "DECIMAL",
+	"DECIMAL64":   "DECIMAL",
+	"DECIMAL128":  "DECIMAL",
+	"DECIMAL256":  "DECIMAL",
+	"STRING":      "TEXT",
+	"FIXEDSTRING": "CHAR",
+}
+
+// TypeName normalizes ClickHouse's type names, unwrapping Nullable and LowCardinality types.
+func (clickHouseDialect) TypeName(name string) string {
+	for _, wrapper := range []string{"Nullable(", "LowCardinality("} {
+		if strings.HasPrefix(name, wrapper) && strings.HasSuffix(name, ")") {
+			return clickHouseDialect{}.TypeName(name[len(wrapper) : len(name)-1])
+		}
+	}
+
+	return normalize(name, clickHouseTypes)
+}
+
+// Snapshot returns nil, as ClickHouse does not support transactions.
+func (clickHouseDialect) Snapshot() *sql.TxOptions {
+	return nil
+}
+
+// dialectColumn is a column with its type name normalized by a dialect.
+type dialectColumn struct {
+	Column
+	dialect Dialect
+}
+
+func (c dialectColumn) DatabaseTypeName() string {
+	return c.dialect.TypeName(c.Column.DatabaseTypeName())
+}
+
+// Nullable reports the nullability of the underlying column, if known.
+func (c dialectColumn) Nullable() (nullable, ok bool) {
+	if n, ok := c.Column.(interface{ Nullable() (bool, bool) }); ok {
+		return n.Nullable()
+	}
+
+	return false, false
+}
+
+// Length reports the length of the underlying column, if known.
+func (c dialectColumn) Length() (length int64, ok bool) {
+	if l, ok := c.Column.(interface{ Length() (int64, bool) }); ok {
+		return l.Length()
+	}
+
+	return 0, false
+}
+
+// DecimalSize reports the precision and scale of the underlying column, if known.
+func (c dialectColumn) DecimalSize() (precision, scale int64, ok bool) {
+	if d, ok := c.Column.(interface{ DecimalSize() (int64, int64, bool) }); ok {
+		return d.DecimalSize()
+	}
+
+	return 0, 0, false
+}
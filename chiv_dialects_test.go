@@ -0,0 +1,128 @@
+// +build unit
+
+package chiv_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+
+	"gavincabbage.com/chiv"
+)
+
+func TestDialects(t *testing.T) {
+	cases := []struct {
+		name        string
+		dialect     chiv.Dialect
+		quoted      string
+		placeholder string
+		limited     string
+		catalog     string
+		types       map[string]string
+	}{
+		{
+			name:        "postgres",
+			dialect:     chiv.Postgres,
+			quoted:      `"audit"."my""table"`,
+			placeholder: "$2",
+			limited:     "SELECT * FROM t LIMIT $2",
+			catalog:     "SELECT column_name FROM information_schema.columns WHERE table_schema = $1 AND table_name = $2;",
+			types:       map[string]string{"INT4": "INTEGER", "FLOAT8": "DOUBLE", "BOOL": "BOOLEAN", "UUID": "UUID"},
+		},
+		{
+			name:        "mysql",
+			dialect:     chiv.MySQL,
+			quoted:      "`audit`.`my\"table`",
+			placeholder: "?",
+			limited:     "SELECT * FROM t LIMIT ?",
+			catalog:     "SELECT column_name FROM information_schema.columns WHERE table_schema = ? AND table_name = ?;",
+			types:       map[string]string{"INT": "INT", "VARBINARY": "BLOB", "DECIMAL": "DECIMAL"},
+		},
+		{
+			name:        "sqlite",
+			dialect:     chiv.SQLite,
+			quoted:      `"audit"."my""table"`,
+			placeholder: "?",
+			limited:     "SELECT * FROM t LIMIT ?",
+			catalog:     "SELECT name FROM pragma_table_info(?, ?);",
+			types:       map[string]string{"INT": "INTEGER", "real": "DOUBLE", "VARCHAR(10)": "VARCHAR(10)"},
+		},
+		{
+			name:        "sql server",
+			dialect:     chiv.SQLServer,
+			quoted:      `[audit].[my"table]`,
+			placeholder: "@p2",
+			limited:     "SELECT TOP (@p2) * FROM t",
+			catalog:     "SELECT column_name FROM information_schema.columns WHERE table_schema = @p1 AND table_name = @p2;",
+			types:       map[string]string{"BIT": "BOOLEAN", "MONEY": "DECIMAL", "NVARCHAR": "VARCHAR", "BINARY": "BLOB"},
+		},
+		{
+			name:        "clickhouse",
+			dialect:     chiv.ClickHouse,
+			quoted:      "`audit`.`my\"table`",
+			placeholder: "?",
+			limited:     "SELECT * FROM t LIMIT ?",
+			catalog:     "SELECT name FROM system.columns WHERE database = ? AND table = ?;",
+			types: map[string]string{
+				"UInt8":                            "TINYINT",
+				"Nullable(Float64)":                "DOUBLE",
+				"LowCardinality(Nullable(String))": "TEXT",
+				"Decimal(10, 2)":                   "DECIMAL",
+				"DateTime":                         "DateTime",
+			},
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.quoted, test.dialect.Quote(`audit.my"table`))
+			require.Equal(t, test.placeholder, test.dialect.Placeholder(2))
+			require.Equal(t, test.limited, test.dialect.Limit("SELECT * FROM t", test.dialect.Placeholder(2)))
+
+			catalog, args := test.dialect.Catalog("audit", "events")
+			require.Equal(t, test.catalog, catalog)
+			require.Len(t, args, 2)
+
+			for name, expected := range test.types {
+				require.Equal(t, expected, test.dialect.TypeName(name), name)
+			}
+		})
+	}
+}
+
+func TestSQLite(t *testing.T) {
+	db := newSQLite(t, `
+		CREATE TABLE "Order" (id INTEGER PRIMARY KEY, "Name" TEXT, amount REAL, paid BOOLEAN);
+		INSERT INTO "Order" VALUES (1, 'first', 1.5, true), (2, 'second', 2.5, false), (3, NULL, 3.5, true);
+	`)
+
+	var (
+		uploader = &uploader{}
+		subject  = chiv.NewArchiver(db, uploader, chiv.WithFormat(chiv.NDJSON))
+	)
+
+	require.NoError(t, subject.Archive("Order", "bucket",
+		chiv.WithColumns("id", "Name", "amount", "paid"),
+		chiv.WithWhere("id > ?", 1),
+		chiv.WithLimit(1),
+	))
+	require.Equal(t, `{"Name":"second","amount":2.5,"id":2,"paid":false}`+"\n", uploader.uploads["Order.jsonl"])
+
+	require.EqualError(t, subject.Archive("missing", "bucket"), "chiv: querying 'missing': table 'missing' not found")
+	require.EqualError(t, subject.Archive("Order", "bucket", chiv.WithColumns("missing")),
+		"chiv: querying 'Order': column 'missing' not found in table 'Order'")
+	require.EqualError(t, subject.Archive("Order", "bucket", chiv.WithColumns("count(*)")),
+		"chiv: querying 'Order': column 'count(*)' not found in table 'Order': columns are quoted names, not expressions")
+
+	require.NoError(t, subject.Archive("Order", "bucket", chiv.WithColumns("ID", "name"), chiv.WithWhere("id = ?", 1)))
+	require.Equal(t, `{"Name":"first","id":1}`+"\n", uploader.uploads["Order.jsonl"])
+
+	result, err := subject.ArchiveAndPurge("Order", "bucket", []string{"id"}, chiv.WithWhere("paid"))
+	require.NoError(t, err)
+	require.Equal(t, &chiv.PurgeResult{Archived: 2, Deleted: 2}, result)
+
+	var remaining int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM "Order";`).Scan(&remaining))
+	require.Equal(t, 1, remaining)
+}
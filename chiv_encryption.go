@@ -0,0 +1,293 @@
+package chiv
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Object metadata describing an encrypted archive.
+const (
+	MetadataEncryption = "chiv-encryption"
+	MetadataKey        = "chiv-key"
+	MetadataNonce      = "chiv-nonce"
+	MetadataChunkSize  = "chiv-chunk-size"
+)
+
+const (
+	encryptionAlgorithm = "AES-256-GCM-CHUNKED"
+	encryptionChunkSize = 64 << 10
+	encryptionKeySize   = 32
+	noncePrefixSize     = 7
+)
+
+// KeyWrapper wraps per-object data keys with a key encryption key.
+type KeyWrapper interface {
+	// WrapKey encrypts a data key.
+	WrapKey(ctx context.Context, key []byte) ([]byte, error)
+	// UnwrapKey decrypts a data key previously encrypted by WrapKey.
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+type fileKeyWrapper struct {
+	aead cipher.AEAD
+}
+
+// NewFileKeyWrapper returns a KeyWrapper using a 256-bit key encryption key read from a local file.
+// The file must contain either the 32 raw key bytes or their base64 encoding.
+func NewFileKeyWrapper(path string) (KeyWrapper, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errorf("reading key file: %w", err)
+	}
+
+	key := b
+	if len(key) != encryptionKeySize {
+		key, err = base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+		if err != nil || len(key) != encryptionKeySize {
+			return nil, errorf("key file must contain a %d byte key", encryptionKeySize)
+		}
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, errorf("initializing key encryption key: %w", err)
+	}
+
+	return &fileKeyWrapper{aead: aead}, nil
+}
+
+// WrapKey encrypts the data key with AES-256-GCM, prepending a random nonce.
+func (k *fileKeyWrapper) WrapKey(_ context.Context, key []byte) ([]byte, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return k.aead.Seal(nonce, nonce, key, nil), nil
+}
+
+// UnwrapKey decrypts a data key wrapped by WrapKey.
+func (k *fileKeyWrapper) UnwrapKey(_ context.Context, wrapped []byte) ([]byte, error) {
+	size := k.aead.NonceSize()
+	if len(wrapped) < size {
+		return nil, errors.New("wrapped key too short")
+	}
+
+	return k.aead.Open(nil, wrapped[:size], wrapped[size:], nil)
+}
+
+// Decrypt returns a reader of the plaintext of an object archived using WithEncryption.
+// The object's metadata must be provided to recover its data key. Metadata names are case-insensitive,
+// and may use underscores in place of hyphens.
+func Decrypt(r io.Reader, metadata map[string]string, wrapper KeyWrapper) (io.Reader, error) {
+	return DecryptWithContext(context.Background(), r, metadata, wrapper)
+}
+
+// DecryptWithContext is like Decrypt, with context.
+func DecryptWithContext(ctx context.Context, r io.Reader, metadata map[string]string, wrapper KeyWrapper) (io.Reader, error) {
+	m := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		m[strings.ReplaceAll(strings.ToLower(k), "_", "-")] = v
+	}
+
+	if algorithm := m[MetadataEncryption]; algorithm != encryptionAlgorithm {
+		return nil, errorf("decrypting: unsupported algorithm '%s'", algorithm)
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(m[MetadataKey])
+	if err != nil {
+		return nil, errorf("decrypting: decoding key: %w", err)
+	}
+	key, err := wrapper.UnwrapKey(ctx, wrapped)
+	if err != nil {
+		return nil, errorf("decrypting: unwrapping key: %w", err)
+	}
+
+	prefix, err := base64.StdEncoding.DecodeString(m[MetadataNonce])
+	if err != nil || len(prefix) != noncePrefixSize {
+		return nil, errorf("decrypting: invalid nonce")
+	}
+
+	size, err := strconv.Atoi(m[MetadataChunkSize])
+	if err != nil || size <= 0 {
+		return nil, errorf("decrypting: invalid chunk size")
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, errorf("decrypting: %w", err)
+	}
+
+	return &decrypter{
+		r:     bufio.NewReader(r),
+		aead:  aead,
+		nonce: append(prefix, make([]byte, aead.NonceSize()-noncePrefixSize)...),
+		buf:   make([]byte, size+aead.Overhead()),
+	}, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// encrypter seals fixed size chunks of plaintext with AES-GCM. Each chunk's nonce is
+// a random per-object prefix, a chunk counter and a flag marking the final chunk.
+type encrypter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	nonce []byte
+	buf   []byte
+	out   []byte
+	count uint32
+}
+
+func newEncrypter(ctx context.Context, w io.Writer, wrapper KeyWrapper) (*encrypter, map[string]string, error) {
+	key := make([]byte, encryptionKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, fmt.Errorf("generating data key: %w", err)
+	}
+
+	wrapped, err := wrapper.WrapKey(ctx, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("wrapping data key: %w", err)
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce[:noncePrefixSize]); err != nil {
+		return nil, nil, fmt.Errorf("generating nonce: %w", err)
+	}
+
+	metadata := map[string]string{
+		MetadataEncryption: encryptionAlgorithm,
+		MetadataKey:        base64.StdEncoding.EncodeToString(wrapped),
+		MetadataNonce:      base64.StdEncoding.EncodeToString(nonce[:noncePrefixSize]),
+		MetadataChunkSize:  strconv.Itoa(encryptionChunkSize),
+	}
+
+	return &encrypter{
+		w:     w,
+		aead:  aead,
+		nonce: nonce,
+		buf:   make([]byte, 0, encryptionChunkSize),
+		out:   make([]byte, 0, encryptionChunkSize+aead.Overhead()),
+	}, metadata, nil
+}
+
+// Write buffers p, sealing full chunks once more data is known to follow them.
+func (e *encrypter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if len(e.buf) == encryptionChunkSize {
+			if err := e.seal(false); err != nil {
+				return n - len(p), err
+			}
+		}
+
+		m := copy(e.buf[len(e.buf):encryptionChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+m]
+		p = p[m:]
+	}
+
+	return n, nil
+}
+
+// Close seals the final chunk.
+func (e *encrypter) Close() error {
+	return e.seal(true)
+}
+
+func (e *encrypter) seal(last bool) error {
+	if e.count == math.MaxUint32 {
+		return errors.New("encrypting: too many chunks")
+	}
+
+	setNonce(e.nonce, e.count, last)
+	e.out = e.aead.Seal(e.out[:0], e.nonce, e.buf, nil)
+	if _, err := e.w.Write(e.out); err != nil {
+		return err
+	}
+
+	e.buf = e.buf[:0]
+	e.count++
+	return nil
+}
+
+type decrypter struct {
+	r     *bufio.Reader
+	aead  cipher.AEAD
+	nonce []byte
+	buf   []byte
+	out   []byte
+	count uint32
+	done  bool
+}
+
+func (d *decrypter) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+func (d *decrypter) open() error {
+	n, err := io.ReadFull(d.r, d.buf)
+	switch err {
+	case nil:
+		if _, err := d.r.Peek(1); err == io.EOF {
+			d.done = true
+		} else if err != nil {
+			return err
+		}
+	case io.EOF, io.ErrUnexpectedEOF:
+		d.done = true
+	default:
+		return err
+	}
+
+	setNonce(d.nonce, d.count, d.done)
+	d.out, err = d.aead.Open(d.buf[:0], d.nonce, d.buf[:n], nil)
+	if err != nil {
+		return errorf("decrypting: chunk %d: %w", d.count, err)
+	}
+
+	d.count++
+	return nil
+}
+
+func setNonce(nonce []byte, count uint32, last bool) {
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], count)
+	nonce[len(nonce)-1] = 0
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+}
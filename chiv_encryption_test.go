@@ -0,0 +1,176 @@
+// +build unit
+
+package chiv_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gavincabbage.com/chiv"
+)
+
+func TestArchiveRowsEncryption(t *testing.T) {
+	var (
+		large = strings.Repeat("x", 100000)
+		cases = []struct {
+			name     string
+			scan     [][]string
+			options  []chiv.Option
+			expected string
+		}{
+			{
+				name:     "no rows",
+				expected: "",
+			},
+			{
+				name:     "single chunk",
+				scan:     [][]string{{"first", "second"}, {"third", "fourth"}},
+				expected: "first,second\nthird,fourth\n",
+			},
+			{
+				name:     "multiple chunks",
+				scan:     [][]string{{large, large}, {large, large}},
+				expected: large + "," + large + "\n" + large + "," + large + "\n",
+			},
+			{
+				name:     "exact chunk",
+				scan:     [][]string{{strings.Repeat("x", 64<<10-2), ""}},
+				expected: strings.Repeat("x", 64<<10-2) + ",\n",
+			},
+		}
+	)
+
+	wrapper := newKeyWrapper(t)
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				rows = &rows{
+					columns: []string{"first_column", "second_column"},
+					scan:    test.scan,
+				}
+				uploader = &uploader{}
+				options  = append(test.options, chiv.WithFormat(writing), chiv.WithEncryption(wrapper))
+			)
+
+			require.NoError(t, chiv.ArchiveRows(rows, uploader, "bucket", options...))
+			require.Equal(t, "AES-256-GCM-CHUNKED", uploader.uploadAttributes.Metadata[chiv.MetadataEncryption])
+
+			r, err := chiv.Decrypt(bytes.NewReader(uploader.uploadBody), uploader.uploadAttributes.Metadata, wrapper)
+			require.NoError(t, err)
+			actual, err := ioutil.ReadAll(r)
+			require.NoError(t, err)
+			require.Equal(t, test.expected, string(actual))
+
+			if len(uploader.uploadBody) > 64<<10+16 {
+				r, err := chiv.Decrypt(bytes.NewReader(uploader.uploadBody[:64<<10+16]), uploader.uploadAttributes.Metadata, wrapper)
+				require.NoError(t, err)
+				_, err = ioutil.ReadAll(r)
+				require.Error(t, err, "truncated")
+			}
+
+			uploader.uploadBody[0] ^= 1
+			r, err = chiv.Decrypt(bytes.NewReader(uploader.uploadBody), uploader.uploadAttributes.Metadata, wrapper)
+			require.NoError(t, err)
+			_, err = ioutil.ReadAll(r)
+			require.Error(t, err, "tampered")
+		})
+	}
+}
+
+func TestArchiveRowsEncryptionCompression(t *testing.T) {
+	var (
+		rows = &rows{
+			columns: []string{"first_column", "second_column"},
+			scan:    [][]string{{"first", "second"}, {"third", "fourth"}},
+		}
+		uploader = &uploader{}
+		wrapper  = newKeyWrapper(t)
+	)
+
+	require.NoError(t, chiv.ArchiveRows(rows, uploader, "bucket",
+		chiv.WithFormat(writing),
+		chiv.WithEncryption(wrapper),
+		chiv.WithCompression(chiv.Gzip, chiv.DefaultCompression),
+	))
+	require.Equal(t, "table.gz", uploader.uploadKey)
+	require.Empty(t, uploader.uploadAttributes.ContentEncoding)
+
+	metadata := make(map[string]string)
+	for k, v := range uploader.uploadAttributes.Metadata {
+		metadata[http.CanonicalHeaderKey(k)] = v
+	}
+
+	r, err := chiv.Decrypt(bytes.NewReader(uploader.uploadBody), metadata, wrapper)
+	require.NoError(t, err)
+	z, err := gzip.NewReader(r)
+	require.NoError(t, err)
+	actual, err := ioutil.ReadAll(z)
+	require.NoError(t, err)
+	require.Equal(t, "first,second\nthird,fourth\n", string(actual))
+}
+
+func TestNewFileKeyWrapper(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chiv")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	key := make([]byte, 32)
+	_, err = rand.Read(key)
+	require.NoError(t, err)
+
+	var (
+		raw     = filepath.Join(dir, "raw")
+		encoded = filepath.Join(dir, "encoded")
+		invalid = filepath.Join(dir, "invalid")
+	)
+	require.NoError(t, ioutil.WriteFile(raw, key, 0600))
+	require.NoError(t, ioutil.WriteFile(encoded, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600))
+	require.NoError(t, ioutil.WriteFile(invalid, []byte("too short"), 0600))
+
+	first, err := chiv.NewFileKeyWrapper(raw)
+	require.NoError(t, err)
+	second, err := chiv.NewFileKeyWrapper(encoded)
+	require.NoError(t, err)
+
+	wrapped, err := first.WrapKey(context.Background(), []byte("data key"))
+	require.NoError(t, err)
+	unwrapped, err := second.UnwrapKey(context.Background(), wrapped)
+	require.NoError(t, err)
+	require.Equal(t, "data key", string(unwrapped))
+
+	_, err = chiv.NewFileKeyWrapper(invalid)
+	require.Error(t, err)
+	_, err = chiv.NewFileKeyWrapper(filepath.Join(dir, "missing"))
+	require.Error(t, err)
+}
+
+func newKeyWrapper(t *testing.T) chiv.KeyWrapper {
+	t.Helper()
+
+	f, err := ioutil.TempFile("", "chiv")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	key := make([]byte, 32)
+	_, err = rand.Read(key)
+	require.NoError(t, err)
+	_, err = f.Write(key)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	wrapper, err := chiv.NewFileKeyWrapper(f.Name())
+	require.NoError(t, err)
+
+	return wrapper
+}
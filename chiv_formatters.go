@@ -1,6 +1,9 @@
 package chiv
 
 import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
 	"encoding/csv"
 	"encoding/json"
 	"errors"
@@ -9,6 +12,7 @@ import (
 	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
 
 	yaml "gopkg.in/yaml.v2"
 )
@@ -39,6 +43,12 @@ type Extensioner interface {
 	Extension() string
 }
 
+// NullPreserver is a Formatter that represents null values natively. Records
+// passed to a NullPreserver contain nil values instead of the WithNull placeholder.
+type NullPreserver interface {
+	PreserveNull() bool
+}
+
 type csvFormatter struct {
 	w       *csv.Writer
 	columns []Column
@@ -146,6 +156,7 @@ const (
 	openBracket  = byte('[')
 	closeBracket = byte(']')
 	comma        = byte(',')
+	newline      = byte('\n')
 )
 
 type jsonFormatter struct {
@@ -220,6 +231,307 @@ func (f *jsonFormatter) writeByte(b byte) error {
 	return nil
 }
 
+type ndjsonFormatter struct {
+	w       io.Writer
+	columns []Column
+}
+
+// NDJSON returns an initialized newline-delimited JSON formatter.
+func NDJSON(w io.Writer, columns []Column) Formatter {
+	return &ndjsonFormatter{
+		w:       w,
+		columns: columns,
+	}
+}
+
+// Open the NDJSON formatter.
+func (*ndjsonFormatter) Open() error {
+	return nil
+}
+
+// Format an NDJSON record as a single line.
+func (f *ndjsonFormatter) Format(record [][]byte) error {
+	if len(f.columns) != len(record) {
+		return errors.New("record length does not match number of columns")
+	}
+
+	m, err := buildMap(record, f.columns)
+	if err != nil {
+		return fmt.Errorf("transforming data: %w", err)
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("writing formatted data: %w", err)
+	}
+
+	if _, err := f.w.Write(append(b, newline)); err != nil {
+		return fmt.Errorf("writing formatted data: %w", err)
+	}
+
+	return nil
+}
+
+// Close the NDJSON formatter.
+func (*ndjsonFormatter) Close() error {
+	return nil
+}
+
+// Extension returns the default NDJSON formatter extension.
+func (*ndjsonFormatter) Extension() string {
+	return "jsonl"
+}
+
+// parquetRowGroupSize is the approximate number of bytes buffered before a Parquet row group is written.
+const parquetRowGroupSize = 16 << 20
+
+type parquetFormatter struct {
+	w      *offsetWriter
+	chunks []*parquetChunk
+	groups []parquetRowGroup
+	rows   int64
+	total  int64
+	size   int
+}
+
+// Parquet returns an initialized Apache Parquet formatter. The Parquet schema is derived
+// from each column's scan and database types. Records are buffered and written in row groups.
+// DECIMAL and NUMERIC columns are written as Parquet decimals if the driver reports their
+// precision and scale, and otherwise as strings, so their values are never rounded. Unsigned
+// 64-bit integers, which may exceed the range of Parquet's INT64, are written as decimals.
+func Parquet(w io.Writer, columns []Column) Formatter {
+	f := &parquetFormatter{
+		w:      &offsetWriter{w: w},
+		chunks: make([]*parquetChunk, len(columns)),
+	}
+	for i, column := range columns {
+		f.chunks[i] = &parquetChunk{
+			name: column.Name(),
+			kind: kindOf(column),
+		}
+		if precision, scale, ok := decimalOf(column); ok {
+			f.chunks[i].kind = text
+			f.chunks[i].precision, f.chunks[i].scale = precision, scale
+		}
+	}
+
+	return f
+}
+
+// Open the Parquet formatter by writing the leading magic number.
+func (f *parquetFormatter) Open() error {
+	if _, err := io.WriteString(f.w, parquetMagic); err != nil {
+		return fmt.Errorf("writing magic number: %w", err)
+	}
+
+	return nil
+}
+
+// Format a Parquet record, writing a row group if enough data is buffered.
+func (f *parquetFormatter) Format(record [][]byte) error {
+	if len(f.chunks) != len(record) {
+		return errors.New("record length does not match number of columns")
+	}
+
+	for i, item := range record {
+		if err := f.chunks[i].append(item); err != nil {
+			return fmt.Errorf("transforming data: %w", err)
+		}
+		f.size += len(item)
+	}
+	f.rows++
+
+	if f.size >= parquetRowGroupSize {
+		return f.flush()
+	}
+
+	return nil
+}
+
+// Close the Parquet formatter after writing any buffered rows and the file footer.
+func (f *parquetFormatter) Close() error {
+	if f.rows > 0 {
+		if err := f.flush(); err != nil {
+			return err
+		}
+	}
+
+	var (
+		footer = parquetFileMetaData(f.chunks, f.groups, f.total)
+		length [4]byte
+	)
+	binary.LittleEndian.PutUint32(length[:], uint32(len(footer)))
+	for _, b := range [][]byte{footer, length[:], []byte(parquetMagic)} {
+		if _, err := f.w.Write(b); err != nil {
+			return fmt.Errorf("closing parquet formatter: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Extension returns the default Parquet formatter extension.
+func (*parquetFormatter) Extension() string {
+	return "parquet"
+}
+
+// PreserveNull reports that the Parquet formatter encodes nulls natively.
+func (*parquetFormatter) PreserveNull() bool {
+	return true
+}
+
+func (f *parquetFormatter) flush() error {
+	group := parquetRowGroup{
+		rows: f.rows,
+	}
+	for _, chunk := range f.chunks {
+		var (
+			page   = chunk.page()
+			header = parquetPageHeader(len(chunk.levels), len(page))
+			offset = f.w.offset
+		)
+		if _, err := f.w.Write(header); err != nil {
+			return fmt.Errorf("writing page header: %w", err)
+		}
+		if _, err := f.w.Write(page); err != nil {
+			return fmt.Errorf("writing page: %w", err)
+		}
+
+		column := parquetColumnChunk{
+			kind:   chunk.physicalType(),
+			name:   chunk.name,
+			offset: offset,
+			size:   f.w.offset - offset,
+			values: int64(len(chunk.levels)),
+		}
+		group.columns = append(group.columns, column)
+		group.size += column.size
+		chunk.reset()
+	}
+
+	f.groups = append(f.groups, group)
+	f.total += f.rows
+	f.rows, f.size = 0, 0
+
+	return nil
+}
+
+type avroFormatter struct {
+	w         io.Writer
+	columns   []Column
+	types     []avroColumn
+	codec     AvroCodec
+	blockSize int
+	sync      []byte
+	block     bytes.Buffer
+	count     int64
+}
+
+// Avro returns an initialized Apache Avro object container file formatter using the null codec.
+// DECIMAL and NUMERIC columns and unsigned 64-bit integers use the Avro decimal logical type,
+// or strings where the driver doesn't report a decimal's precision and scale.
+func Avro(w io.Writer, columns []Column) Formatter {
+	return AvroWith(AvroNull, avroBlockSize)(w, columns)
+}
+
+// AvroWith returns an Avro FormatterFunc that writes blocks of approximately blockSize bytes
+// compressed with the given codec. The Avro record schema is derived from the columns, whose
+// names are sanitized into unique Avro names.
+func AvroWith(codec AvroCodec, blockSize int) FormatterFunc {
+	return func(w io.Writer, columns []Column) Formatter {
+		return &avroFormatter{
+			w:         w,
+			columns:   columns,
+			types:     avroColumns(columns),
+			codec:     codec,
+			blockSize: blockSize,
+		}
+	}
+}
+
+// Open the Avro formatter by writing the file header and embedded schema.
+func (f *avroFormatter) Open() error {
+	if !validAvroCodec(f.codec) {
+		return fmt.Errorf("unsupported avro codec '%s'", f.codec)
+	}
+
+	schema, err := buildAvroSchema(f.columns, f.types)
+	if err != nil {
+		return fmt.Errorf("building schema: %w", err)
+	}
+
+	f.sync = make([]byte, 16)
+	if _, err := rand.Read(f.sync); err != nil {
+		return fmt.Errorf("generating sync marker: %w", err)
+	}
+
+	if _, err := f.w.Write(avroHeader(schema, f.codec, f.sync)); err != nil {
+		return fmt.Errorf("writing header: %w", err)
+	}
+
+	return nil
+}
+
+// Format an Avro record, writing a block if enough data is buffered.
+func (f *avroFormatter) Format(record [][]byte) error {
+	if len(f.columns) != len(record) {
+		return errors.New("record length does not match number of columns")
+	}
+
+	for i, item := range record {
+		if err := f.types[i].encode(&f.block, item); err != nil {
+			return fmt.Errorf("transforming data: %w", err)
+		}
+	}
+	f.count++
+
+	if f.block.Len() >= f.blockSize {
+		return f.flush()
+	}
+
+	return nil
+}
+
+// Close the Avro formatter after writing any buffered records.
+func (f *avroFormatter) Close() error {
+	if f.count > 0 {
+		return f.flush()
+	}
+
+	return nil
+}
+
+// Extension returns the default Avro formatter extension.
+func (*avroFormatter) Extension() string {
+	return "avro"
+}
+
+// PreserveNull reports that the Avro formatter encodes nulls natively.
+func (*avroFormatter) PreserveNull() bool {
+	return true
+}
+
+func (f *avroFormatter) flush() error {
+	data, err := avroCompress(f.codec, f.block.Bytes())
+	if err != nil {
+		return fmt.Errorf("compressing block: %w", err)
+	}
+
+	var b bytes.Buffer
+	writeVarint(&b, f.count)
+	writeVarint(&b, int64(len(data)))
+	b.Write(data)
+	b.Write(f.sync)
+	if _, err := f.w.Write(b.Bytes()); err != nil {
+		return fmt.Errorf("writing block: %w", err)
+	}
+
+	f.block.Reset()
+	f.count = 0
+
+	return nil
+}
+
 func buildMap(record [][]byte, columns []Column) (map[string]interface{}, error) {
 	m := make(map[string]interface{})
 	for i, column := range columns {
@@ -234,42 +546,91 @@ func buildMap(record [][]byte, columns []Column) (map[string]interface{}, error)
 }
 
 var pattern = struct {
-	boolean, integer, float *regexp.Regexp
+	boolean, integer, float, decimal, uint64 *regexp.Regexp
 }{
 	boolean: regexp.MustCompile("BOOL*"),
-	integer: regexp.MustCompile("INT*"),
+	integer: regexp.MustCompile(`^(UNSIGNED )?(TINY|SMALL|MEDIUM|BIG)?INT(EGER)?[0-9]*$`),
 	float:   regexp.MustCompile("DECIMAL*|FLOAT*|NUMERIC*|DOUBLE*"),
+	decimal: regexp.MustCompile("DECIMAL|NUMERIC"),
+	uint64:  regexp.MustCompile(`^(UNSIGNED BIGINT|BIGINT UNSIGNED|UINT64)$`),
 }
 
-func parse(v []byte, c Column) (interface{}, error) {
-	if v == nil {
-		return nil, nil
-	}
+type kind int
 
-	var (
-		s = string(v)
-		t = c.ScanType()
-	)
-	if t != nil {
+const (
+	text kind = iota
+	boolean
+	integer
+	float
+)
+
+func kindOf(c Column) kind {
+	if t := c.ScanType(); t != nil {
 		switch t.Kind() {
 		case reflect.Bool:
-			return strconv.ParseBool(s)
+			return boolean
 		case reflect.Float32, reflect.Float64:
-			return strconv.ParseFloat(s, 64)
+			return float
 		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
 			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-			return strconv.Atoi(s)
+			return integer
 		}
 	}
 
 	d := c.DatabaseTypeName()
 	switch {
 	case pattern.boolean.MatchString(d):
-		return strconv.ParseBool(s)
+		return boolean
 	case pattern.float.MatchString(d):
-		return strconv.ParseFloat(s, 64)
+		return float
 	case pattern.integer.MatchString(d):
-		return strconv.Atoi(s)
+		return integer
+	}
+
+	return text
+}
+
+// maxUint64Precision is the number of decimal digits of the largest unsigned 64-bit integer.
+const maxUint64Precision = 20
+
+// decimalOf reports whether a column's values are written as exact decimals by formats with typed
+// schemas, returning their precision and scale if known: DECIMAL and NUMERIC columns, and unsigned 64-bit
+// integers, which may exceed the range of int64.
+func decimalOf(c Column) (precision, scale int, ok bool) {
+	if t := c.ScanType(); (t != nil && (t.Kind() == reflect.Uint64 || t.Kind() == reflect.Uint)) ||
+		pattern.uint64.MatchString(strings.ToUpper(c.DatabaseTypeName())) {
+		return maxUint64Precision, 0, true
+	}
+	if !pattern.decimal.MatchString(c.DatabaseTypeName()) {
+		return 0, 0, false
+	}
+	if d, ok := c.(interface{ DecimalSize() (int64, int64, bool) }); ok {
+		if precision, scale, ok := d.DecimalSize(); ok && precision > 0 {
+			return int(precision), int(scale), true
+		}
+	}
+
+	return 0, 0, true
+}
+
+func parse(v []byte, c Column) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	s := string(v)
+	switch kindOf(c) {
+	case boolean:
+		return strconv.ParseBool(s)
+	case float:
+		return strconv.ParseFloat(s, 64)
+	case integer:
+		n, err := strconv.Atoi(s)
+		if errors.Is(err, strconv.ErrRange) && !strings.HasPrefix(s, "-") {
+			// Unsigned 64-bit integers may exceed the range of int.
+			return strconv.ParseUint(s, 10, 64)
+		}
+		return n, err
 	}
 
 	return s, nil
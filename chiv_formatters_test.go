@@ -4,10 +4,19 @@ package chiv_test
 
 import (
 	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
 	"reflect"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
 
 	"gavincabbage.com/chiv"
 )
@@ -108,6 +117,380 @@ func TestJsonFormatter(t *testing.T) {
 	test(t, expected, chiv.JSON)
 }
 
+func TestNdjsonFormatter(t *testing.T) {
+	expected := []string{`
+{"first_column":1,"fourth_column":6,"second_column":"first_row","third_column":100}
+{"first_column":2,"fourth_column":7,"second_column":"second_row","third_column":12.12}
+{"first_column":3,"fourth_column":8,"second_column":"third_row","third_column":42.42}
+`,
+	}
+
+	test(t, expected, chiv.NDJSON)
+}
+
+func TestParquetFormatter(t *testing.T) {
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				b       = bytes.Buffer{}
+				columns = make([]chiv.Column, len(test.columns))
+			)
+			for i := range test.columns {
+				columns[i] = test.columns[i]
+			}
+
+			subject := chiv.Parquet(&b, columns)
+			assert.NoError(t, subject.Open())
+
+			for _, record := range test.records {
+				assert.NoError(t, subject.Format(record))
+			}
+			assert.Error(t, subject.Format([][]byte{[]byte("not enough columns")}))
+
+			assert.NoError(t, subject.Close())
+
+			schema, values := readParquet(t, b.Bytes())
+			require.Len(t, schema, 5)
+			for i, expected := range []parquet.Type{parquet.Type_INT64, parquet.Type_BYTE_ARRAY, parquet.Type_DOUBLE, parquet.Type_INT64} {
+				assert.Equal(t, test.columns[i].name, schema[i+1].GetName())
+				assert.Equal(t, expected, schema[i+1].GetType())
+				assert.Equal(t, parquet.FieldRepetitionType_OPTIONAL, schema[i+1].GetRepetitionType())
+			}
+			assert.Equal(t, parquet.ConvertedType_UTF8, schema[2].GetConvertedType())
+
+			assert.Equal(t, [][]interface{}{
+				{int64(1), int64(2), int64(3)},
+				{"first_row", "second_row", "third_row"},
+				{100.0, 12.12, 42.42},
+				{int64(6), int64(7), int64(8)},
+			}, values)
+
+			assert.Equal(t, "parquet", subject.(chiv.Extensioner).Extension())
+		})
+	}
+}
+
+func TestParquetFormatterTypes(t *testing.T) {
+	var (
+		b       = bytes.Buffer{}
+		columns = []chiv.Column{
+			column{name: "flag", databaseType: "BOOLEAN", scanType: reflect.TypeOf(false)},
+			decimalColumn{column: column{name: "price", databaseType: "DECIMAL"}, precision: 10, scale: 2},
+			decimalColumn{column: column{name: "total", databaseType: "NUMERIC"}, precision: 30, scale: 3},
+			column{name: "amount", databaseType: "DECIMAL", scanType: reflect.TypeOf(0.0)},
+		}
+		subject = chiv.Parquet(&b, columns)
+	)
+
+	require.NoError(t, subject.Open())
+	require.NoError(t, subject.Format([][]byte{[]byte("true"), []byte("12.34"), []byte("123456789012345678901234.5"), []byte("0.1")}))
+	require.NoError(t, subject.Format([][]byte{nil, nil, nil, nil}))
+	require.NoError(t, subject.Format([][]byte{[]byte("false"), []byte("-0.05"), []byte("-1"), []byte("12345678901234567890.123")}))
+	require.NoError(t, subject.Close())
+
+	schema, values := readParquet(t, b.Bytes())
+	require.Len(t, schema, 5)
+
+	assert.Equal(t, parquet.Type_BOOLEAN, schema[1].GetType())
+
+	assert.Equal(t, parquet.Type_INT64, schema[2].GetType())
+	assert.Equal(t, parquet.ConvertedType_DECIMAL, schema[2].GetConvertedType())
+	assert.Equal(t, int32(10), schema[2].GetPrecision())
+	assert.Equal(t, int32(2), schema[2].GetScale())
+	assert.Equal(t, int32(2), schema[2].GetLogicalType().GetDECIMAL().GetScale())
+
+	assert.Equal(t, parquet.Type_BYTE_ARRAY, schema[3].GetType())
+	assert.Equal(t, parquet.ConvertedType_DECIMAL, schema[3].GetConvertedType())
+	assert.Equal(t, int32(30), schema[3].GetPrecision())
+	assert.Equal(t, int32(3), schema[3].GetScale())
+
+	assert.Equal(t, parquet.Type_BYTE_ARRAY, schema[4].GetType())
+	assert.Equal(t, parquet.ConvertedType_UTF8, schema[4].GetConvertedType())
+
+	assert.Equal(t, []interface{}{true, nil, false}, values[0])
+	assert.Equal(t, []interface{}{int64(1234), nil, int64(-5)}, values[1])
+	assert.Equal(t, []interface{}{"0.1", nil, "12345678901234567890.123"}, values[3])
+
+	large, ok := new(big.Int).SetString("123456789012345678901234500", 10)
+	require.True(t, ok)
+	require.Len(t, values[2], 3)
+	assert.Equal(t, large, unscaled(values[2][0].(string)))
+	assert.Nil(t, values[2][1])
+	assert.Equal(t, big.NewInt(-1000), unscaled(values[2][2].(string)))
+
+	assert.Error(t, subject.Format([][]byte{[]byte("true"), []byte("0.001"), nil, nil}))
+}
+
+func TestParquetFormatterKinds(t *testing.T) {
+	var (
+		b       = bytes.Buffer{}
+		columns = []chiv.Column{
+			column{name: "address", databaseType: "INET"},
+			column{name: "elapsed", databaseType: "INTERVAL"},
+			column{name: "ids", databaseType: "_INT4"},
+			column{name: "count", databaseType: "INT4"},
+			column{name: "total", databaseType: "UNSIGNED BIGINT", scanType: reflect.TypeOf(uint64(0))},
+		}
+		subject = chiv.Parquet(&b, columns)
+	)
+
+	require.NoError(t, subject.Open())
+	require.NoError(t, subject.Format([][]byte{[]byte("10.0.0.1"), []byte("1 day"), []byte("{1,2}"), []byte("3"), []byte("18446744073709551615")}))
+	require.NoError(t, subject.Close())
+
+	schema, values := readParquet(t, b.Bytes())
+	require.Len(t, schema, 6)
+	for i := 1; i <= 3; i++ {
+		assert.Equal(t, parquet.Type_BYTE_ARRAY, schema[i].GetType())
+		assert.Equal(t, parquet.ConvertedType_UTF8, schema[i].GetConvertedType())
+	}
+	assert.Equal(t, parquet.Type_INT64, schema[4].GetType())
+	assert.Equal(t, parquet.ConvertedType_DECIMAL, schema[5].GetConvertedType())
+	assert.Equal(t, int32(20), schema[5].GetPrecision())
+
+	assert.Equal(t, []interface{}{"10.0.0.1"}, values[0])
+	assert.Equal(t, []interface{}{"{1,2}"}, values[2])
+	assert.Equal(t, []interface{}{int64(3)}, values[3])
+	max, ok := new(big.Int).SetString("18446744073709551615", 10)
+	require.True(t, ok)
+	assert.Equal(t, max, unscaled(values[4][0].(string)))
+}
+
+func TestJsonFormatterUnsigned(t *testing.T) {
+	var (
+		b       = bytes.Buffer{}
+		columns = []chiv.Column{column{name: "total", databaseType: "UNSIGNED BIGINT", scanType: reflect.TypeOf(uint64(0))}}
+		subject = chiv.JSON(&b, columns)
+	)
+
+	require.NoError(t, subject.Open())
+	require.NoError(t, subject.Format([][]byte{[]byte("18446744073709551615")}))
+	require.NoError(t, subject.Format([][]byte{[]byte("1")}))
+	require.NoError(t, subject.Close())
+	require.JSONEq(t, `[{"total":18446744073709551615},{"total":1}]`, b.String())
+	require.Contains(t, b.String(), "18446744073709551615")
+}
+
+func TestArchiveParquetNull(t *testing.T) {
+	f := &fixture{
+		columns: []string{"id", "name"},
+		types:   []string{"INTEGER", "TEXT"},
+		rows:    [][]interface{}{{"1", "first"}, {"2", nil}},
+	}
+	db := newFixture(t, f)
+	defer db.Close()
+
+	uploader := &uploader{}
+	require.NoError(t, chiv.NewArchiver(db, uploader, chiv.WithFormat(chiv.Parquet), chiv.WithNull("NULL")).Archive("events", "bucket"))
+
+	_, values := readParquet(t, []byte(uploader.uploads["events.parquet"]))
+	assert.Equal(t, [][]interface{}{{int64(1), int64(2)}, {"first", nil}}, values)
+}
+
+// readParquet decodes a Parquet file, returning its schema and the values of each column.
+func readParquet(t *testing.T, b []byte) ([]*parquet.SchemaElement, [][]interface{}) {
+	t.Helper()
+
+	file, err := buffer.NewBufferFile(b)
+	require.NoError(t, err)
+	r, err := reader.NewParquetColumnReader(file, 1)
+	require.NoError(t, err)
+	defer r.ReadStop()
+
+	values := make([][]interface{}, len(r.Footer.Schema)-1)
+	for i := range values {
+		values[i], _, _, err = r.ReadColumnByIndex(int64(i), r.GetNumRows())
+		require.NoError(t, err)
+	}
+	for i, element := range r.Footer.Schema {
+		element.Name = r.SchemaHandler.GetExName(i)
+	}
+
+	return r.Footer.Schema, values
+}
+
+// unscaled decodes the big-endian two's complement unscaled value of a Parquet or Avro decimal.
+func unscaled(s string) *big.Int {
+	n := new(big.Int).SetBytes([]byte(s))
+	if len(s) > 0 && s[0]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(8*len(s))))
+	}
+
+	return n
+}
+
+func TestAvroFormatter(t *testing.T) {
+	for _, codec := range []chiv.AvroCodec{chiv.AvroNull, chiv.AvroDeflate, chiv.AvroSnappy} {
+		for _, test := range cases {
+			t.Run(fmt.Sprintf("%s %s", codec, test.name), func(t *testing.T) {
+				var (
+					b       = bytes.Buffer{}
+					columns = make([]chiv.Column, len(test.columns))
+				)
+				for i := range test.columns {
+					columns[i] = test.columns[i]
+				}
+
+				subject := chiv.AvroWith(codec, 16)(&b, columns)
+				assert.NoError(t, subject.Open())
+
+				for _, record := range test.records {
+					assert.NoError(t, subject.Format(record))
+				}
+				assert.NoError(t, subject.Format([][]byte{nil, nil, nil, nil}))
+				assert.Error(t, subject.Format([][]byte{[]byte("not enough columns")}))
+
+				assert.NoError(t, subject.Close())
+
+				out := b.String()
+				assert.Equal(t, "Obj\x01", out[:4])
+				assert.Contains(t, out, `{"name":"first_column","type":["null","long"]}`)
+				assert.Contains(t, out, `{"name":"second_column","type":["null","string"]}`)
+				assert.Contains(t, out, `{"name":"third_column","type":["null","double"]}`)
+				assert.Contains(t, out, string(codec))
+
+				assert.Equal(t, "avro", subject.(chiv.Extensioner).Extension())
+				assert.True(t, subject.(chiv.NullPreserver).PreserveNull())
+			})
+		}
+	}
+}
+
+func TestAvroFormatterNames(t *testing.T) {
+	var (
+		b       = bytes.Buffer{}
+		columns = []chiv.Column{
+			column{name: "a-b", databaseType: "TEXT"},
+			column{name: "a_b", databaseType: "TEXT"},
+			column{name: "a.b", databaseType: "TEXT"},
+			column{name: "1st", databaseType: "TEXT"},
+		}
+		subject = chiv.Avro(&b, columns)
+	)
+
+	require.NoError(t, subject.Open())
+	require.NoError(t, subject.Close())
+	assert.Contains(t, b.String(), `"fields":[{"name":"a_b_2","type":["null","string"]},{"name":"a_b","type":["null","string"]},`+
+		`{"name":"a_b_3","type":["null","string"]},{"name":"_1st","type":["null","string"]}]`)
+}
+
+func TestAvroFormatterCodec(t *testing.T) {
+	var (
+		b       = bytes.Buffer{}
+		subject = chiv.AvroWith("zstandard", 16)(&b, []chiv.Column{column{name: "id", databaseType: "INTEGER"}})
+	)
+
+	require.EqualError(t, subject.Open(), "unsupported avro codec 'zstandard'")
+	require.Zero(t, b.Len())
+}
+
+func TestAvroFormatterDecimals(t *testing.T) {
+	var (
+		b       = bytes.Buffer{}
+		columns = []chiv.Column{
+			decimalColumn{column: column{name: "price", databaseType: "DECIMAL"}, precision: 19, scale: 2},
+			column{name: "amount", databaseType: "NUMERIC", scanType: reflect.TypeOf(0.0)},
+			column{name: "total", databaseType: "BIGINT UNSIGNED", scanType: reflect.TypeOf(uint64(0))},
+		}
+		subject = chiv.Avro(&b, columns)
+	)
+
+	require.NoError(t, subject.Open())
+	require.NoError(t, subject.Format([][]byte{[]byte("12345678901234567.89"), []byte("0.1"), []byte("18446744073709551615")}))
+	require.NoError(t, subject.Format([][]byte{[]byte("-0.05"), nil, nil}))
+	require.NoError(t, subject.Close())
+
+	schema, records := readAvro(t, b.Bytes())
+	assert.Contains(t, schema, `{"name":"price","type":["null",{"type":"bytes","logicalType":"decimal","precision":19,"scale":2}]}`)
+	assert.Contains(t, schema, `{"name":"amount","type":["null","string"]}`)
+	assert.Contains(t, schema, `{"name":"total","type":["null",{"type":"bytes","logicalType":"decimal","precision":20,"scale":0}]}`)
+
+	require.Len(t, records, 2)
+	assert.Equal(t, "12345678901234567.89", new(big.Rat).SetFrac(unscaled(records[0][0].(string)), big.NewInt(100)).FloatString(2))
+	assert.Equal(t, "0.1", records[0][1])
+	assert.Equal(t, "18446744073709551615", unscaled(records[0][2].(string)).String())
+	assert.Equal(t, big.NewInt(-5), unscaled(records[1][0].(string)))
+	assert.Equal(t, []interface{}{nil, nil}, records[1][1:])
+
+	assert.Error(t, subject.Format([][]byte{[]byte("0.001"), nil, nil}))
+}
+
+// readAvro decodes an Avro object container file written with the null codec, returning its
+// schema and records.
+func readAvro(t *testing.T, b []byte) (string, [][]interface{}) {
+	t.Helper()
+
+	r := bytes.NewReader(b[4:])
+	long := func() int64 {
+		n, err := binary.ReadVarint(r)
+		require.NoError(t, err)
+		return n
+	}
+	str := func() string {
+		s := make([]byte, long())
+		_, err := r.Read(s)
+		require.NoError(t, err)
+		return string(s)
+	}
+
+	metadata := make(map[string]string)
+	for n := long(); n != 0; n = long() {
+		for ; n > 0; n-- {
+			metadata[str()] = str()
+		}
+	}
+	require.Equal(t, "null", metadata["avro.codec"])
+	r.Seek(16, io.SeekCurrent)
+
+	var schema struct {
+		Fields []struct {
+			Type interface{}
+		}
+	}
+	require.NoError(t, json.Unmarshal([]byte(metadata["avro.schema"]), &schema))
+
+	var decode func(interface{}) interface{}
+	decode = func(typ interface{}) interface{} {
+		switch typ := typ.(type) {
+		case []interface{}:
+			return decode(typ[long()])
+		case map[string]interface{}:
+			return decode(typ["type"])
+		}
+		switch typ {
+		case "null":
+			return nil
+		case "long":
+			return long()
+		case "double":
+			var v float64
+			require.NoError(t, binary.Read(r, binary.LittleEndian, &v))
+			return v
+		case "boolean":
+			v, err := r.ReadByte()
+			require.NoError(t, err)
+			return v == 1
+		}
+		return str()
+	}
+
+	var records [][]interface{}
+	for r.Len() > 0 {
+		count := long()
+		long()
+		for ; count > 0; count-- {
+			record := make([]interface{}, len(schema.Fields))
+			for i, field := range schema.Fields {
+				record[i] = decode(field.Type)
+			}
+			records = append(records, record)
+		}
+		r.Seek(16, io.SeekCurrent)
+	}
+
+	return metadata["avro.schema"], records
+}
+
 func test(t *testing.T, expected []string, format chiv.FormatterFunc) {
 	for i, test := range cases {
 		t.Run(test.name, func(t *testing.T) {
@@ -132,6 +515,15 @@ func test(t *testing.T, expected []string, format chiv.FormatterFunc) {
 	}
 }
 
+type decimalColumn struct {
+	column
+	precision, scale int64
+}
+
+func (c decimalColumn) DecimalSize() (int64, int64, bool) {
+	return c.precision, c.scale, true
+}
+
 type column struct {
 	databaseType string
 	name         string
@@ -0,0 +1,145 @@
+package chiv
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// rowHookInterval is the minimum interval between calls to OnRow by each download.
+const rowHookInterval = 100 * time.Millisecond
+
+// Stage is the stage of an archive reported by OnError.
+type Stage string
+
+// Archive stages.
+const (
+	StageQuery      Stage = "query"
+	StageDownload   Stage = "download"
+	StageScan       Stage = "scan"
+	StageFormat     Stage = "format"
+	StageUpload     Stage = "upload"
+	StageVerify     Stage = "verify"
+	StageCheckpoint Stage = "checkpoint"
+)
+
+// Hooks are callbacks invoked as an archive progresses, e.g. to log, report progress or instrument archives.
+// Any hook may be nil. Hooks are called with the context of the archive and the archived table, which is
+// empty when archiving rows. They may be called concurrently by the downloads and uploads of an archive,
+// or archives of multiple tables, and should return quickly.
+type Hooks struct {
+	// OnQuery is called before a table is queried, with the bucket it is archived to. It returns the
+	// context the table is queried with, e.g. carrying a trace span, or nil to query it with ctx.
+	OnQuery func(ctx context.Context, table, bucket string) context.Context
+	// OnStart is called when the rows of a table are first read, with their columns.
+	OnStart func(ctx context.Context, table string, columns []Column)
+	// OnRow is called as rows are archived, at most every 100ms per download and once more when a
+	// download completes, with the total rows archived and bytes formatted so far.
+	OnRow func(ctx context.Context, table string, rows, bytes int64)
+	// OnFormatterOpen is called when an object is opened, before its formatter is opened. It returns the
+	// context the object is uploaded and verified with, or nil to upload it with ctx.
+	OnFormatterOpen func(ctx context.Context, table, key string) context.Context
+	// OnFormatterClose is called when the formatter of an object is closed.
+	OnFormatterClose func(ctx context.Context, table, key string)
+	// OnUploadPart is called when each object of an archive is uploaded.
+	OnUploadPart func(ctx context.Context, table string, object *ObjectResult)
+	// OnComplete is called when an archive completes successfully.
+	OnComplete func(ctx context.Context, table string, result *Result)
+	// OnError is called when an archive fails, with the stage that failed. It is called once per archive:
+	// when one stage fails the archive is canceled, and other stages failing as a result are not reported.
+	OnError func(ctx context.Context, table string, stage Stage, err error)
+}
+
+// query calls OnQuery, returning the context to query the table with.
+func (h *Hooks) query(ctx context.Context, table, bucket string) context.Context {
+	if h.OnQuery != nil {
+		if c := h.OnQuery(ctx, table, bucket); c != nil {
+			return c
+		}
+	}
+
+	return ctx
+}
+
+func (h *Hooks) start(ctx context.Context, table string, columns []Column) {
+	if h.OnStart != nil {
+		h.OnStart(ctx, table, columns)
+	}
+}
+
+// formatterOpen calls OnFormatterOpen, returning the context to upload the object with.
+func (h *Hooks) formatterOpen(ctx context.Context, table, key string) context.Context {
+	if h.OnFormatterOpen != nil {
+		if c := h.OnFormatterOpen(ctx, table, key); c != nil {
+			return c
+		}
+	}
+
+	return ctx
+}
+
+func (h *Hooks) formatterClose(ctx context.Context, table, key string) {
+	if h.OnFormatterClose != nil {
+		h.OnFormatterClose(ctx, table, key)
+	}
+}
+
+func (h *Hooks) uploadPart(ctx context.Context, table string, object *ObjectResult) {
+	if h.OnUploadPart != nil {
+		h.OnUploadPart(ctx, table, object)
+	}
+}
+
+// fail calls OnError for the first failure of an archive, returning the error.
+func (a *Archiver) fail(ctx context.Context, table string, stage Stage, err error) error {
+	if a.hooks.OnError != nil {
+		a.results.failing(func() {
+			a.hooks.OnError(ctx, table, stage, err)
+		})
+	}
+
+	return err
+}
+
+// complete totals the results of a completed archive and calls OnComplete.
+func (a *Archiver) complete(ctx context.Context) *Result {
+	result := a.results.result()
+	if a.hooks.OnComplete != nil {
+		a.hooks.OnComplete(ctx, a.results.table, result)
+	}
+
+	return result
+}
+
+// progress counts the rows of a download, calling OnRow at most once per interval.
+type progress struct {
+	ctx      context.Context
+	archiver *Archiver
+	table    string
+	last     time.Time
+}
+
+func (a *Archiver) newProgress(ctx context.Context, table string) *progress {
+	return &progress{ctx: ctx, archiver: a, table: table, last: time.Now()}
+}
+
+// row counts an archived row.
+func (p *progress) row() {
+	atomic.AddInt64(&p.archiver.results.rows, 1)
+	if p.archiver.hooks.OnRow == nil {
+		return
+	}
+
+	if now := time.Now(); now.Sub(p.last) >= rowHookInterval {
+		p.last = now
+		p.report()
+	}
+}
+
+// report calls OnRow with the rows archived and bytes formatted so far.
+func (p *progress) report() {
+	if p.archiver.hooks.OnRow != nil {
+		results := p.archiver.results
+		p.archiver.hooks.OnRow(p.ctx, p.table, atomic.LoadInt64(&results.rows), atomic.LoadInt64(&results.bytes))
+	}
+}
@@ -0,0 +1,201 @@
+// +build unit
+
+package chiv_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gavincabbage.com/chiv"
+)
+
+// events records the hooks called by an archive.
+type events struct {
+	mu       sync.Mutex
+	queried  []string
+	started  []int
+	rows     []int64
+	bytes    []int64
+	opened   []string
+	closed   []string
+	uploaded []string
+	result   *chiv.Result
+	errs     map[chiv.Stage]string
+	failures int
+}
+
+func (e *events) hooks() chiv.Hooks {
+	return chiv.Hooks{
+		OnQuery: func(_ context.Context, table, bucket string) context.Context {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			e.queried = append(e.queried, table+":"+bucket)
+			return nil
+		},
+		OnStart: func(_ context.Context, table string, columns []chiv.Column) {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			e.started = append(e.started, len(columns))
+		},
+		OnRow: func(_ context.Context, table string, rows, bytes int64) {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			e.rows = append(e.rows, rows)
+			e.bytes = append(e.bytes, bytes)
+		},
+		OnFormatterOpen: func(_ context.Context, table, key string) context.Context {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			e.opened = append(e.opened, key)
+			return nil
+		},
+		OnFormatterClose: func(_ context.Context, table, key string) {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			e.closed = append(e.closed, key)
+		},
+		OnUploadPart: func(_ context.Context, table string, object *chiv.ObjectResult) {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			e.uploaded = append(e.uploaded, object.Key)
+		},
+		OnComplete: func(_ context.Context, table string, result *chiv.Result) {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			e.result = result
+		},
+		OnError: func(_ context.Context, table string, stage chiv.Stage, err error) {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			if e.errs == nil {
+				e.errs = make(map[chiv.Stage]string)
+			}
+			e.errs[stage] = err.Error()
+			e.failures++
+		},
+	}
+}
+
+func TestArchiveHooks(t *testing.T) {
+	var (
+		rows = &rows{
+			columns: []string{"first_column", "second_column"},
+			scan:    [][]string{{"first", "1"}, {"second", "2"}, {"third", "3"}},
+		}
+		events  = &events{}
+		subject = chiv.NewArchiver(nil, &uploader{}, chiv.WithFormat(writing), chiv.WithHooks(events.hooks()))
+	)
+
+	result, err := subject.ArchiveRowsWithResult(rows, "bucket", chiv.WithMaxRowsPerObject(2))
+	require.NoError(t, err)
+
+	sort.Strings(events.uploaded)
+	require.Equal(t, []int{2}, events.started)
+	require.Equal(t, int64(3), events.rows[len(events.rows)-1])
+	require.Equal(t, int64(len("first,1\nsecond,2\nthird,3\n")), events.bytes[len(events.bytes)-1])
+	require.Equal(t, []string{"table-00001", "table-00002"}, events.opened)
+	require.Equal(t, []string{"table-00001", "table-00002"}, events.closed)
+	require.Equal(t, []string{"table-00001", "table-00002"}, events.uploaded)
+	require.Equal(t, result, events.result)
+	require.Nil(t, events.queried)
+	require.Nil(t, events.errs)
+}
+
+func TestArchiveHooksErrors(t *testing.T) {
+	cases := []struct {
+		name     string
+		scanErr  error
+		uploader *uploader
+		format   chiv.FormatterFunc
+		expected map[chiv.Stage]string
+	}{
+		{
+			name:     "scan",
+			scanErr:  errors.New("scanning"),
+			uploader: &uploader{},
+			format:   writing,
+			expected: map[chiv.Stage]string{chiv.StageScan: "chiv: downloading: scanning row: scanning"},
+		},
+		{
+			name:     "format",
+			uploader: &uploader{},
+			format:   failing,
+			expected: map[chiv.Stage]string{chiv.StageFormat: "chiv: downloading: formatting row: formatting"},
+		},
+		{
+			name:     "upload",
+			uploader: &uploader{uploadErr: errors.New("uploading")},
+			format:   writing,
+			expected: map[chiv.Stage]string{chiv.StageUpload: "chiv: uploading: uploading"},
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				rows = &rows{
+					columns: []string{"first_column"},
+					scan:    [][]string{{"first"}},
+					scanErr: test.scanErr,
+				}
+				events  = &events{}
+				subject = chiv.NewArchiver(nil, test.uploader, chiv.WithFormat(test.format), chiv.WithHooks(events.hooks()))
+			)
+
+			_, err := subject.ArchiveRowsWithResult(rows, "bucket")
+			require.Error(t, err)
+			require.Nil(t, events.result)
+			require.Equal(t, test.expected, events.errs)
+		})
+	}
+
+	t.Run("canceled", func(t *testing.T) {
+		var (
+			rows    = &rows{columns: []string{"first_column"}, scan: [][]string{{"first"}, {"second"}}}
+			events  = &events{}
+			subject = chiv.NewArchiver(nil, rejecting{}, chiv.WithFormat(writing), chiv.WithHooks(events.hooks()))
+		)
+
+		require.Error(t, subject.ArchiveRows(rows, "bucket"))
+		require.Equal(t, map[chiv.Stage]string{chiv.StageUpload: "chiv: uploading: rejected"}, events.errs)
+		require.Equal(t, 1, events.failures)
+	})
+
+	t.Run("query", func(t *testing.T) {
+		db := newFixture(t, &fixture{})
+		defer db.Close()
+
+		events := &events{}
+		subject := chiv.NewArchiver(db, &uploader{}, chiv.WithHooks(events.hooks()))
+
+		require.Error(t, subject.Archive("events", "bucket", chiv.WithParallelism(2, "")))
+		require.Equal(t, []string{"events:bucket"}, events.queried)
+		require.Equal(t, map[chiv.Stage]string{chiv.StageQuery: "chiv: querying 'events': parallelism requires a split column"}, events.errs)
+	})
+
+	t.Run("verify", func(t *testing.T) {
+		var (
+			rows     = &rows{columns: []string{"first_column"}, scan: [][]string{{"first"}}}
+			events   = &events{}
+			uploader = &uploader{}
+			verifier = &verifier{uploader: uploader, corrupt: func(b []byte) []byte { return nil }}
+			subject  = chiv.NewArchiver(nil, uploader, chiv.WithFormat(writing), chiv.WithHooks(events.hooks()), chiv.WithVerify(verifier, chiv.VerifySize))
+		)
+
+		require.Error(t, subject.ArchiveRows(rows, "bucket"))
+		require.Equal(t, map[chiv.Stage]string{chiv.StageVerify: "chiv: verifying 'table': uploaded 6 bytes, found 0"}, events.errs)
+	})
+}
+
+// rejecting fails uploads without reading them, failing the downloads writing to them.
+type rejecting struct{}
+
+func (rejecting) Upload(context.Context, string, string, *chiv.Attributes, io.Reader) (*chiv.ObjectInfo, error) {
+	return nil, errors.New("rejected")
+}
@@ -0,0 +1,202 @@
+package chiv
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// quote each part of a schema-qualified identifier between open and close, escaping close by doubling it.
+func quote(identifier, open, close string) string {
+	parts := strings.Split(identifier, ".")
+	for i, part := range parts {
+		parts[i] = open + strings.Replace(part, close, close+close, -1) + close
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// validate the table and the columns to be archived from it against the database catalog, returning
+// the catalog's name of the table and replacing the configured column names with the catalog's, as quoted
+// names are case-sensitive in some databases.
+func (a *Archiver) validate(ctx context.Context, table string) (_ string, err error) {
+	if err := validateName(table); err != nil {
+		return "", err
+	}
+
+	known, err := a.catalog(ctx, table)
+	if err != nil {
+		return "", err
+	}
+	if known == nil {
+		return table, nil
+	}
+	if len(known) == 0 {
+		if table, err = a.resolveTable(ctx, table); err != nil {
+			return "", err
+		}
+		if known, err = a.catalog(ctx, table); err != nil {
+			return "", err
+		}
+	}
+	if len(known) == 0 {
+		return "", fmt.Errorf("table '%s' not found", table)
+	}
+
+	if a.columns, err = resolveAll(known, table, a.columns); err != nil {
+		return "", err
+	}
+	if a.checkpoints != nil {
+		if a.incremental, err = resolve(known, table, a.incremental); err != nil {
+			return "", err
+		}
+	}
+	if a.parallelism > 1 && !a.ctid() {
+		if a.splitColumn, err = resolve(known, table, a.splitColumn); err != nil {
+			return "", err
+		}
+	}
+	if a.batchSize > 0 {
+		if a.batchKeys, err = resolveAll(known, table, a.batchKeys); err != nil {
+			return "", err
+		}
+	}
+	if a.partition, err = resolveAll(known, table, a.partition); err != nil {
+		return "", err
+	}
+	if a.spool != nil {
+		if a.spool.keys, err = resolveAll(known, table, a.spool.keys); err != nil {
+			return "", err
+		}
+		a.spool.table = table
+	}
+
+	return table, nil
+}
+
+// resolveTable returns the catalog's name of a table that is not named exactly, matching it case-insensitively
+// among the tables of its schema, as unquoted names are folded in some databases.
+func (a *Archiver) resolveTable(ctx context.Context, table string) (string, error) {
+	var (
+		parts  = strings.Split(table, ".")
+		schema string
+	)
+	if len(parts) == 2 {
+		schema = parts[0]
+	}
+
+	names, err := a.listTables(ctx, schema)
+	if err != nil {
+		return "", fmt.Errorf("querying catalog: %w", err)
+	}
+
+	var matches []string
+	for _, name := range names {
+		if strings.EqualFold(name, parts[len(parts)-1]) {
+			matches = append(matches, name)
+		}
+	}
+	switch {
+	case len(matches) == 0:
+		return "", fmt.Errorf("table '%s' not found", table)
+	case len(matches) > 1:
+		return "", fmt.Errorf("table '%s' is ambiguous, matching '%s'", table, strings.Join(matches, "', '"))
+	}
+	parts[len(parts)-1] = matches[0]
+
+	return strings.Join(parts, "."), nil
+}
+
+// expression matches column names that look like SQL expressions rather than names, e.g. "count(*)" or "a AS b".
+var expression = regexp.MustCompile(`[()*,]|(?i)\sAS\s`)
+
+// resolve returns the catalog's name of a column, matching exactly or otherwise case-insensitively.
+func resolve(known map[string][]string, table, column string) (string, error) {
+	names := known[strings.ToLower(column)]
+	for _, name := range names {
+		if name == column {
+			return name, nil
+		}
+	}
+
+	switch {
+	case len(names) == 1:
+		return names[0], nil
+	case len(names) > 1:
+		return "", fmt.Errorf("column '%s' is ambiguous in table '%s', matching '%s'", column, table, strings.Join(names, "', '"))
+	case expression.MatchString(column):
+		return "", fmt.Errorf("column '%s' not found in table '%s': columns are quoted names, not expressions", column, table)
+	}
+
+	return "", fmt.Errorf("column '%s' not found in table '%s'", column, table)
+}
+
+// resolveAll returns the catalog's names of columns.
+func resolveAll(known map[string][]string, table string, columns []string) ([]string, error) {
+	if columns == nil {
+		return nil, nil
+	}
+
+	resolved := make([]string, len(columns))
+	for i, column := range columns {
+		name, err := resolve(known, table, column)
+		if err != nil {
+			return nil, err
+		}
+		resolved[i] = name
+	}
+
+	return resolved, nil
+}
+
+// validateName validates a table name, optionally schema-qualified.
+func validateName(table string) error {
+	parts := strings.Split(table, ".")
+	for _, part := range parts {
+		if part == "" {
+			return fmt.Errorf("invalid table name '%s'", table)
+		}
+	}
+	if len(parts) > 2 {
+		return fmt.Errorf("invalid table name '%s': expected table or schema.table", table)
+	}
+
+	return nil
+}
+
+// catalog returns the column names of a table keyed by their lower-cased names, which are empty if the
+// table does not exist, or nil if the dialect has no catalog.
+func (a *Archiver) catalog(ctx context.Context, table string) (map[string][]string, error) {
+	var (
+		parts  = strings.Split(table, ".")
+		schema string
+	)
+	if len(parts) == 2 {
+		schema = parts[0]
+	}
+	query, args := a.dialect.Catalog(schema, parts[len(parts)-1])
+	if query == "" {
+		return nil, nil
+	}
+
+	rows, err := a.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying catalog: %w", err)
+	}
+	defer rows.Close()
+
+	known := make(map[string][]string)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("scanning catalog: %w", err)
+		}
+		known[strings.ToLower(name)] = append(known[strings.ToLower(name)], name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("scanning catalog: %w", err)
+	}
+
+	return known, nil
+}
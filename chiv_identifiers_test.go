@@ -0,0 +1,215 @@
+// +build unit
+
+package chiv_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gavincabbage.com/chiv"
+)
+
+func TestArchiveCatalogNames(t *testing.T) {
+	cases := []struct {
+		name     string
+		columns  []string
+		expected string
+		err      string
+	}{
+		{
+			name:     "case-insensitive match",
+			columns:  []string{"name"},
+			expected: `SELECT "Name" FROM "events";`,
+		},
+		{
+			name:     "exact match",
+			columns:  []string{"ID", "id"},
+			expected: `SELECT "ID", "id" FROM "events";`,
+		},
+		{
+			name:    "ambiguous",
+			columns: []string{"Id"},
+			err:     "chiv: querying 'events': column 'Id' is ambiguous in table 'events', matching 'ID', 'id'",
+		},
+		{
+			name:    "expression",
+			columns: []string{"name AS label"},
+			err:     "chiv: querying 'events': column 'name AS label' not found in table 'events': columns are quoted names, not expressions",
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			f := &fixture{
+				columns: []string{"column_name"},
+				rows:    [][]interface{}{{"ID"}, {"id"}, {"Name"}},
+			}
+			db := newFixture(t, f)
+			defer db.Close()
+
+			subject := chiv.NewArchiver(db, &uploader{}, chiv.WithDialect(chiv.Postgres), chiv.WithFormat(writing))
+			err := subject.Archive("events", "bucket", chiv.WithColumns(test.columns...))
+			if test.err != "" {
+				require.EqualError(t, err, test.err)
+				require.Len(t, f.queries, 1)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, test.expected, f.queries[1])
+		})
+	}
+}
+
+func TestArchiveIdentifiers(t *testing.T) {
+	cases := []struct {
+		name     string
+		table    string
+		options  []chiv.Option
+		expected string
+		err      string
+	}{
+		{
+			name:     "schema qualified",
+			table:    "audit.events",
+			expected: `SELECT * FROM "audit"."events";`,
+		},
+		{
+			name:     "mixed case and reserved words",
+			table:    "Events",
+			options:  []chiv.Option{chiv.WithColumns("ID", "order")},
+			expected: `SELECT "ID", "order" FROM "Events";`,
+		},
+		{
+			name:     "embedded quotes",
+			table:    `events"; DROP TABLE events; --`,
+			options:  []chiv.Option{chiv.WithColumns(`id" FROM secrets; --`)},
+			expected: `SELECT "id"" FROM secrets; --" FROM "events""; DROP TABLE events; --";`,
+		},
+		{
+			name:  "empty",
+			table: "",
+			err:   "chiv: querying '': invalid table name ''",
+		},
+		{
+			name:  "empty part",
+			table: "audit.",
+			err:   "chiv: querying 'audit.': invalid table name 'audit.'",
+		},
+		{
+			name:  "too many parts",
+			table: "db.audit.events",
+			err:   "chiv: querying 'db.audit.events': invalid table name 'db.audit.events': expected table or schema.table",
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			f := &fixture{
+				columns: []string{"id"},
+			}
+			db := newFixture(t, f)
+			defer db.Close()
+
+			options := append(test.options, chiv.WithFormat(writing))
+			err := chiv.Archive(db, &uploader{}, test.table, "bucket", options...)
+			if test.err != "" {
+				require.EqualError(t, err, test.err)
+				require.Empty(t, f.queries)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, []string{test.expected}, f.queries)
+		})
+	}
+}
+
+func TestArchiveCatalogTableNames(t *testing.T) {
+	catalog := func(tables ...string) func(string, []interface{}) ([]string, [][]interface{}, bool) {
+		return func(query string, args []interface{}) ([]string, [][]interface{}, bool) {
+			switch {
+			case strings.Contains(query, "information_schema.tables"):
+				rows := make([][]interface{}, len(tables))
+				for i, table := range tables {
+					rows[i] = []interface{}{table}
+				}
+				return []string{"table_name"}, rows, true
+			case strings.Contains(query, "information_schema.columns"):
+				for _, table := range tables {
+					if args[0] == table {
+						return []string{"column_name"}, [][]interface{}{{"ID"}, {"Region"}}, true
+					}
+				}
+				return []string{"column_name"}, nil, true
+			}
+			return nil, nil, false
+		}
+	}
+
+	t.Run("archive", func(t *testing.T) {
+		f := &fixture{
+			columns: []string{"ID", "Region"},
+			rows:    [][]interface{}{{"1", "eu"}},
+			respond: catalog("users", "orders"),
+		}
+		db := newFixture(t, f)
+		defer db.Close()
+
+		u := &uploader{}
+		subject := chiv.NewArchiver(db, u, chiv.WithDialect(chiv.Postgres), chiv.WithFormat(writing))
+		err := subject.Archive("Users", "bucket", chiv.WithColumns("id", "region"), chiv.WithPartitionBy("region"))
+		require.NoError(t, err)
+		require.Equal(t, `SELECT "ID", "Region" FROM "users";`, f.queries[len(f.queries)-1])
+		require.Contains(t, u.uploads, "Users/Region=eu/part-0001")
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		f := &fixture{
+			columns:  []string{"ID", "Region"},
+			rows:     [][]interface{}{{"1", "eu"}},
+			affected: 1,
+			respond:  catalog("users"),
+		}
+		db := newFixture(t, f)
+		defer db.Close()
+
+		subject := chiv.NewArchiver(db, &uploader{}, chiv.WithDialect(chiv.Postgres), chiv.WithFormat(writing))
+		result, err := subject.ArchiveAndPurge("USERS", "bucket", []string{"id"})
+		require.NoError(t, err)
+		require.Equal(t, &chiv.PurgeResult{Archived: 1, Deleted: 1}, result)
+		require.Equal(t, `DELETE FROM "users" WHERE "ID" IN ($1);`, f.queries[len(f.queries)-1])
+	})
+
+	cases := []struct {
+		name   string
+		tables []string
+		table  string
+		err    string
+	}{
+		{
+			name:   "ambiguous",
+			tables: []string{"Users", "users"},
+			table:  "USERS",
+			err:    "chiv: querying 'USERS': table 'USERS' is ambiguous, matching 'Users', 'users'",
+		},
+		{
+			name:   "missing",
+			tables: []string{"users"},
+			table:  "accounts",
+			err:    "chiv: querying 'accounts': table 'accounts' not found",
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			f := &fixture{respond: catalog(test.tables...)}
+			db := newFixture(t, f)
+			defer db.Close()
+
+			subject := chiv.NewArchiver(db, &uploader{}, chiv.WithDialect(chiv.Postgres), chiv.WithFormat(writing))
+			require.EqualError(t, subject.Archive(test.table, "bucket"), test.err)
+		})
+	}
+}
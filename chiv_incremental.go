@@ -0,0 +1,168 @@
+package chiv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// CheckpointStore persists the high-water marks of incrementally archived tables.
+type CheckpointStore interface {
+	// Load the checkpoint for a table, reporting whether one exists.
+	Load(ctx context.Context, table string) (string, bool, error)
+	// Save the checkpoint for a table.
+	Save(ctx context.Context, table, value string) error
+}
+
+type fileCheckpointStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileCheckpointStore returns a CheckpointStore persisting checkpoints to a local JSON file.
+func NewFileCheckpointStore(path string) CheckpointStore {
+	return &fileCheckpointStore{path: path}
+}
+
+// Load the checkpoint for a table from the file.
+func (s *fileCheckpointStore) Load(_ context.Context, table string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	checkpoints, err := s.read()
+	if err != nil {
+		return "", false, err
+	}
+
+	value, ok := checkpoints[table]
+	return value, ok, nil
+}
+
+// Save the checkpoint for a table, atomically replacing the file.
+func (s *fileCheckpointStore) Save(_ context.Context, table, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	checkpoints, err := s.read()
+	if err != nil {
+		return err
+	}
+	checkpoints[table] = value
+
+	b, err := json.MarshalIndent(checkpoints, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), s.path)
+}
+
+func (s *fileCheckpointStore) read() (map[string]string, error) {
+	checkpoints := make(map[string]string)
+
+	b, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return checkpoints, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &checkpoints); err != nil {
+		return nil, err
+	}
+
+	return checkpoints, nil
+}
+
+// ObjectStore downloads and uploads S3 objects. The clients of the awsv1 and awsv2 packages implement ObjectStore.
+type ObjectStore interface {
+	Downloader
+	Uploader
+}
+
+type s3CheckpointStore struct {
+	mu     sync.Mutex
+	s3     ObjectStore
+	bucket string
+	key    string
+}
+
+// NewS3CheckpointStore returns a CheckpointStore persisting checkpoints to a JSON object in S3.
+func NewS3CheckpointStore(s3 ObjectStore, bucket, key string) CheckpointStore {
+	return &s3CheckpointStore{
+		s3:     s3,
+		bucket: bucket,
+		key:    key,
+	}
+}
+
+// Load the checkpoint for a table from the S3 object.
+func (s *s3CheckpointStore) Load(ctx context.Context, table string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	checkpoints, err := s.read(ctx)
+	if err != nil {
+		return "", false, err
+	}
+
+	value, ok := checkpoints[table]
+	return value, ok, nil
+}
+
+// Save the checkpoint for a table to the S3 object.
+func (s *s3CheckpointStore) Save(ctx context.Context, table, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	checkpoints, err := s.read(ctx)
+	if err != nil {
+		return err
+	}
+	checkpoints[table] = value
+
+	b, err := json.Marshal(checkpoints)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.s3.Upload(ctx, s.bucket, s.key, &Attributes{ContentType: "application/json"}, bytes.NewReader(b))
+	return err
+}
+
+func (s *s3CheckpointStore) read(ctx context.Context) (map[string]string, error) {
+	checkpoints := make(map[string]string)
+
+	body, _, err := s.s3.Download(ctx, s.bucket, s.key)
+	if errors.Is(err, ErrNotFound) {
+		return checkpoints, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	if err := json.NewDecoder(body).Decode(&checkpoints); err != nil {
+		return nil, err
+	}
+
+	return checkpoints, nil
+}
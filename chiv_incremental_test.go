@@ -0,0 +1,130 @@
+// +build unit
+
+package chiv_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gavincabbage.com/chiv"
+)
+
+func TestArchiverIncremental(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chiv")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	stores := map[string]chiv.CheckpointStore{
+		"file": chiv.NewFileCheckpointStore(filepath.Join(dir, "checkpoints.json")),
+		"s3":   chiv.NewS3CheckpointStore(&objectStore{}, "bucket", "checkpoints.json"),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			f := &fixture{
+				columns: []string{"id", "name"},
+				rows:    [][]interface{}{{"1", "first"}, {"2", "second"}, {"3", "third"}},
+			}
+			db := newFixture(t, f)
+			defer db.Close()
+
+			var (
+				uploader = &uploader{}
+				subject  = chiv.NewArchiver(db, uploader, chiv.WithFormat(writing), chiv.WithIncremental("id", store))
+			)
+
+			require.NoError(t, subject.Archive("events", "bucket"))
+			require.Equal(t, "1,first\n2,second\n3,third\n", uploader.uploads["events"])
+
+			checkpoint, ok, err := store.Load(context.Background(), "events")
+			require.NoError(t, err)
+			require.True(t, ok)
+			require.Equal(t, "3", checkpoint)
+
+			f.rows = [][]interface{}{{"4", "fourth"}}
+			require.NoError(t, subject.Archive("events", "bucket"))
+
+			checkpoint, _, err = store.Load(context.Background(), "events")
+			require.NoError(t, err)
+			require.Equal(t, "4", checkpoint)
+
+			f.rows = nil
+			require.NoError(t, subject.Archive("events", "bucket"))
+
+			checkpoint, _, err = store.Load(context.Background(), "events")
+			require.NoError(t, err)
+			require.Equal(t, "4", checkpoint)
+
+			f.rows = [][]interface{}{{"5", "fifth"}}
+			uploader.uploadErr = errors.New("uploading")
+			require.Error(t, subject.Archive("events", "bucket"))
+
+			checkpoint, _, err = store.Load(context.Background(), "events")
+			require.NoError(t, err)
+			require.Equal(t, "4", checkpoint)
+
+			require.Equal(t, []string{
+				`SELECT * FROM "events" ORDER BY "id";`,
+				`SELECT * FROM "events" WHERE "id" > ? ORDER BY "id";`,
+				`SELECT * FROM "events" WHERE "id" > ? ORDER BY "id";`,
+				`SELECT * FROM "events" WHERE "id" > ? ORDER BY "id";`,
+			}, f.queries)
+			require.Equal(t, [][]interface{}{{}, {"3"}, {"4"}, {"4"}}, f.args)
+
+			_, ok, err = store.Load(context.Background(), "other")
+			require.NoError(t, err)
+			require.False(t, ok)
+		})
+	}
+}
+
+func TestArchiverIncrementalUnknownColumn(t *testing.T) {
+	db := newFixture(t, &fixture{
+		columns: []string{"id", "name"},
+		rows:    [][]interface{}{{"1", "first"}},
+	})
+	defer db.Close()
+
+	var (
+		store   = chiv.NewS3CheckpointStore(&objectStore{}, "bucket", "checkpoints.json")
+		subject = chiv.NewArchiver(db, &uploader{}, chiv.WithFormat(writing), chiv.WithIncremental("unknown", store))
+	)
+
+	require.EqualError(t, subject.Archive("events", "bucket"), "chiv: downloading: incremental column 'unknown' not found")
+}
+
+type objectStore struct {
+	objects  map[string][]byte
+	metadata map[string]string
+}
+
+func (s *objectStore) Download(_ context.Context, _, key string) (io.ReadCloser, *chiv.Attributes, error) {
+	b, ok := s.objects[key]
+	if !ok {
+		return nil, nil, chiv.ErrNotFound
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(b)), &chiv.Attributes{Metadata: s.metadata}, nil
+}
+
+func (s *objectStore) Upload(_ context.Context, _, key string, _ *chiv.Attributes, r io.Reader) (*chiv.ObjectInfo, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.objects == nil {
+		s.objects = make(map[string][]byte)
+	}
+	s.objects[key] = b
+
+	return &chiv.ObjectInfo{}, nil
+}
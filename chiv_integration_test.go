@@ -8,10 +8,15 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	s3v2 "github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -20,6 +25,8 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"gavincabbage.com/chiv"
+	"gavincabbage.com/chiv/awsv1"
+	"gavincabbage.com/chiv/awsv2"
 
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
@@ -296,7 +303,7 @@ func TestArchiver_Archive(t *testing.T) {
 			var (
 				db         = newDB(t, test.driver, test.database)
 				s3client   = newS3Client(t, os.Getenv("AWS_REGION"), os.Getenv("AWS_ENDPOINT"))
-				uploader   = s3manager.NewUploaderWithClient(s3client)
+				uploader   = awsv1.NewUploader(s3manager.NewUploaderWithClient(s3client))
 				downloader = s3manager.NewDownloaderWithClient(s3client)
 			)
 			defer db.Close()
@@ -333,7 +340,7 @@ func TestArchiveWithContext(t *testing.T) {
 		expected   = "./testdata/postgres/postgres.csv"
 		db         = newDB(t, driver, database)
 		s3client   = newS3Client(t, os.Getenv("AWS_REGION"), os.Getenv("AWS_ENDPOINT"))
-		uploader   = s3manager.NewUploaderWithClient(s3client)
+		uploader   = awsv1.NewUploader(s3manager.NewUploaderWithClient(s3client))
 		downloader = s3manager.NewDownloaderWithClient(s3client)
 	)
 	defer db.Close()
@@ -350,6 +357,244 @@ func TestArchiveWithContext(t *testing.T) {
 	require.Equal(t, readFile(t, expected), actual)
 }
 
+func TestArchiveWithResult(t *testing.T) {
+	var (
+		database   = os.Getenv("POSTGRES_URL")
+		driver     = "postgres"
+		bucket     = "result_bucket"
+		table      = "postgres_table"
+		key        = "postgres_table.csv"
+		setup      = "./testdata/postgres/postgres_setup.sql"
+		teardown   = "./testdata/postgres/postgres_teardown.sql"
+		expected   = "./testdata/postgres/postgres.csv"
+		db         = newDB(t, driver, database)
+		s3client   = newS3Client(t, os.Getenv("AWS_REGION"), os.Getenv("AWS_ENDPOINT"))
+		uploader   = awsv1.NewUploader(s3manager.NewUploaderWithClient(s3client))
+		downloader = s3manager.NewDownloaderWithClient(s3client)
+	)
+	defer db.Close()
+
+	exec(t, db, readFile(t, setup))
+	defer exec(t, db, readFile(t, teardown))
+
+	createBucket(t, s3client, bucket)
+	defer deleteBucket(t, s3client, bucket)
+
+	result, err := chiv.NewArchiver(db, uploader).ArchiveWithResult(table, bucket)
+	require.NoError(t, err)
+
+	actual := download(t, downloader, bucket, key)
+	require.Equal(t, readFile(t, expected), actual)
+	require.Equal(t, int64(len(actual)), result.Bytes)
+	require.Len(t, result.Objects, 1)
+	require.Equal(t, key, result.Objects[0].Key)
+	require.NotEmpty(t, result.Objects[0].ETag)
+	require.NotEmpty(t, result.Objects[0].Location)
+}
+
+func TestRestore(t *testing.T) {
+	var (
+		database   = os.Getenv("POSTGRES_URL")
+		driver     = "postgres"
+		bucket     = "restore_bucket"
+		table      = "postgres_table"
+		setup      = "./testdata/postgres/postgres_setup.sql"
+		teardown   = "./testdata/postgres/postgres_teardown.sql"
+		db         = newDB(t, driver, database)
+		s3client   = newS3Client(t, os.Getenv("AWS_REGION"), os.Getenv("AWS_ENDPOINT"))
+		uploader   = awsv1.NewUploader(s3manager.NewUploaderWithClient(s3client))
+		downloader = s3manager.NewDownloaderWithClient(s3client)
+	)
+	defer db.Close()
+
+	createBucket(t, s3client, bucket)
+	defer deleteBucket(t, s3client, bucket)
+
+	cases := []struct {
+		name    string
+		key     string
+		options []chiv.Option
+	}{
+		{
+			name: "csv",
+			key:  "postgres_table.csv",
+		},
+		{
+			name:    "gzip json",
+			key:     "postgres_table.json.gz",
+			options: []chiv.Option{chiv.WithFormat(chiv.JSON), chiv.WithCompression(chiv.Gzip, chiv.DefaultCompression)},
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			exec(t, db, readFile(t, setup))
+			defer exec(t, db, readFile(t, teardown))
+
+			subject := chiv.NewArchiver(db, uploader)
+			require.NoError(t, subject.Archive(table, bucket, test.options...))
+			archived := download(t, downloader, bucket, test.key)
+
+			exec(t, db, "DELETE FROM postgres_table;")
+			result, err := chiv.Restore(context.Background(), db, awsv1.NewClient(s3client), bucket, test.key, table, test.options...)
+			require.NoError(t, err)
+			require.Equal(t, int64(len(archived)), result.Bytes)
+
+			require.NoError(t, subject.Archive(table, bucket, test.options...))
+			require.Equal(t, archived, download(t, downloader, bucket, test.key))
+		})
+	}
+}
+
+func TestArchiveWithVerify(t *testing.T) {
+	var (
+		database   = os.Getenv("POSTGRES_URL")
+		driver     = "postgres"
+		bucket     = "verify_bucket"
+		table      = "postgres_table"
+		key        = "postgres_table.csv"
+		setup      = "./testdata/postgres/postgres_setup.sql"
+		teardown   = "./testdata/postgres/postgres_teardown.sql"
+		expected   = "./testdata/postgres/postgres.csv"
+		db         = newDB(t, driver, database)
+		s3client   = newS3Client(t, os.Getenv("AWS_REGION"), os.Getenv("AWS_ENDPOINT"))
+		uploader   = awsv1.NewUploader(s3manager.NewUploaderWithClient(s3client))
+		downloader = s3manager.NewDownloaderWithClient(s3client)
+	)
+	defer db.Close()
+
+	exec(t, db, readFile(t, setup))
+	defer exec(t, db, readFile(t, teardown))
+
+	createBucket(t, s3client, bucket)
+	defer deleteBucket(t, s3client, bucket)
+
+	for _, verification := range []chiv.Verification{chiv.VerifySize, chiv.VerifyContent} {
+		result, err := chiv.NewArchiver(db, uploader).ArchiveWithResult(table, bucket, chiv.WithVerify(awsv1.NewClient(s3client), verification), chiv.WithAnnotation())
+		require.NoError(t, err)
+		require.Equal(t, readFile(t, expected), download(t, downloader, bucket, key))
+
+		output, err := s3client.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+		require.NoError(t, err)
+		require.Equal(t, result.Objects[0].Checksum, aws.StringValue(output.Metadata["Chiv-Sha256"]))
+		require.Equal(t, strconv.FormatInt(result.Rows, 10), aws.StringValue(output.Metadata["Chiv-Rows"]))
+	}
+}
+
+func TestArchiveWithAWSv2(t *testing.T) {
+	var (
+		database   = os.Getenv("POSTGRES_URL")
+		driver     = "postgres"
+		bucket     = "awsv2_bucket"
+		table      = "postgres_table"
+		key        = "postgres_table.csv"
+		setup      = "./testdata/postgres/postgres_setup.sql"
+		teardown   = "./testdata/postgres/postgres_teardown.sql"
+		expected   = "./testdata/postgres/postgres.csv"
+		db         = newDB(t, driver, database)
+		s3client   = newS3Client(t, os.Getenv("AWS_REGION"), os.Getenv("AWS_ENDPOINT"))
+		downloader = s3manager.NewDownloaderWithClient(s3client)
+		client     = newS3v2Client(t, os.Getenv("AWS_REGION"), os.Getenv("AWS_ENDPOINT"))
+	)
+	defer db.Close()
+
+	exec(t, db, readFile(t, setup))
+	defer exec(t, db, readFile(t, teardown))
+
+	createBucket(t, s3client, bucket)
+	defer deleteBucket(t, s3client, bucket)
+
+	sink := awsv2.NewSink(manager.NewUploader(client), bucket)
+	result, err := chiv.NewArchiver(db, nil, chiv.WithSink(sink)).ArchiveWithResult(table, bucket, chiv.WithVerify(awsv2.NewClient(client), chiv.VerifyContent))
+	require.NoError(t, err)
+	require.NotEmpty(t, result.Objects[0].ETag)
+	require.Equal(t, readFile(t, expected), download(t, downloader, bucket, key))
+}
+
+func TestArchiveCatalogValidation(t *testing.T) {
+	var (
+		database = os.Getenv("POSTGRES_URL")
+		driver   = "postgres"
+		bucket   = "postgres_bucket"
+		setup    = "./testdata/postgres/postgres_setup.sql"
+		teardown = "./testdata/postgres/postgres_teardown.sql"
+		db       = newDB(t, driver, database)
+		s3client = newS3Client(t, os.Getenv("AWS_REGION"), os.Getenv("AWS_ENDPOINT"))
+		uploader = awsv1.NewUploader(s3manager.NewUploaderWithClient(s3client))
+	)
+	defer db.Close()
+
+	exec(t, db, readFile(t, setup))
+	defer exec(t, db, readFile(t, teardown))
+
+	require.EqualError(t, chiv.Archive(db, uploader, "missing_table", bucket),
+		"chiv: querying 'missing_table': table 'missing_table' not found")
+	require.EqualError(t, chiv.Archive(db, uploader, "public.postgres_table", bucket, chiv.WithColumns("id", "missing_column")),
+		"chiv: querying 'public.postgres_table': column 'missing_column' not found in table 'public.postgres_table'")
+}
+
+func TestArchiveSnapshot(t *testing.T) {
+	var (
+		database   = os.Getenv("POSTGRES_URL")
+		driver     = "postgres"
+		bucket     = "snapshot_bucket"
+		setup      = "./testdata/postgres/two_tables_setup.sql"
+		teardown   = "./testdata/postgres/two_tables_teardown.sql"
+		db         = newDB(t, driver, database)
+		s3client   = newS3Client(t, os.Getenv("AWS_REGION"), os.Getenv("AWS_ENDPOINT"))
+		uploader   = awsv1.NewUploader(s3manager.NewUploaderWithClient(s3client))
+		downloader = s3manager.NewDownloaderWithClient(s3client)
+	)
+	defer db.Close()
+
+	exec(t, db, readFile(t, setup))
+	defer exec(t, db, readFile(t, teardown))
+
+	createBucket(t, s3client, bucket)
+	defer deleteBucket(t, s3client, bucket)
+
+	subject := chiv.NewArchiver(db, uploader, chiv.WithTableConcurrency(2))
+	require.NoError(t, subject.ArchiveSnapshot([]string{"first_table", "second_table"}, bucket))
+
+	require.Equal(t, readFile(t, "./testdata/postgres/two_tables_first.csv"), download(t, downloader, bucket, "first_table.csv"))
+	require.Equal(t, readFile(t, "./testdata/postgres/two_tables_second.csv"), download(t, downloader, bucket, "second_table.csv"))
+}
+
+func TestArchiveParallel(t *testing.T) {
+	var (
+		database   = os.Getenv("POSTGRES_URL")
+		driver     = "postgres"
+		bucket     = "parallel_bucket"
+		setup      = "./testdata/postgres/two_tables_setup.sql"
+		teardown   = "./testdata/postgres/two_tables_teardown.sql"
+		expected   = readFile(t, "./testdata/postgres/two_tables_first.csv")
+		db         = newDB(t, driver, database)
+		s3client   = newS3Client(t, os.Getenv("AWS_REGION"), os.Getenv("AWS_ENDPOINT"))
+		uploader   = awsv1.NewUploader(s3manager.NewUploaderWithClient(s3client))
+		downloader = s3manager.NewDownloaderWithClient(s3client)
+	)
+	defer db.Close()
+
+	exec(t, db, readFile(t, setup))
+	defer exec(t, db, readFile(t, teardown))
+
+	createBucket(t, s3client, bucket)
+	defer deleteBucket(t, s3client, bucket)
+
+	subject := chiv.NewArchiver(db, uploader)
+
+	for _, column := range []string{"integer_column", "ctid"} {
+		key := column + ".csv"
+		require.NoError(t, subject.Archive("first_table", bucket, chiv.WithKey(key), chiv.WithParallelism(3, column)))
+
+		actual := strings.Split(download(t, downloader, bucket, key), "\n")
+		sort.Strings(actual[1:])
+		lines := strings.Split(expected, "\n")
+		sort.Strings(lines[1:])
+		require.Equal(t, lines, actual)
+	}
+}
+
 func TestArchiveRowsJoin(t *testing.T) {
 	var (
 		database   = os.Getenv("POSTGRES_URL")
@@ -361,7 +606,7 @@ func TestArchiveRowsJoin(t *testing.T) {
 		expected   = "./testdata/postgres/join.csv"
 		db         = newDB(t, driver, database)
 		s3client   = newS3Client(t, os.Getenv("AWS_REGION"), os.Getenv("AWS_ENDPOINT"))
-		uploader   = s3manager.NewUploaderWithClient(s3client)
+		uploader   = awsv1.NewUploader(s3manager.NewUploaderWithClient(s3client))
 		downloader = s3manager.NewDownloaderWithClient(s3client)
 	)
 	defer db.Close()
@@ -433,6 +678,24 @@ func newS3Client(t testing.TB, region string, endpoint string) *s3.S3 {
 	return client
 }
 
+func newS3v2Client(t testing.TB, region string, endpoint string) *s3v2.Client {
+	t.Helper()
+
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background(), awsconfig.WithRegion(region))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+
+	return s3v2.NewFromConfig(cfg, func(o *s3v2.Options) {
+		o.EndpointResolver = s3v2.EndpointResolverFromURL(endpoint)
+		o.UsePathStyle = true
+	})
+}
+
 func exec(t testing.TB, db *sql.DB, statements string) {
 	s := strings.Split(statements, ";\n\n")
 	for _, statement := range s {
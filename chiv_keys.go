@@ -0,0 +1,120 @@
+package chiv
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// KeyData is provided to key templates configured with WithKeyTemplate.
+type KeyData struct {
+	// Table is the archived table, or "table" when archiving rows.
+	Table string
+	// Format is the formatter's default extension, e.g. "csv", regardless of WithExtension.
+	Format string
+	// Extension is the full object extension including any compression, e.g. "csv.gz".
+	Extension string
+	// Time is the time the archive started, in UTC.
+	Time time.Time
+	// RunID uniquely identifies each call to Archive.
+	RunID string
+	// Part is the sequence number of the object within its table or partition, numbered from one.
+	Part int
+	// Partition is the Hive-style partition path of the object, if partitioned.
+	Partition string
+}
+
+var keyFuncs = template.FuncMap{
+	"date": func(layout string, t time.Time) string {
+		return t.Format(layout)
+	},
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// parseKeyTemplate parses the configured key template and checks that it executes, and that it
+// distinguishes the parts and partitions the archive may be split into.
+func (a *Archiver) parseKeyTemplate() error {
+	if a.keyTemplate == "" {
+		return nil
+	}
+
+	t, err := template.New("key").Funcs(keyFuncs).Option("missingkey=error").Parse(a.keyTemplate)
+	if err != nil {
+		return err
+	}
+	if _, err := execute(t, KeyData{}); err != nil {
+		return err
+	}
+
+	split := a.maxRows > 0 || a.maxBytes > 0 || (a.parallelism > 1 && a.parts) || len(a.partition) > 0
+	if split {
+		if ok, err := varies(t, KeyData{Part: 1}, KeyData{Part: 2}); err != nil {
+			return err
+		} else if !ok {
+			return errors.New("template must include {{.Part}} when archives are split into multiple objects")
+		}
+	}
+	if len(a.partition) > 0 {
+		if ok, err := varies(t, KeyData{Partition: "a"}, KeyData{Partition: "b"}); err != nil {
+			return err
+		} else if !ok {
+			return errors.New("template must include {{.Partition}} when archives are partitioned")
+		}
+	}
+
+	a.template = t
+	return nil
+}
+
+// varies reports whether a template builds different keys for the given data.
+func varies(t *template.Template, first, second KeyData) (bool, error) {
+	a, err := execute(t, first)
+	if err != nil {
+		return false, err
+	}
+	b, err := execute(t, second)
+	if err != nil {
+		return false, err
+	}
+
+	return a != b, nil
+}
+
+func execute(t *template.Template, data KeyData) (string, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+func (a *Archiver) templateKey(table, partition string, part int) (string, error) {
+	data := KeyData{
+		Table:     table,
+		Format:    a.formatExt,
+		Extension: a.extension,
+		Time:      a.start,
+		RunID:     a.runID,
+		Part:      part,
+		Partition: partition,
+	}
+	if a.codec != nil {
+		data.Extension = strings.TrimPrefix(data.Extension+"."+a.codec.Extension(), ".")
+	}
+
+	return execute(a.template, data)
+}
+
+func newRunID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,124 @@
+// +build unit
+
+package chiv_test
+
+import (
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"gavincabbage.com/chiv"
+)
+
+func TestArchiveRowsKeyTemplate(t *testing.T) {
+	today := time.Now().UTC().Format("2006/01/02")
+
+	cases := []struct {
+		name        string
+		template    string
+		options     []chiv.Option
+		expected    []string
+		expectedErr string
+	}{
+		{
+			name:     "table and extension",
+			template: "archives/{{.Table}}.{{.Extension}}",
+			options:  []chiv.Option{chiv.WithFormat(format(&extensionFormatter{&formatter{}}))},
+			expected: []string{"^archives/table\\.ext$"},
+		},
+		{
+			name:     "format and compressed extension",
+			template: "{{.Format | upper}}/{{.Table}}.{{.Extension}}",
+			options: []chiv.Option{
+				chiv.WithFormat(format(&extensionFormatter{&formatter{}})),
+				chiv.WithCompression(chiv.Gzip, chiv.DefaultCompression),
+			},
+			expected: []string{"^EXT/table\\.ext\\.gz$"},
+		},
+		{
+			name:     "date and run id",
+			template: `{{.Table}}/{{.Time | date "2006/01/02"}}/{{.RunID}}`,
+			options:  []chiv.Option{chiv.WithFormat(writing)},
+			expected: []string{"^table/" + regexp.QuoteMeta(today) + "/[0-9a-f]{16}$"},
+		},
+		{
+			name:     "parts",
+			template: `{{.Table}}/{{.RunID}}/{{printf "%03d" .Part}}`,
+			options:  []chiv.Option{chiv.WithFormat(writing), chiv.WithMaxRowsPerObject(1)},
+			expected: []string{"^table/([0-9a-f]{16})/001$", "^table/([0-9a-f]{16})/002$"},
+		},
+		{
+			name:     "format with extension",
+			template: "{{.Format}}/{{.Table}}.{{.Extension}}",
+			options: []chiv.Option{
+				chiv.WithFormat(format(&extensionFormatter{&formatter{}})),
+				chiv.WithExtension("txt"),
+			},
+			expected: []string{"^ext/table\\.txt$"},
+		},
+		{
+			name:        "parts without part",
+			template:    "{{.Table}}/{{.RunID}}",
+			options:     []chiv.Option{chiv.WithFormat(writing), chiv.WithMaxRowsPerObject(1)},
+			expectedErr: "chiv: parsing key template: template must include {{.Part}} when archives are split into multiple objects",
+		},
+		{
+			name:        "partitions without partition",
+			template:    "{{.Table}}/{{.Part}}",
+			options:     []chiv.Option{chiv.WithFormat(writing), chiv.WithPartitionBy("first_column")},
+			expectedErr: "chiv: parsing key template: template must include {{.Partition}} when archives are partitioned",
+		},
+		{
+			name:        "parse error",
+			template:    "{{.Table",
+			options:     []chiv.Option{chiv.WithFormat(writing)},
+			expectedErr: "chiv: parsing key template: template: key:1: unclosed action",
+		},
+		{
+			name:        "execution error",
+			template:    "{{.Unknown}}",
+			options:     []chiv.Option{chiv.WithFormat(writing)},
+			expectedErr: `chiv: parsing key template: template: key:1:2: executing "key" at <.Unknown>: can't evaluate field Unknown in type chiv.KeyData`,
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				rows = &rows{
+					columns: []string{"first_column", "second_column"},
+					scan:    [][]string{{"first", "second"}, {"third", "fourth"}},
+				}
+				uploader = &uploader{}
+				options  = append(test.options, chiv.WithKey("ignored"), chiv.WithKeyTemplate(test.template))
+			)
+
+			err := chiv.ArchiveRows(rows, uploader, "bucket", options...)
+			if test.expectedErr != "" {
+				require.EqualError(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+
+			var keys []string
+			for key := range uploader.uploads {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			require.Len(t, keys, len(test.expected))
+			var runIDs []string
+			for i, key := range keys {
+				match := regexp.MustCompile(test.expected[i]).FindStringSubmatch(key)
+				require.NotNil(t, match, key)
+				runIDs = append(runIDs, match[1:]...)
+			}
+			for _, runID := range runIDs {
+				require.Equal(t, runIDs[0], runID)
+			}
+		})
+	}
+}
@@ -17,6 +17,16 @@ func WithKey(s string) Option {
 	}
 }
 
+// WithKeyTemplate configures a text/template used to build object keys, overriding WithKey.
+// The template is executed with KeyData, e.g. "{{.Table}}/{{.Time | date \"2006/01/02\"}}/{{.RunID}}.{{.Extension}}".
+// Templates for archives split into parts or partitions must include {{.Part}} and {{.Partition}}, and templates
+// for multiple tables must include {{.Table}}.
+func WithKeyTemplate(s string) Option {
+	return func(a *Archiver) {
+		a.keyTemplate = s
+	}
+}
+
 // WithExtension configures an extension for object keys uploaded to S3.
 func WithExtension(s string) Option {
 	return func(a *Archiver) {
@@ -31,9 +41,203 @@ func WithNull(s string) Option {
 	}
 }
 
-// WithColumns configures a list of column names to archive.
+// WithColumns configures a list of column names to archive. Names are quoted, so expressions such as
+// "count(*)" or "a AS b" are not supported, and are matched to the database catalog case-insensitively.
 func WithColumns(c ...string) Option {
 	return func(a *Archiver) {
 		a.columns = c
 	}
 }
+
+// WithCompression configures a codec and level used to compress uploads.
+// Use DefaultCompression for the codec's default level.
+func WithCompression(codec Codec, level int) Option {
+	return func(a *Archiver) {
+		a.codec = codec
+		a.level = level
+	}
+}
+
+// WithEncryption configures client-side encryption of uploads. Each object is encrypted with a new
+// AES-256-GCM data key, wrapped by the KeyWrapper and stored in the object metadata. Use Decrypt to read it back.
+func WithEncryption(wrapper KeyWrapper) Option {
+	return func(a *Archiver) {
+		a.wrapper = wrapper
+	}
+}
+
+// WithMaxRowsPerObject splits uploads into multiple objects of at most n rows each.
+// Each object is independently formatted and its key is suffixed with a sequence number, e.g. table-00001.csv.
+func WithMaxRowsPerObject(n int64) Option {
+	return func(a *Archiver) {
+		a.maxRows = n
+	}
+}
+
+// WithMaxBytesPerObject splits uploads into multiple objects of approximately n bytes each.
+// An object may exceed n by one record plus any data buffered by the formatter or compressor.
+func WithMaxBytesPerObject(n int64) Option {
+	return func(a *Archiver) {
+		a.maxBytes = n
+	}
+}
+
+// WithPartitionBy configures columns used to partition uploads Hive-style. Each row is written to an object under
+// a prefix of the row's partition values, e.g. table/date=2019-09-01/part-0001.csv, with WithKey configuring the
+// leading prefix. Partition columns are not included in the formatted data.
+func WithPartitionBy(columns ...string) Option {
+	return func(a *Archiver) {
+		a.partition = columns
+	}
+}
+
+// WithMaxOpenPartitions configures the maximum number of partitions uploaded concurrently.
+// When the limit is reached the least recently written partition is closed.
+func WithMaxOpenPartitions(n int) Option {
+	return func(a *Archiver) {
+		a.maxOpen = n
+	}
+}
+
+// WithIncremental configures incremental archival of a table by an ever-increasing column, e.g. an ID or timestamp.
+// Only rows with column values greater than the table's checkpoint are archived, and the greatest value archived
+// is saved as the new checkpoint once the upload succeeds. Incremental archival applies only to Archive.
+func WithIncremental(column string, store CheckpointStore) Option {
+	return func(a *Archiver) {
+		a.incremental = column
+		a.checkpoints = store
+	}
+}
+
+// WithWhere configures a condition filtering the rows archived from a table, e.g. "created_at < ?".
+// Placeholders are written as ? and rewritten to the driver's style, e.g. $1 for Postgres,
+// and args are passed to the database with the query.
+func WithWhere(clause string, args ...interface{}) Option {
+	return func(a *Archiver) {
+		a.where = clause
+		a.whereArgs = args
+	}
+}
+
+// WithOrderBy configures the order in which rows are archived from a table, e.g. "created_at DESC".
+// With WithIncremental, rows are first ordered by the incremental column.
+func WithOrderBy(columns ...string) Option {
+	return func(a *Archiver) {
+		a.orderBy = columns
+	}
+}
+
+// WithLimit configures the maximum number of rows archived from a table.
+func WithLimit(n int64) Option {
+	return func(a *Archiver) {
+		a.limit = n
+	}
+}
+
+// WithDialect configures the SQL dialect of the database, overriding the dialect selected from its driver.
+func WithDialect(dialect Dialect) Option {
+	return func(a *Archiver) {
+		a.dialect = dialect
+	}
+}
+
+// WithTableConcurrency configures the maximum number of tables archived concurrently by ArchiveSchema and ArchiveTables.
+func WithTableConcurrency(n int) Option {
+	return func(a *Archiver) {
+		a.concurrency = n
+	}
+}
+
+// WithParallelism configures archiving a table with n concurrent queries, each reading a range of the given integer
+// split column between its minimum and maximum values. Postgres 14 and later tables may instead be split by "ctid",
+// reading ranges of the table's physical blocks; earlier servers can't scan a range of blocks, so the table is then
+// read by a single query. The rows of all queries are merged into one stream and are not ordered across ranges.
+// Parallelism cannot be combined with WithLimit or WithIncremental, or used by ArchiveSnapshot.
+func WithParallelism(n int, splitColumn string) Option {
+	return func(a *Archiver) {
+		a.parallelism = n
+		a.splitColumn = splitColumn
+	}
+}
+
+// WithParallelParts configures parallel archives to upload the rows of each range to their own objects rather than
+// merging them, formatting each concurrently. Object keys are numbered as by WithMaxRowsPerObject.
+func WithParallelParts() Option {
+	return func(a *Archiver) {
+		a.parts = true
+	}
+}
+
+// WithBatchSize configures reading a table in batches of at most n rows, each read by its own short query ordered
+// by the given key columns and starting after the last key of the previous batch. The key columns must uniquely
+// identify rows, and need not be archived. Batched archives are identical to archives read by a single query
+// ordered by the key columns. Batching cannot be combined with WithOrderBy, and with WithIncremental the
+// incremental column must be the first key column.
+func WithBatchSize(n int64, keys ...string) Option {
+	return func(a *Archiver) {
+		a.batchSize = n
+		a.batchKeys = keys
+	}
+}
+
+// WithHooks configures callbacks invoked as archives progress.
+func WithHooks(hooks Hooks) Option {
+	return func(a *Archiver) {
+		a.hooks = hooks
+	}
+}
+
+// WithParser configures the parser of objects restored by Restore, overriding detection of the format from the key.
+func WithParser(p ParserFunc) Option {
+	return func(a *Archiver) {
+		a.parser = p
+	}
+}
+
+// WithCreateTable configures Restore to create a table that does not exist from the schema sidecar of the
+// restored object, stored at its key with SchemaExtension appended.
+func WithCreateTable() Option {
+	return func(a *Archiver) {
+		a.createTable = true
+	}
+}
+
+// WithSchemaSidecar configures uploading a schema sidecar next to each object, at its key with SchemaExtension
+// appended. The sidecar is a JSON document, a Schema, describing the archived columns as reported by the database
+// driver, and the source table, dialect, rows and chiv version. Sidecars are neither compressed nor encrypted.
+func WithSchemaSidecar() Option {
+	return func(a *Archiver) {
+		a.sidecar = true
+	}
+}
+
+// WithVerify configures verifying each object after it is uploaded, using the Verifier to read it back.
+// With VerifySize, the size of each object in S3 is compared to the bytes uploaded. With VerifyContent, each
+// object is downloaded again and its size, SHA-256 checksum and rows are compared to those uploaded, as reported
+// in its ObjectResult. Any mismatch fails the archive. Verified objects are left as uploaded unless configured
+// WithAnnotation.
+func WithVerify(verifier Verifier, verification Verification) Option {
+	return func(a *Archiver) {
+		a.verifier = verifier
+		a.verifyBy = verification
+	}
+}
+
+// WithAnnotation configures annotating objects verified WithVerify with their checksum and rows as metadata,
+// which the S3 clients of the awsv1 and awsv2 packages do by copying them in place. Each copy is another request,
+// and creates a new version in versioned buckets. Objects larger than 5 GB, which S3 cannot copy in a single
+// request, are verified without adding metadata.
+func WithAnnotation() Option {
+	return func(a *Archiver) {
+		a.annotation = true
+	}
+}
+
+// WithSink configures writing objects, and their schema sidecars, to a Sink rather than uploading them to the
+// bucket with the Archiver's Uploader, e.g. to write archives to the local filesystem or another object store.
+// The bucket given to Archive is then only reported in results.
+func WithSink(sink Sink) Option {
+	return func(a *Archiver) {
+		a.sink = sink
+	}
+}
@@ -0,0 +1,363 @@
+package chiv
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// splitRange is the range of split column values read by one query of a parallel archive.
+// Bounds are nil when unbounded, and the first range also includes null values.
+type splitRange struct {
+	lower, upper interface{}
+}
+
+// archiveParallel archives a table with concurrent queries over ranges of its split column,
+// merging their rows into one stream or downloading each to its own parts.
+func (a *Archiver) archiveParallel(ctx context.Context, table, bucket string) (err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	g, gctx := errgroup.WithContext(ctx)
+	qctx := a.hooks.query(gctx, table, bucket)
+
+	if a.splitColumn == "" {
+		return a.fail(ctx, table, StageQuery, errorf("querying '%s': parallelism requires a split column", table))
+	}
+	if a.limit > 0 || a.checkpoints != nil {
+		return a.fail(ctx, table, StageQuery, errorf("querying '%s': parallelism cannot be used with a limit or incremental archival", table))
+	}
+	source, err := a.validate(qctx, table)
+	if err != nil {
+		return a.fail(ctx, table, StageQuery, errorf("querying '%s': %w", table, err))
+	}
+
+	ranges, err := a.ranges(qctx, source)
+	if err != nil {
+		return a.fail(ctx, table, StageQuery, errorf("splitting '%s': %w", table, err))
+	}
+
+	rows, err := a.queryRanges(qctx, source, ranges)
+	if err != nil {
+		return a.fail(ctx, table, StageQuery, errorf("querying '%s': %w", table, err))
+	}
+	defer func() {
+		for _, r := range rows {
+			if e := r.Close(); e != nil && err == nil {
+				err = errorf("closing rows: %w", e)
+			}
+		}
+	}()
+
+	columns, err := a.prepare(rows[0])
+	if err != nil {
+		return a.fail(ctx, table, StageDownload, err)
+	}
+
+	if a.parts {
+		a.numbers = newPartNumbers()
+		for _, r := range rows {
+			b, r := *a, r
+			g.Go(func() error {
+				return b.download(gctx, g, r, columns, table, bucket)
+			})
+		}
+	} else {
+		merged, err := mergeRows(gctx, g, rows)
+		if err != nil {
+			return a.fail(ctx, table, StageDownload, errorf("getting column types from rows: %w", err))
+		}
+		g.Go(func() error {
+			return a.download(gctx, g, merged, columns, table, bucket)
+		})
+	}
+
+	return g.Wait()
+}
+
+// queryRanges starts the query of each range concurrently, closing them all if any fails.
+func (a *Archiver) queryRanges(ctx context.Context, table string, ranges []splitRange) ([]cursor, error) {
+	var (
+		rows = make([]cursor, len(ranges))
+		errs = make([]error, len(ranges))
+		wg   sync.WaitGroup
+	)
+	for i := range ranges {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rows[i], errs[i] = a.queryRange(ctx, table, &ranges[i])
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			for _, r := range rows {
+				if r != nil {
+					_ = r.Close()
+				}
+			}
+			return nil, err
+		}
+	}
+
+	return rows, nil
+}
+
+// minTIDRangeVersion is the first Postgres version, 14, with TID range scans. On earlier servers each query of
+// a range of ctid values scans the whole table.
+const minTIDRangeVersion = 140000
+
+// ranges splits a table into ranges of its split column, by the column's minimum and maximum values,
+// or by blocks of the table's heap when splitting a Postgres table by ctid. Tables are not split by
+// ctid on servers without TID range scans, and are read by a single query instead.
+func (a *Archiver) ranges(ctx context.Context, table string) ([]splitRange, error) {
+	var (
+		lo, hi sql.NullInt64
+		err    error
+	)
+	if a.ctid() {
+		var version sql.NullInt64
+		if err := a.queryRow(ctx, "SELECT current_setting('server_version_num')::int;", nil, &version); err != nil {
+			return nil, fmt.Errorf("querying server version: %w", err)
+		}
+		if version.Int64 < minTIDRangeVersion {
+			return []splitRange{{}}, nil
+		}
+
+		lo.Valid = true
+		err = a.queryRow(ctx, "SELECT pg_relation_size($1::regclass) / current_setting('block_size')::int;", []interface{}{a.dialect.Quote(table)}, &hi)
+	} else {
+		var (
+			column = a.dialect.Quote(a.splitColumn)
+			query  = fmt.Sprintf("SELECT MIN(%s), MAX(%s) FROM %s", column, column, a.dialect.Quote(table))
+		)
+		if a.where != "" {
+			query = fmt.Sprintf("%s WHERE (%s)", query, a.bind(a.where))
+		}
+		err = a.queryRow(ctx, query+";", a.whereArgs, &lo, &hi)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("querying range of split column '%s': %w", a.splitColumn, err)
+	}
+
+	var bounds []int64
+	if lo.Valid && hi.Valid {
+		bounds = split(lo.Int64, hi.Int64, a.parallelism)
+	}
+
+	ranges := make([]splitRange, len(bounds)+1)
+	for i, bound := range bounds {
+		ranges[i].upper = a.rangeBound(bound)
+		ranges[i+1].lower = a.rangeBound(bound)
+	}
+
+	return ranges, nil
+}
+
+// queryRow queries a single row, scanning it into dest.
+func (a *Archiver) queryRow(ctx context.Context, query string, args []interface{}, dest ...interface{}) error {
+	rows, err := a.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return err
+	}
+
+	return rows.Close()
+}
+
+// split returns the bounds dividing the values lo to hi, inclusive, into at most n ranges of equal size.
+func split(lo, hi int64, n int) []int64 {
+	count := uint64(hi) - uint64(lo) + 1
+	if count == 0 {
+		count = math.MaxUint64
+	}
+	if count < uint64(n) {
+		n = int(count)
+	}
+
+	var (
+		bounds = make([]int64, 0, n-1)
+		q, r   = count / uint64(n), count % uint64(n)
+	)
+	for i := uint64(1); i < uint64(n); i++ {
+		bounds = append(bounds, lo+int64(q*i+r*i/uint64(n)))
+	}
+
+	return bounds
+}
+
+func (a *Archiver) rangeBound(bound int64) interface{} {
+	if a.ctid() {
+		return fmt.Sprintf("(%d,0)", bound)
+	}
+
+	return bound
+}
+
+// rangeCondition returns the condition selecting a range of the split column, appending its args.
+func (a *Archiver) rangeCondition(r *splitRange, args []interface{}) (string, []interface{}) {
+	var (
+		column = a.dialect.Quote(a.splitColumn)
+		cast   string
+		terms  []string
+	)
+	if a.ctid() {
+		cast = "::tid"
+	}
+	if r.lower != nil {
+		args = append(args, r.lower)
+		terms = append(terms, fmt.Sprintf("%s >= %s%s", column, a.dialect.Placeholder(len(args)), cast))
+	}
+	if r.upper != nil {
+		args = append(args, r.upper)
+		terms = append(terms, fmt.Sprintf("%s < %s%s", column, a.dialect.Placeholder(len(args)), cast))
+	}
+
+	if r.lower == nil && r.upper != nil && !a.ctid() {
+		return fmt.Sprintf("(%s OR %s IS NULL)", terms[0], column), args
+	}
+
+	return strings.Join(terms, " AND "), args
+}
+
+// ctid reports whether a Postgres table is split by the physical location of its rows.
+func (a *Archiver) ctid() bool {
+	_, ok := a.dialect.(postgresDialect)
+	return ok && a.splitColumn == "ctid"
+}
+
+// mergedRows merges the rows of the concurrent queries of a parallel archive into one stream.
+// Each query is scanned in the archive's group, so that a failing query cancels the archive.
+type mergedRows struct {
+	types   []*sql.ColumnType
+	records chan [][]byte
+	record  [][]byte
+	mu      sync.Mutex
+	err     error
+}
+
+// mergeRows starts scanning the rows of each query, closing the stream once all are scanned.
+func mergeRows(ctx context.Context, g *errgroup.Group, rows []cursor) (*mergedRows, error) {
+	types, err := rows[0].ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		m  = &mergedRows{types: types, records: make(chan [][]byte, len(rows))}
+		wg sync.WaitGroup
+	)
+	for i, r := range rows {
+		i, r := i, r
+		wg.Add(1)
+		g.Go(func() error {
+			defer wg.Done()
+			if err := m.scan(ctx, r); err != nil {
+				m.fail(err)
+				return errorf("downloading: scanning range %d: %w", i, err)
+			}
+			return nil
+		})
+	}
+
+	go func() {
+		wg.Wait()
+		close(m.records)
+	}()
+
+	return m, nil
+}
+
+// scan copies each row of a query to the stream.
+func (m *mergedRows) scan(ctx context.Context, rows cursor) error {
+	var (
+		raw     = make([]sql.RawBytes, len(m.types))
+		scanned = make([]interface{}, len(m.types))
+	)
+	for i := range raw {
+		scanned[i] = &raw[i]
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(scanned...); err != nil {
+			return err
+		}
+
+		var (
+			record = make([][]byte, len(raw))
+			size   int
+		)
+		for _, value := range raw {
+			size += len(value)
+		}
+		buf := make([]byte, 0, size)
+		for i, value := range raw {
+			if value != nil {
+				buf = append(buf, value...)
+				record[i] = buf[len(buf)-len(value) : len(buf) : len(buf)]
+			}
+		}
+
+		select {
+		case m.records <- record:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return rows.Err()
+}
+
+func (m *mergedRows) fail(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.err == nil {
+		m.err = err
+	}
+}
+
+func (m *mergedRows) ColumnTypes() ([]*sql.ColumnType, error) {
+	return m.types, nil
+}
+
+func (m *mergedRows) Next() bool {
+	var ok bool
+	m.record, ok = <-m.records
+	return ok
+}
+
+func (m *mergedRows) Scan(dest ...interface{}) error {
+	for i, d := range dest {
+		raw, ok := d.(*sql.RawBytes)
+		if !ok {
+			return fmt.Errorf("unsupported scan type %T", d)
+		}
+		*raw = m.record[i]
+	}
+
+	return nil
+}
+
+func (m *mergedRows) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.err
+}
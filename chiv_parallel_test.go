@@ -0,0 +1,186 @@
+// +build unit
+
+package chiv_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gavincabbage.com/chiv"
+)
+
+func TestArchiveParallel(t *testing.T) {
+	db := newSQLite(t, `
+		CREATE TABLE events (id INTEGER, name TEXT);
+		CREATE TABLE extremes (id INTEGER, name TEXT);
+		INSERT INTO events VALUES (NULL, 'null');
+		INSERT INTO extremes VALUES (-9223372036854775808, 'min'), (0, 'zero'), (9223372036854775807, 'max');
+	`)
+
+	var all []string
+	for i := 1; i <= 20; i++ {
+		_, err := db.Exec(`INSERT INTO events VALUES (?, ?);`, i, fmt.Sprintf("event%d", i))
+		require.NoError(t, err)
+		all = append(all, fmt.Sprintf("%d,event%d", i, i))
+	}
+	all = append(all, ",null")
+	sort.Strings(all)
+
+	cases := []struct {
+		name     string
+		table    string
+		options  []chiv.Option
+		keys     []string
+		expected []string
+		err      string
+	}{
+		{
+			name:     "merged",
+			table:    "events",
+			options:  []chiv.Option{chiv.WithParallelism(4, "id")},
+			keys:     []string{"events"},
+			expected: all,
+		},
+		{
+			name:     "parts",
+			table:    "events",
+			options:  []chiv.Option{chiv.WithParallelism(4, "id"), chiv.WithParallelParts()},
+			keys:     []string{"events-00001", "events-00002", "events-00003", "events-00004"},
+			expected: all,
+		},
+		{
+			name:     "batches",
+			table:    "events",
+			options:  []chiv.Option{chiv.WithParallelism(4, "id"), chiv.WithBatchSize(2, "id"), chiv.WithWhere("id IS NOT NULL")},
+			keys:     []string{"events"},
+			expected: all[1:],
+		},
+		{
+			name:     "more queries than values",
+			table:    "extremes",
+			options:  []chiv.Option{chiv.WithParallelism(8, "id")},
+			keys:     []string{"extremes"},
+			expected: []string{"-9223372036854775808,min", "0,zero", "9223372036854775807,max"},
+		},
+		{
+			name:     "where",
+			table:    "events",
+			options:  []chiv.Option{chiv.WithParallelism(3, "id"), chiv.WithWhere("id > ?", 17)},
+			keys:     []string{"events"},
+			expected: []string{"18,event18", "19,event19", "20,event20"},
+		},
+		{
+			name:    "merged error",
+			table:   "events",
+			options: []chiv.Option{chiv.WithParallelism(4, "id"), chiv.WithFormat(failing)},
+			err:     "chiv: downloading: formatting row: formatting",
+		},
+		{
+			name:    "parts error",
+			table:   "events",
+			options: []chiv.Option{chiv.WithParallelism(4, "id"), chiv.WithParallelParts(), chiv.WithFormat(failing)},
+			err:     "chiv: downloading: formatting row: formatting",
+		},
+		{
+			name:    "limit",
+			table:   "events",
+			options: []chiv.Option{chiv.WithParallelism(4, "id"), chiv.WithLimit(10)},
+			err:     "chiv: querying 'events': parallelism cannot be used with a limit or incremental archival",
+		},
+		{
+			name:    "no split column",
+			table:   "events",
+			options: []chiv.Option{chiv.WithParallelism(4, "")},
+			err:     "chiv: querying 'events': parallelism requires a split column",
+		},
+		{
+			name:    "unknown split column",
+			table:   "events",
+			options: []chiv.Option{chiv.WithParallelism(4, "missing")},
+			err:     "chiv: querying 'events': column 'missing' not found in table 'events'",
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				uploader = &uploader{}
+				subject  = chiv.NewArchiver(db, uploader, chiv.WithFormat(writing))
+			)
+
+			err := subject.Archive(test.table, "bucket", test.options...)
+			if test.err != "" {
+				require.EqualError(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+
+			var (
+				keys  []string
+				lines []string
+			)
+			for key, body := range uploader.uploads {
+				keys = append(keys, key)
+				lines = append(lines, strings.Split(strings.TrimSuffix(body, "\n"), "\n")...)
+			}
+			sort.Strings(keys)
+			sort.Strings(lines)
+			require.Equal(t, test.keys, keys)
+			require.Equal(t, test.expected, lines)
+		})
+	}
+}
+
+func failing(io.Writer, []chiv.Column) chiv.Formatter {
+	return &formatter{formatErr: errors.New("formatting")}
+}
+
+func TestArchiveParallelCTID(t *testing.T) {
+	cases := []struct {
+		name     string
+		version  int64
+		expected []string
+	}{
+		{
+			name:    "tid range scans",
+			version: 140000,
+			expected: []string{
+				`SELECT * FROM "events" WHERE "ctid" < $1::tid;`,
+				`SELECT * FROM "events" WHERE "ctid" >= $1::tid AND "ctid" < $2::tid;`,
+				`SELECT * FROM "events" WHERE "ctid" >= $1::tid;`,
+			},
+		},
+		{
+			name:     "without tid range scans",
+			version:  130000,
+			expected: []string{`SELECT * FROM "events";`},
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			f := &fixture{columns: []string{"value"}, rows: [][]interface{}{{test.version}}}
+			db := newFixture(t, f)
+			defer db.Close()
+
+			err := chiv.Archive(db, &uploader{}, "events", "bucket", chiv.WithDialect(chiv.Postgres), chiv.WithFormat(writing), chiv.WithParallelism(3, "ctid"))
+			require.NoError(t, err)
+
+			var selects []string
+			for _, query := range f.queries {
+				if strings.HasPrefix(query, `SELECT * FROM "events"`) {
+					selects = append(selects, query)
+				}
+			}
+			sort.Strings(selects)
+			sort.Strings(test.expected)
+			require.Equal(t, test.expected, selects)
+		})
+	}
+}
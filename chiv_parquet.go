@@ -0,0 +1,378 @@
+package chiv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// Parquet file format constants, see https://github.com/apache/parquet-format.
+const (
+	parquetMagic = "PAR1"
+
+	parquetBoolean   int32 = 0
+	parquetInt64     int32 = 2
+	parquetDouble    int32 = 5
+	parquetByteArray int32 = 6
+
+	parquetOptional     int32 = 1
+	parquetUTF8         int32 = 0
+	parquetDecimal      int32 = 5
+	parquetPlain        int32 = 0
+	parquetRLE          int32 = 3
+	parquetDataPage     int32 = 0
+	parquetUncompressed int32 = 0
+)
+
+// parquetMaxInt64Precision is the largest decimal precision stored as an INT64 rather than a BYTE_ARRAY.
+const parquetMaxInt64Precision = 18
+
+// parquetChunk buffers the values of one column for the current row group. Decimal columns
+// have a precision, and store their unscaled values.
+type parquetChunk struct {
+	name      string
+	kind      kind
+	precision int
+	scale     int
+	levels    []byte
+	values    bytes.Buffer
+	bools     []bool
+}
+
+func (c *parquetChunk) append(v []byte) error {
+	if v == nil {
+		c.levels = append(c.levels, 0)
+		return nil
+	}
+	c.levels = append(c.levels, 1)
+
+	var (
+		s   = string(v)
+		buf [8]byte
+	)
+	if c.precision > 0 {
+		n, err := unscaled(s, c.scale)
+		if err != nil {
+			return err
+		}
+		if c.precision <= parquetMaxInt64Precision {
+			binary.LittleEndian.PutUint64(buf[:], uint64(n.Int64()))
+			c.values.Write(buf[:])
+			return nil
+		}
+		v = twosComplement(n)
+	}
+
+	switch c.kind {
+	case boolean:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		c.bools = append(c.bools, b)
+	case integer:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint64(buf[:], uint64(n))
+		c.values.Write(buf[:])
+	case float:
+		n, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(n))
+		c.values.Write(buf[:])
+	default:
+		binary.LittleEndian.PutUint32(buf[:4], uint32(len(v)))
+		c.values.Write(buf[:4])
+		c.values.Write(v)
+	}
+
+	return nil
+}
+
+// unscaled returns the unscaled value of a decimal of the given scale.
+func unscaled(s string, scale int) (*big.Int, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("invalid decimal '%s'", s)
+	}
+	r.Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)))
+	if !r.IsInt() {
+		return nil, fmt.Errorf("decimal '%s' exceeds scale %d", s, scale)
+	}
+
+	return r.Num(), nil
+}
+
+// twosComplement encodes an integer in big-endian two's complement, as Parquet stores decimals in byte arrays.
+func twosComplement(n *big.Int) []byte {
+	size := n.BitLen()/8 + 1
+	if n.Sign() < 0 {
+		n = new(big.Int).Add(n, new(big.Int).Lsh(big.NewInt(1), uint(8*size)))
+	}
+
+	return n.FillBytes(make([]byte, size))
+}
+
+func (c *parquetChunk) physicalType() int32 {
+	if c.precision > parquetMaxInt64Precision {
+		return parquetByteArray
+	} else if c.precision > 0 {
+		return parquetInt64
+	}
+
+	switch c.kind {
+	case boolean:
+		return parquetBoolean
+	case integer:
+		return parquetInt64
+	case float:
+		return parquetDouble
+	}
+
+	return parquetByteArray
+}
+
+// page encodes the definition levels and PLAIN encoded values of a v1 data page.
+func (c *parquetChunk) page() []byte {
+	var (
+		b      bytes.Buffer
+		levels = rle(c.levels)
+		length [4]byte
+	)
+	binary.LittleEndian.PutUint32(length[:], uint32(len(levels)))
+	b.Write(length[:])
+	b.Write(levels)
+
+	if c.kind == boolean {
+		packed := make([]byte, (len(c.bools)+7)/8)
+		for i, v := range c.bools {
+			if v {
+				packed[i/8] |= 1 << uint(i%8)
+			}
+		}
+		b.Write(packed)
+	} else {
+		b.Write(c.values.Bytes())
+	}
+
+	return b.Bytes()
+}
+
+func (c *parquetChunk) reset() {
+	c.levels = c.levels[:0]
+	c.bools = c.bools[:0]
+	c.values.Reset()
+}
+
+// rle encodes levels of bit width one using only the run length half of the RLE/bit-packing hybrid.
+func rle(levels []byte) []byte {
+	var b bytes.Buffer
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		writeUvarint(&b, uint64(j-i)<<1)
+		b.WriteByte(levels[i])
+		i = j
+	}
+
+	return b.Bytes()
+}
+
+type parquetColumnChunk struct {
+	kind   int32
+	name   string
+	offset int64
+	size   int64
+	values int64
+}
+
+type parquetRowGroup struct {
+	columns []parquetColumnChunk
+	size    int64
+	rows    int64
+}
+
+func parquetPageHeader(values, size int) []byte {
+	var c compact
+	c.begin()
+	c.i32(1, parquetDataPage)
+	c.i32(2, int32(size))
+	c.i32(3, int32(size))
+	c.field(5, compactStruct)
+	c.begin()
+	c.i32(1, int32(values))
+	c.i32(2, parquetPlain)
+	c.i32(3, parquetRLE)
+	c.i32(4, parquetRLE)
+	c.end()
+	c.end()
+
+	return c.Bytes()
+}
+
+func parquetFileMetaData(chunks []*parquetChunk, groups []parquetRowGroup, rows int64) []byte {
+	var c compact
+	c.begin()
+	c.i32(1, 1)
+
+	c.list(2, compactStruct, len(chunks)+1)
+	c.begin()
+	c.binary(4, []byte("schema"))
+	c.i32(5, int32(len(chunks)))
+	c.end()
+	for _, chunk := range chunks {
+		c.begin()
+		c.i32(1, chunk.physicalType())
+		c.i32(3, parquetOptional)
+		c.binary(4, []byte(chunk.name))
+		if chunk.precision > 0 {
+			c.i32(6, parquetDecimal)
+			c.i32(7, int32(chunk.scale))
+			c.i32(8, int32(chunk.precision))
+			c.field(10, compactStruct)
+			c.begin()
+			c.field(5, compactStruct)
+			c.begin()
+			c.i32(1, int32(chunk.scale))
+			c.i32(2, int32(chunk.precision))
+			c.end()
+			c.end()
+		} else if chunk.kind == text {
+			c.i32(6, parquetUTF8)
+		}
+		c.end()
+	}
+
+	c.i64(3, rows)
+
+	c.list(4, compactStruct, len(groups))
+	for _, group := range groups {
+		c.begin()
+		c.list(1, compactStruct, len(group.columns))
+		for _, column := range group.columns {
+			c.begin()
+			c.i64(2, column.offset)
+			c.field(3, compactStruct)
+			c.begin()
+			c.i32(1, column.kind)
+			c.list(2, compactI32, 2)
+			c.varint(int64(parquetPlain))
+			c.varint(int64(parquetRLE))
+			c.list(3, compactBinary, 1)
+			writeUvarint(&c.Buffer, uint64(len(column.name)))
+			c.WriteString(column.name)
+			c.i32(4, parquetUncompressed)
+			c.i64(5, column.values)
+			c.i64(6, column.size)
+			c.i64(7, column.size)
+			c.i64(9, column.offset)
+			c.end()
+			c.end()
+		}
+		c.i64(2, group.size)
+		c.i64(3, group.rows)
+		c.end()
+	}
+
+	c.binary(6, []byte("chiv"))
+	c.end()
+
+	return c.Bytes()
+}
+
+// Thrift compact protocol type identifiers.
+const (
+	compactI32    = byte(5)
+	compactI64    = byte(6)
+	compactBinary = byte(8)
+	compactList   = byte(9)
+	compactStruct = byte(12)
+)
+
+// compact is a minimal Thrift compact protocol encoder, sufficient for Parquet metadata.
+type compact struct {
+	bytes.Buffer
+	ids []int16
+}
+
+func (c *compact) begin() {
+	c.ids = append(c.ids, 0)
+}
+
+func (c *compact) end() {
+	c.WriteByte(0)
+	c.ids = c.ids[:len(c.ids)-1]
+}
+
+func (c *compact) field(id int16, t byte) {
+	last := &c.ids[len(c.ids)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		c.WriteByte(byte(delta)<<4 | t)
+	} else {
+		c.WriteByte(t)
+		c.varint(int64(id))
+	}
+	*last = id
+}
+
+func (c *compact) i32(id int16, v int32) {
+	c.field(id, compactI32)
+	c.varint(int64(v))
+}
+
+func (c *compact) i64(id int16, v int64) {
+	c.field(id, compactI64)
+	c.varint(v)
+}
+
+func (c *compact) binary(id int16, b []byte) {
+	c.field(id, compactBinary)
+	writeUvarint(&c.Buffer, uint64(len(b)))
+	c.Write(b)
+}
+
+func (c *compact) list(id int16, t byte, size int) {
+	c.field(id, compactList)
+	if size < 15 {
+		c.WriteByte(byte(size)<<4 | t)
+	} else {
+		c.WriteByte(0xf0 | t)
+		writeUvarint(&c.Buffer, uint64(size))
+	}
+}
+
+func (c *compact) varint(v int64) {
+	writeUvarint(&c.Buffer, uint64((v<<1)^(v>>63)))
+}
+
+func writeUvarint(b *bytes.Buffer, v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	b.Write(buf[:n])
+}
+
+// offsetWriter tracks the number of bytes written to the underlying writer.
+type offsetWriter struct {
+	w      io.Writer
+	offset int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.offset += int64(n)
+	if err != nil {
+		return n, fmt.Errorf("writing parquet: %w", err)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,283 @@
+package chiv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ParserFunc returns an initialized Parser.
+type ParserFunc func(io.Reader) Parser
+
+// Parser reads records written by a Formatter, the counterpart of Formatter used by Restore.
+// A Parser of a format representing null values natively may implement NullPreserver.
+type Parser interface {
+	// Open the Parser and return the names of the columns of its records.
+	Open() ([]string, error)
+	// Parse and return a single record, or io.EOF after the last record. Records may be reused between calls.
+	Parse() ([][]byte, error)
+	// Close the Parser and perform any format-specific cleanup.
+	Close() error
+}
+
+type csvParser struct {
+	r *csv.Reader
+}
+
+// ParseCSV returns an initialized parser of CSV written by the CSV formatter.
+func ParseCSV(r io.Reader) Parser {
+	return &csvParser{r: csv.NewReader(r)}
+}
+
+// Open the CSV parser by reading the CSV header.
+func (p *csvParser) Open() ([]string, error) {
+	p.r.ReuseRecord = true
+	header, err := p.r.Read()
+	if err == io.EOF {
+		return nil, errors.New("reading header: missing header")
+	} else if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+
+	return append([]string(nil), header...), nil
+}
+
+// Parse a CSV record.
+func (p *csvParser) Parse() ([][]byte, error) {
+	strings, err := p.r.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	record := make([][]byte, len(strings))
+	for i, s := range strings {
+		record[i] = []byte(s)
+	}
+
+	return record, nil
+}
+
+// Close the CSV parser.
+func (*csvParser) Close() error {
+	return nil
+}
+
+// ParseYAML returns an initialized parser of YAML written by the YAML formatter.
+func ParseYAML(r io.Reader) Parser {
+	return &mapParser{next: yamlRecords(bufio.NewReader(r))}
+}
+
+// ParseJSON returns an initialized parser of a JSON array written by the JSON formatter.
+func ParseJSON(r io.Reader) Parser {
+	d := json.NewDecoder(r)
+	d.UseNumber()
+
+	return &mapParser{open: jsonArray(d), next: jsonRecords(d, true)}
+}
+
+// ParseNDJSON returns an initialized parser of newline-delimited JSON written by the NDJSON formatter.
+func ParseNDJSON(r io.Reader) Parser {
+	d := json.NewDecoder(r)
+	d.UseNumber()
+
+	return &mapParser{next: jsonRecords(d, false)}
+}
+
+// mapParser parses formats whose records are maps of column names to values. The columns are the
+// sorted keys of the first record, matching the formatters' sorted output.
+type mapParser struct {
+	open    func() error
+	next    func() (map[string]interface{}, error)
+	columns map[string]int
+	first   map[string]interface{}
+}
+
+// Open the parser by reading the first record for its columns.
+func (p *mapParser) Open() ([]string, error) {
+	if p.open != nil {
+		if err := p.open(); err != nil {
+			return nil, err
+		}
+	}
+
+	first, err := p.next()
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	p.first = first
+
+	columns := make([]string, 0, len(first))
+	for column := range first {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	p.columns = make(map[string]int, len(columns))
+	for i, column := range columns {
+		p.columns[column] = i
+	}
+
+	return columns, nil
+}
+
+// Parse a record, with nil values for any columns it is missing.
+func (p *mapParser) Parse() ([][]byte, error) {
+	if p.columns == nil {
+		return nil, io.EOF
+	}
+
+	m := p.first
+	if m != nil {
+		p.first = nil
+	} else {
+		var err error
+		if m, err = p.next(); err != nil {
+			return nil, err
+		}
+	}
+
+	record := make([][]byte, len(p.columns))
+	for column, v := range m {
+		i, ok := p.columns[column]
+		if !ok {
+			return nil, fmt.Errorf("unexpected column '%s'", column)
+		}
+		if record[i], ok = unparse(v); !ok {
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, fmt.Errorf("parsing column '%s': %w", column, err)
+			}
+			record[i] = b
+		}
+	}
+
+	return record, nil
+}
+
+// Close the parser.
+func (*mapParser) Close() error {
+	return nil
+}
+
+// PreserveNull reports that map formats represent null values natively.
+func (*mapParser) PreserveNull() bool {
+	return true
+}
+
+// unparse formats a parsed scalar value as it was scanned from the database, reporting false for other values.
+func unparse(v interface{}) ([]byte, bool) {
+	switch v := v.(type) {
+	case nil:
+		return nil, true
+	case string:
+		return []byte(v), true
+	case bool:
+		return []byte(strconv.FormatBool(v)), true
+	case json.Number:
+		return []byte(v.String()), true
+	case int:
+		return []byte(strconv.Itoa(v)), true
+	case int64:
+		return []byte(strconv.FormatInt(v, 10)), true
+	case uint64:
+		return []byte(strconv.FormatUint(v, 10)), true
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'f', -1, 64)), true
+	}
+
+	return nil, false
+}
+
+// jsonArray reads the opening bracket of a JSON array.
+func jsonArray(d *json.Decoder) func() error {
+	return func() error {
+		t, err := d.Token()
+		if err != nil {
+			return fmt.Errorf("reading json: %w", err)
+		}
+		if t != json.Delim(openBracket) {
+			return errors.New("reading json: expected array")
+		}
+
+		return nil
+	}
+}
+
+// jsonRecords decodes JSON objects, within an array if inArray.
+func jsonRecords(d *json.Decoder, inArray bool) func() (map[string]interface{}, error) {
+	return func() (map[string]interface{}, error) {
+		if inArray && !d.More() {
+			if _, err := d.Token(); err != nil {
+				return nil, fmt.Errorf("reading json: %w", err)
+			}
+			return nil, io.EOF
+		}
+
+		var m map[string]interface{}
+		if err := d.Decode(&m); err == io.EOF {
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, fmt.Errorf("reading json: %w", err)
+		}
+
+		return m, nil
+	}
+}
+
+// yamlRecords decodes the items of the YAML sequence written by the YAML formatter one at a time.
+// Each item starts with an unindented "- " line, so items can be split without decoding the whole sequence.
+func yamlRecords(r *bufio.Reader) func() (map[string]interface{}, error) {
+	var (
+		item []byte
+		done bool
+	)
+
+	return func() (map[string]interface{}, error) {
+		for !done {
+			line, err := r.ReadBytes(newline)
+			if err != nil && err != io.EOF {
+				return nil, fmt.Errorf("reading yaml: %w", err)
+			}
+			done = err == io.EOF
+
+			start := bytes.HasPrefix(line, []byte("- "))
+			if start && len(item) > 0 {
+				m, err := yamlRecord(item)
+				item = append(item[:0], line...)
+				return m, err
+			}
+			item = append(item, line...)
+		}
+
+		if len(bytes.TrimSpace(item)) == 0 {
+			return nil, io.EOF
+		}
+
+		m, err := yamlRecord(item)
+		item = item[:0]
+		return m, err
+	}
+}
+
+// yamlRecord decodes a single item of a YAML sequence.
+func yamlRecord(item []byte) (map[string]interface{}, error) {
+	var items []map[string]interface{}
+	if err := yaml.Unmarshal(item, &items); err != nil {
+		return nil, fmt.Errorf("reading yaml: %w", err)
+	}
+	if len(items) != 1 {
+		return nil, fmt.Errorf("reading yaml: expected one record, found %d", len(items))
+	}
+
+	return items[0], nil
+}
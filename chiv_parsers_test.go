@@ -0,0 +1,110 @@
+// +build unit
+
+package chiv_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gavincabbage.com/chiv"
+)
+
+func TestParsers(t *testing.T) {
+	cases := []struct {
+		name     string
+		parser   chiv.ParserFunc
+		input    string
+		columns  []string
+		expected [][][]byte
+	}{
+		{
+			name:     "csv",
+			parser:   chiv.ParseCSV,
+			input:    "first_column,second_column\nfirst,\"with, comma\"\nsecond,\n",
+			columns:  []string{"first_column", "second_column"},
+			expected: [][][]byte{{[]byte("first"), []byte("with, comma")}, {[]byte("second"), []byte("")}},
+		},
+		{
+			name:     "csv header only",
+			parser:   chiv.ParseCSV,
+			input:    "first_column\n",
+			columns:  []string{"first_column"},
+			expected: nil,
+		},
+		{
+			name:     "json",
+			parser:   chiv.ParseJSON,
+			input:    `[{"b":1.50,"a":"first","c":true},{"a":null,"b":-2}]`,
+			columns:  []string{"a", "b", "c"},
+			expected: [][][]byte{{[]byte("first"), []byte("1.50"), []byte("true")}, {nil, []byte("-2"), nil}},
+		},
+		{
+			name:     "json empty",
+			parser:   chiv.ParseJSON,
+			input:    `[]`,
+			columns:  nil,
+			expected: nil,
+		},
+		{
+			name:     "ndjson",
+			parser:   chiv.ParseNDJSON,
+			input:    "{\"a\":\"first\",\"b\":{\"nested\":1}}\n{\"a\":\"second\",\"b\":null}\n",
+			columns:  []string{"a", "b"},
+			expected: [][][]byte{{[]byte("first"), []byte(`{"nested":1}`)}, {[]byte("second"), nil}},
+		},
+		{
+			name:     "yaml",
+			parser:   chiv.ParseYAML,
+			input:    "- a: first\n  b: 1\n- a: |-\n    multiple\n    - lines\n  b: null\n- a: \"3\"\n  b: 2.5\n",
+			columns:  []string{"a", "b"},
+			expected: [][][]byte{{[]byte("first"), []byte("1")}, {[]byte("multiple\n- lines"), nil}, {[]byte("3"), []byte("2.5")}},
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			subject := test.parser(strings.NewReader(test.input))
+
+			columns, err := subject.Open()
+			require.NoError(t, err)
+			require.Equal(t, test.columns, columns)
+
+			var records [][][]byte
+			for {
+				record, err := subject.Parse()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				records = append(records, record)
+			}
+			require.Equal(t, test.expected, records)
+			require.NoError(t, subject.Close())
+		})
+	}
+}
+
+func TestParsersErrors(t *testing.T) {
+	t.Run("csv missing header", func(t *testing.T) {
+		_, err := chiv.ParseCSV(strings.NewReader("")).Open()
+		require.EqualError(t, err, "reading header: missing header")
+	})
+
+	t.Run("json not an array", func(t *testing.T) {
+		_, err := chiv.ParseJSON(strings.NewReader(`{"a":1}`)).Open()
+		require.EqualError(t, err, "reading json: expected array")
+	})
+
+	t.Run("unexpected column", func(t *testing.T) {
+		subject := chiv.ParseNDJSON(strings.NewReader("{\"a\":1}\n{\"b\":2}\n"))
+		_, err := subject.Open()
+		require.NoError(t, err)
+		_, err = subject.Parse()
+		require.NoError(t, err)
+		_, err = subject.Parse()
+		require.EqualError(t, err, "unexpected column 'b'")
+	})
+}
@@ -0,0 +1,233 @@
+package chiv
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	defaultMaxOpenPartitions = 8
+	hiveDefaultPartition     = "__HIVE_DEFAULT_PARTITION__"
+)
+
+// objects tracks the open objects of an archive by partition. When the number of open
+// partitions reaches the configured limit, the least recently used partition is closed.
+// Rows later routed to a closed partition are written to a new part.
+type objects struct {
+	archiver *Archiver
+	ctx      context.Context
+	g        *errgroup.Group
+	columns  []Column
+	table    string
+	bucket   string
+	active   map[string]*object
+	parts    *partNumbers
+	recent   []string
+}
+
+func (a *Archiver) newObjects(ctx context.Context, g *errgroup.Group, columns []Column, table, bucket string) *objects {
+	parts := a.numbers
+	if parts == nil {
+		parts = newPartNumbers()
+	}
+
+	return &objects{
+		archiver: a,
+		ctx:      ctx,
+		g:        g,
+		columns:  columns,
+		table:    table,
+		bucket:   bucket,
+		active:   make(map[string]*object),
+		parts:    parts,
+	}
+}
+
+// get the open object for a partition, opening a new part if the partition has
+// no open object or its open object is full.
+func (s *objects) get(partition string) (*object, error) {
+	o, ok := s.active[partition]
+	if ok && s.archiver.full(o) {
+		if err := s.closePartition(partition); err != nil {
+			return nil, err
+		}
+		ok = false
+	}
+
+	if !ok {
+		if s.archiver.maxOpen > 0 && len(s.active) >= s.archiver.maxOpen {
+			if err := s.closePartition(s.recent[0]); err != nil {
+				return nil, err
+			}
+		}
+
+		var err error
+		if o, err = s.open(partition, s.parts.next(partition)); err != nil {
+			return nil, err
+		}
+		s.active[partition] = o
+	}
+
+	s.touch(partition)
+	return o, nil
+}
+
+// partNumbers numbers the parts of each partition, and may be shared by concurrent downloads.
+type partNumbers struct {
+	mu    sync.Mutex
+	parts map[string]int
+}
+
+func newPartNumbers() *partNumbers {
+	return &partNumbers{parts: make(map[string]int)}
+}
+
+// next returns the next part number of a partition, numbering from one.
+func (p *partNumbers) next(partition string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.parts[partition]++
+	return p.parts[partition]
+}
+
+func (s *objects) touch(partition string) {
+	if n := len(s.recent); n > 0 && s.recent[n-1] == partition {
+		return
+	}
+
+	s.remove(partition)
+	s.recent = append(s.recent, partition)
+}
+
+func (s *objects) remove(partition string) {
+	for i, p := range s.recent {
+		if p == partition {
+			s.recent = append(s.recent[:i], s.recent[i+1:]...)
+			return
+		}
+	}
+}
+
+func (s *objects) closePartition(partition string) error {
+	o := s.active[partition]
+	delete(s.active, partition)
+	s.remove(partition)
+
+	if err := o.close(); err != nil {
+		return err
+	}
+	s.archiver.hooks.formatterClose(s.ctx, s.table, o.result.Key)
+
+	return nil
+}
+
+// close all open objects.
+func (s *objects) close() error {
+	for len(s.recent) > 0 {
+		if err := s.closePartition(s.recent[0]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// abort all open objects with the given error.
+func (s *objects) abort(err error) {
+	for _, o := range s.active {
+		o.abort(err)
+	}
+}
+
+// partitionColumns returns the indexes of the configured partition columns and of the remaining data columns.
+func (a *Archiver) partitionColumns(columns []Column) (partitioned, data []int, err error) {
+	if len(a.partition) == 0 {
+		data = make([]int, len(columns))
+		for i := range columns {
+			data[i] = i
+		}
+		return nil, data, nil
+	}
+
+	names := make(map[string]int, len(columns))
+	for i, column := range columns {
+		names[column.Name()] = i
+	}
+
+	partitioned = make([]int, len(a.partition))
+	for i, name := range a.partition {
+		j, ok := names[name]
+		if !ok {
+			return nil, nil, fmt.Errorf("partition column '%s' not found", name)
+		}
+		partitioned[i] = j
+		delete(names, name)
+	}
+
+	for i, column := range columns {
+		if _, ok := names[column.Name()]; ok {
+			data = append(data, i)
+		}
+	}
+
+	return partitioned, data, nil
+}
+
+func columnIndex(columns []Column, name string) int {
+	for i, column := range columns {
+		if column.Name() == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func project(columns []Column, indexes []int) []Column {
+	out := make([]Column, len(indexes))
+	for i, j := range indexes {
+		out[i] = columns[j]
+	}
+
+	return out
+}
+
+// partition returns the Hive-style path, e.g. year=2019/month=09, of a record.
+func partition(columns []Column, partitioned []int, record []sql.RawBytes) string {
+	var b strings.Builder
+	for i, j := range partitioned {
+		if i > 0 {
+			b.WriteByte('/')
+		}
+		b.WriteString(escapePartition(columns[j].Name()))
+		b.WriteByte('=')
+		if record[j] == nil {
+			b.WriteString(hiveDefaultPartition)
+		} else {
+			b.WriteString(escapePartition(string(record[j])))
+		}
+	}
+
+	return b.String()
+}
+
+// escapePartition escapes characters in partition names and values as Hive does.
+func escapePartition(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c < 0x20 || c == 0x7f || strings.IndexByte("\"#%'*/:=?\\{[]^", c) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,146 @@
+// +build unit
+
+package chiv_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gavincabbage.com/chiv"
+)
+
+func TestArchiveRowsPartitioned(t *testing.T) {
+	cases := []struct {
+		name        string
+		rows        [][]interface{}
+		options     []chiv.Option
+		expected    map[string]string
+		expectedErr string
+	}{
+		{
+			name: "single column",
+			rows: [][]interface{}{
+				{"1", "2019-01-01", "us"},
+				{"2", "2019-01-02", "us"},
+				{"3", "2019-01-01", "eu"},
+			},
+			options: []chiv.Option{
+				chiv.WithPartitionBy("date"),
+			},
+			expected: map[string]string{
+				"table/date=2019-01-01/part-0001": "1,us\n3,eu\n",
+				"table/date=2019-01-02/part-0001": "2,us\n",
+			},
+		},
+		{
+			name: "multiple columns with key prefix",
+			rows: [][]interface{}{
+				{"1", "2019-01-01", "us"},
+				{"2", "2019-01-02", "us"},
+				{"3", "2019-01-01", "eu"},
+			},
+			options: []chiv.Option{
+				chiv.WithPartitionBy("region", "date"),
+				chiv.WithKey("archive/"),
+				chiv.WithExtension("csv"),
+			},
+			expected: map[string]string{
+				"archive/region=us/date=2019-01-01/part-0001.csv": "1\n",
+				"archive/region=us/date=2019-01-02/part-0001.csv": "2\n",
+				"archive/region=eu/date=2019-01-01/part-0001.csv": "3\n",
+			},
+		},
+		{
+			name: "null and escaped values",
+			rows: [][]interface{}{
+				{"1", nil, "us"},
+				{"2", "2019/01/02 12:00", "us"},
+			},
+			options: []chiv.Option{
+				chiv.WithPartitionBy("date"),
+			},
+			expected: map[string]string{
+				"table/date=__HIVE_DEFAULT_PARTITION__/part-0001": "1,us\n",
+				"table/date=2019%2F01%2F02 12%3A00/part-0001":     "2,us\n",
+			},
+		},
+		{
+			name: "max open partitions",
+			rows: [][]interface{}{
+				{"1", "2019-01-01", "us"},
+				{"2", "2019-01-02", "us"},
+				{"3", "2019-01-03", "us"},
+				{"4", "2019-01-01", "us"},
+			},
+			options: []chiv.Option{
+				chiv.WithPartitionBy("date"),
+				chiv.WithMaxOpenPartitions(2),
+			},
+			expected: map[string]string{
+				"table/date=2019-01-01/part-0001": "1,us\n",
+				"table/date=2019-01-02/part-0001": "2,us\n",
+				"table/date=2019-01-03/part-0001": "3,us\n",
+				"table/date=2019-01-01/part-0002": "4,us\n",
+			},
+		},
+		{
+			name: "max rows per object",
+			rows: [][]interface{}{
+				{"1", "2019-01-01", "us"},
+				{"2", "2019-01-01", "us"},
+				{"3", "2019-01-01", "us"},
+			},
+			options: []chiv.Option{
+				chiv.WithPartitionBy("date"),
+				chiv.WithMaxRowsPerObject(2),
+			},
+			expected: map[string]string{
+				"table/date=2019-01-01/part-0001": "1,us\n2,us\n",
+				"table/date=2019-01-01/part-0002": "3,us\n",
+			},
+		},
+		{
+			name: "no rows",
+			options: []chiv.Option{
+				chiv.WithPartitionBy("date"),
+			},
+			expected: nil,
+		},
+		{
+			name: "unknown column",
+			options: []chiv.Option{
+				chiv.WithPartitionBy("unknown"),
+			},
+			expectedErr: "chiv: downloading: partition column 'unknown' not found",
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			db := newFixture(t, &fixture{
+				columns: []string{"id", "date", "region"},
+				rows:    test.rows,
+			})
+			defer db.Close()
+
+			rows, err := db.Query("SELECT * FROM table")
+			require.NoError(t, err)
+			defer rows.Close()
+
+			var (
+				uploader = &uploader{}
+				options  = append(test.options, chiv.WithFormat(writing))
+			)
+
+			err = chiv.ArchiveRows(rows, uploader, "bucket", options...)
+			if test.expectedErr != "" {
+				require.EqualError(t, err, test.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, test.expected, uploader.uploads)
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package chiv
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultPurgeBatchSize = 500
+	// maxPurgeParameters bounds the placeholders of each batched delete, within the limits of SQLite and SQL Server.
+	maxPurgeParameters = 999
+)
+
+// PurgeResult reports the rows archived and deleted by ArchiveAndPurge.
+type PurgeResult struct {
+	Archived int64
+	Deleted  int64
+}
+
+// Beginner begins database transactions. *sql.DB implements Beginner.
+type Beginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// ArchiveAndPurge archives a Database table to S3 and, once every upload has succeeded, deletes
+// the archived rows from the table. Rows are deleted by the given key columns, which must uniquely
+// identify them, in batches within a single transaction. Use WithWhere to select the rows to move;
+// its condition is applied again when deleting, so rows no longer matching it are kept. Rows
+// changed since they were read that still match the condition are deleted.
+func (a *Archiver) ArchiveAndPurge(table, bucket string, keys []string, options ...Option) (*PurgeResult, error) {
+	return a.ArchiveAndPurgeWithContext(context.Background(), table, bucket, keys, options...)
+}
+
+// ArchiveAndPurgeWithContext is like ArchiveAndPurge, with context.
+func (a *Archiver) ArchiveAndPurgeWithContext(ctx context.Context, table, bucket string, keys []string, options ...Option) (*PurgeResult, error) {
+	b := *a
+	for _, option := range options {
+		option(&b)
+	}
+
+	if len(keys) == 0 {
+		return nil, errorf("purging '%s': no key columns", table)
+	}
+	if _, ok := b.db.(Beginner); !ok {
+		return nil, errorf("purging '%s': database does not support transactions", table)
+	}
+
+	s, err := newSpool(keys)
+	if err != nil {
+		return nil, errorf("purging '%s': %w", table, err)
+	}
+	defer s.remove()
+
+	b.spool = s
+	if err := b.ArchiveWithContext(ctx, table, bucket); err != nil {
+		return nil, err
+	}
+
+	// Rows are deleted from the table as named by the catalog, if it was validated against one.
+	source := table
+	if s.table != "" {
+		source = s.table
+	}
+	deleted, err := b.purge(ctx, source, s)
+	if err != nil {
+		return nil, errorf("purging '%s': %w", table, err)
+	}
+
+	return &PurgeResult{Archived: s.rows, Deleted: deleted}, nil
+}
+
+// purge deletes the spooled rows in batches within a transaction, rolling back if more rows
+// are deleted than were archived.
+func (a *Archiver) purge(ctx context.Context, table string, s *spool) (deleted int64, err error) {
+	if s.rows == 0 {
+		return 0, nil
+	}
+
+	tx, err := a.db.(Beginner).BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	r, err := s.reader()
+	if err != nil {
+		return 0, fmt.Errorf("reading spooled keys: %w", err)
+	}
+
+	size := defaultPurgeBatchSize
+	if limit := (maxPurgeParameters - len(a.whereArgs)) / len(s.keys); limit < size {
+		size = limit
+	}
+	if size < 1 {
+		size = 1
+	}
+
+	batch := make([][]string, 0, size)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		query, args := a.deleteQuery(table, s.keys, batch)
+		result, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return fmt.Errorf("deleting rows: %w", err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("counting deleted rows: %w", err)
+		}
+		deleted += n
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		values, err := s.read(r)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, fmt.Errorf("reading spooled keys: %w", err)
+		}
+
+		if batch = append(batch, values); len(batch) == cap(batch) {
+			if err := flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return 0, err
+	}
+
+	if deleted > s.rows {
+		return 0, fmt.Errorf("deleted %d rows but archived %d, rolled back: key columns are not unique", deleted, s.rows)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("committing transaction: %w", err)
+	}
+
+	return deleted, nil
+}
+
+// deleteQuery builds a statement deleting a batch of rows by key, if they still match the WHERE condition.
+func (a *Archiver) deleteQuery(table string, keys []string, batch [][]string) (string, []interface{}) {
+	var (
+		terms = make([]string, len(batch))
+		args  = append(make([]interface{}, 0, len(a.whereArgs)+len(batch)*len(keys)), a.whereArgs...)
+		where string
+	)
+	if a.where != "" {
+		where = fmt.Sprintf("(%s) AND ", a.bind(a.where))
+	}
+	for i, values := range batch {
+		conditions := make([]string, len(keys))
+		for j := range keys {
+			args = append(args, values[j])
+			conditions[j] = a.dialect.Placeholder(len(args))
+			if len(keys) > 1 {
+				conditions[j] = fmt.Sprintf("%s = %s", a.dialect.Quote(keys[j]), conditions[j])
+			}
+		}
+		terms[i] = strings.Join(conditions, " AND ")
+	}
+
+	if len(keys) == 1 {
+		return fmt.Sprintf("DELETE FROM %s WHERE %s%s IN (%s);", a.dialect.Quote(table), where, a.dialect.Quote(keys[0]), strings.Join(terms, ", ")), args
+	}
+	if where != "" {
+		return fmt.Sprintf("DELETE FROM %s WHERE %s((%s));", a.dialect.Quote(table), where, strings.Join(terms, ") OR (")), args
+	}
+
+	return fmt.Sprintf("DELETE FROM %s WHERE (%s);", a.dialect.Quote(table), strings.Join(terms, ") OR (")), args
+}
+
+// spool records the key values of archived rows in a temporary file, so purges need not hold
+// every key in memory. Spools may be written by the concurrent downloads of a parallel archive.
+type spool struct {
+	mu      sync.Mutex
+	table   string
+	keys    []string
+	indexes []int
+	file    *os.File
+	w       *bufio.Writer
+	rows    int64
+}
+
+func newSpool(keys []string) (*spool, error) {
+	f, err := ioutil.TempFile("", "chiv-purge-")
+	if err != nil {
+		return nil, fmt.Errorf("creating key spool: %w", err)
+	}
+
+	return &spool{
+		keys: keys,
+		file: f,
+		w:    bufio.NewWriter(f),
+	}, nil
+}
+
+// resolve the indexes of the key columns in the archived columns.
+func (s *spool) resolve(columns []Column) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.indexes = make([]int, len(s.keys))
+	for i, key := range s.keys {
+		if s.indexes[i] = columnIndex(columns, key); s.indexes[i] < 0 {
+			return fmt.Errorf("purge key column '%s' not found", key)
+		}
+	}
+
+	return nil
+}
+
+func (s *spool) write(row []sql.RawBytes) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var buf [binary.MaxVarintLen64]byte
+	for i, j := range s.indexes {
+		if row[j] == nil {
+			return fmt.Errorf("null value in key column '%s'", s.keys[i])
+		}
+		n := binary.PutUvarint(buf[:], uint64(len(row[j])))
+		if _, err := s.w.Write(buf[:n]); err != nil {
+			return err
+		}
+		if _, err := s.w.Write(row[j]); err != nil {
+			return err
+		}
+	}
+	s.rows++
+
+	return nil
+}
+
+func (s *spool) reader() (*bufio.Reader, error) {
+	if err := s.w.Flush(); err != nil {
+		return nil, err
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	return bufio.NewReader(s.file), nil
+}
+
+// read the key values of the next spooled row, returning io.EOF after the last.
+func (s *spool) read(r *bufio.Reader) ([]string, error) {
+	values := make([]string, len(s.keys))
+	for i := range values {
+		n, err := binary.ReadUvarint(r)
+		if err == io.EOF && i == 0 {
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, unexpected(err)
+		}
+
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, unexpected(err)
+		}
+		values[i] = string(b)
+	}
+
+	return values, nil
+}
+
+func (s *spool) remove() {
+	_ = s.file.Close()
+	_ = os.Remove(s.file.Name())
+}
+
+func unexpected(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
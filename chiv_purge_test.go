@@ -0,0 +1,144 @@
+// +build unit
+
+package chiv_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gavincabbage.com/chiv"
+)
+
+func TestArchiveAndPurge(t *testing.T) {
+	cases := []struct {
+		name     string
+		keys     []string
+		affected int64
+		query    string
+		args     []interface{}
+	}{
+		{
+			name:     "single key",
+			keys:     []string{"id"},
+			affected: 2,
+			query:    `DELETE FROM "events" WHERE (id < ?) AND "id" IN (?, ?);`,
+			args:     []interface{}{int64(3), "1", "2"},
+		},
+		{
+			name:     "composite key",
+			keys:     []string{"id", "name"},
+			affected: 2,
+			query:    `DELETE FROM "events" WHERE (id < ?) AND (("id" = ? AND "name" = ?) OR ("id" = ? AND "name" = ?));`,
+			args:     []interface{}{int64(3), "1", "first", "2", "second"},
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			f := &fixture{
+				columns:  []string{"id", "name"},
+				rows:     [][]interface{}{{"1", "first"}, {"2", "second"}},
+				affected: test.affected,
+			}
+			db := newFixture(t, f)
+			defer db.Close()
+
+			var (
+				uploader = &uploader{}
+				subject  = chiv.NewArchiver(db, uploader, chiv.WithFormat(writing))
+			)
+
+			result, err := subject.ArchiveAndPurge("events", "bucket", test.keys, chiv.WithWhere("id < ?", 3))
+			require.NoError(t, err)
+			require.Equal(t, &chiv.PurgeResult{Archived: 2, Deleted: 2}, result)
+			require.Equal(t, "1,first\n2,second\n", uploader.uploads["events"])
+
+			require.Equal(t, []string{`SELECT * FROM "events" WHERE (id < ?);`, test.query}, f.queries)
+			require.Equal(t, []interface{}{int64(3)}, f.args[0])
+			require.Equal(t, test.args, f.args[1])
+			require.True(t, f.committed)
+		})
+	}
+}
+
+func TestArchiveAndPurgeParameters(t *testing.T) {
+	f := &fixture{
+		columns:  []string{"a", "b", "c", "d"},
+		affected: 1,
+	}
+	for i := 0; i < 300; i++ {
+		f.rows = append(f.rows, []interface{}{fmt.Sprint(i), "b", "c", "d"})
+	}
+	db := newFixture(t, f)
+	defer db.Close()
+
+	subject := chiv.NewArchiver(db, &uploader{}, chiv.WithFormat(writing))
+
+	_, err := subject.ArchiveAndPurge("events", "bucket", []string{"a", "b", "c", "d"})
+	require.NoError(t, err)
+
+	require.Len(t, f.queries, 3)
+	require.Len(t, f.args[1], 249*4)
+	require.Len(t, f.args[2], 51*4)
+	require.True(t, f.committed)
+}
+
+func TestArchiveAndPurgeErrors(t *testing.T) {
+	t.Run("upload failed", func(t *testing.T) {
+		f := &fixture{
+			columns: []string{"id"},
+			rows:    [][]interface{}{{"1"}},
+		}
+		db := newFixture(t, f)
+		defer db.Close()
+
+		subject := chiv.NewArchiver(db, &uploader{uploadErr: errors.New("uploading")}, chiv.WithFormat(writing))
+
+		_, err := subject.ArchiveAndPurge("events", "bucket", []string{"id"})
+		require.Error(t, err)
+		require.Len(t, f.queries, 1)
+		require.False(t, f.committed)
+	})
+
+	t.Run("missing key column", func(t *testing.T) {
+		f := &fixture{
+			columns: []string{"id"},
+			rows:    [][]interface{}{{"1"}},
+		}
+		db := newFixture(t, f)
+		defer db.Close()
+
+		subject := chiv.NewArchiver(db, &uploader{}, chiv.WithFormat(writing))
+
+		_, err := subject.ArchiveAndPurge("events", "bucket", []string{"uuid"})
+		require.EqualError(t, err, "chiv: downloading: purge key column 'uuid' not found")
+		require.Len(t, f.queries, 1)
+	})
+
+	t.Run("keys not unique", func(t *testing.T) {
+		f := &fixture{
+			columns:  []string{"id"},
+			rows:     [][]interface{}{{"1"}},
+			affected: 2,
+		}
+		db := newFixture(t, f)
+		defer db.Close()
+
+		subject := chiv.NewArchiver(db, &uploader{}, chiv.WithFormat(writing))
+
+		_, err := subject.ArchiveAndPurge("events", "bucket", []string{"id"})
+		require.EqualError(t, err, "chiv: purging 'events': deleted 2 rows but archived 1, rolled back: key columns are not unique")
+		require.False(t, f.committed)
+		require.True(t, f.rolledBack)
+	})
+
+	t.Run("no keys", func(t *testing.T) {
+		subject := chiv.NewArchiver(newFixture(t, &fixture{}), &uploader{})
+
+		_, err := subject.ArchiveAndPurge("events", "bucket", nil)
+		require.EqualError(t, err, "chiv: purging 'events': no key columns")
+	})
+}
@@ -0,0 +1,402 @@
+package chiv
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+const (
+	defaultRestoreBatchSize = 500
+	// maxRestoreParameters bounds the placeholders of each batched insert, within the limits of SQLite and SQL Server.
+	maxRestoreParameters = 999
+)
+
+// parsers maps object extensions to the parsers of the built-in formats.
+var parsers = map[string]ParserFunc{
+	"csv":    ParseCSV,
+	"yaml":   ParseYAML,
+	"yml":    ParseYAML,
+	"json":   ParseJSON,
+	"jsonl":  ParseNDJSON,
+	"ndjson": ParseNDJSON,
+}
+
+// codecs are the built-in codecs, detected by Restore from an object's content encoding or extension.
+var codecs = []Codec{Gzip, Zstd, Bzip2, LZ4, Snappy}
+
+// RestoreResult reports the rows restored by Restore.
+type RestoreResult struct {
+	// Rows is the number of rows inserted.
+	Rows int64
+	// Bytes is the number of bytes downloaded.
+	Bytes int64
+}
+
+// Restore downloads an archived object from S3 and inserts its rows into a database table, within a single
+// transaction. Rows are copied with COPY FROM STDIN into Postgres through lib/pq, and inserted in batches of
+// multi-row INSERT statements otherwise.
+//
+// Options describe how the object was archived. Its format is detected from its extension unless configured
+// with WithParser, and its compression from its content encoding or extension unless configured with
+// WithCompression. Encrypted objects require WithEncryption. Values matching WithNull are restored as null,
+// as are empty values of formats that don't represent null natively if WithNull is not configured.
+// With WithCreateTable, a table that does not exist is created from the object's schema sidecar.
+func Restore(ctx context.Context, db Database, downloader Downloader, bucket, key, table string, options ...Option) (*RestoreResult, error) {
+	return NewArchiver(db, nil, options...).restore(ctx, downloader, bucket, key, table)
+}
+
+func (a *Archiver) restore(ctx context.Context, downloader Downloader, bucket, key, table string) (result *RestoreResult, err error) {
+	beginner, ok := a.db.(Beginner)
+	if !ok {
+		return nil, errorf("restoring '%s': database does not support transactions", table)
+	}
+	if err := validateName(table); err != nil {
+		return nil, errorf("restoring '%s': %w", table, err)
+	}
+
+	var schema *Schema
+	if a.createTable {
+		if schema, err = readSchema(ctx, downloader, bucket, key+SchemaExtension); err != nil {
+			return nil, errorf("restoring '%s': reading schema sidecar: %w", table, err)
+		}
+	}
+
+	object, attributes, err := downloader.Download(ctx, bucket, key)
+	if err != nil {
+		return nil, errorf("downloading '%s': %w", key, err)
+	}
+	defer object.Close()
+
+	body := &readCounter{Reader: object}
+	r, name, err := a.decode(ctx, body, key, attributes)
+	if err != nil {
+		return nil, errorf("downloading '%s': %w", key, err)
+	}
+	parse := a.parserFor(path.Ext(name))
+	if parse == nil {
+		_ = r.Close()
+		return nil, errorf("downloading '%s': unknown format of '%s': configure a parser with WithParser", key, key)
+	}
+	defer func() {
+		if r != nil {
+			_ = r.Close()
+		}
+	}()
+
+	parser := parse(r)
+	defer func() {
+		if parser != nil {
+			_ = parser.Close()
+		}
+	}()
+	columns, err := parser.Open()
+	if err != nil {
+		return nil, errorf("restoring '%s': opening parser: %w", table, err)
+	}
+
+	copyIn := a.copyIn()
+	tx, err := beginner.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, errorf("restoring '%s': beginning transaction: %w", table, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	b := *a
+	b.db = tx
+	if columns, err = b.prepareTable(ctx, tx, table, columns, schema); err != nil {
+		return nil, errorf("restoring '%s': %w", table, err)
+	}
+
+	var rows int64
+	if len(columns) > 0 {
+		insert := b.insertBatches
+		if copyIn {
+			insert = b.copyRows
+		}
+		if rows, err = insert(ctx, tx, table, columns, b.values(parser)); err != nil {
+			return nil, errorf("restoring '%s': %w", table, err)
+		}
+	}
+
+	if err := parser.Close(); err != nil {
+		return nil, errorf("restoring '%s': closing parser: %w", table, err)
+	}
+	parser = nil
+
+	// Close the reader before counting the bytes downloaded, as decompressors may read concurrently.
+	if err := r.Close(); err != nil {
+		return nil, errorf("downloading '%s': closing reader: %w", key, err)
+	}
+	r = nil
+
+	if err := tx.Commit(); err != nil {
+		return nil, errorf("restoring '%s': committing transaction: %w", table, err)
+	}
+
+	return &RestoreResult{Rows: rows, Bytes: body.n}, nil
+}
+
+// readSchema downloads and decodes a schema sidecar.
+func readSchema(ctx context.Context, downloader Downloader, bucket, key string) (*Schema, error) {
+	body, _, err := downloader.Download(ctx, bucket, key)
+	if err != nil {
+		return nil, fmt.Errorf("downloading '%s': %w", key, err)
+	}
+	defer body.Close()
+
+	var schema Schema
+	if err := json.NewDecoder(body).Decode(&schema); err != nil {
+		return nil, fmt.Errorf("decoding '%s': %w", key, err)
+	}
+	if len(schema.Columns) == 0 {
+		return nil, fmt.Errorf("decoding '%s': no columns", key)
+	}
+
+	return &schema, nil
+}
+
+// decode returns a reader of the formatted data of an object, decrypting and decompressing it,
+// and the object's key without the extension of its compression codec.
+func (a *Archiver) decode(ctx context.Context, r io.Reader, key string, attributes *Attributes) (io.ReadCloser, string, error) {
+	if attributes == nil {
+		attributes = &Attributes{}
+	}
+	if encrypted(attributes.Metadata) {
+		if a.wrapper == nil {
+			return nil, "", errors.New("object is encrypted: restoring requires WithEncryption")
+		}
+		var err error
+		if r, err = DecryptWithContext(ctx, r, attributes.Metadata, a.wrapper); err != nil {
+			return nil, "", err
+		}
+	}
+
+	codec := a.codec
+	if codec == nil {
+		codec = detectCodec(key, attributes.ContentEncoding)
+	}
+
+	name := key
+	rc := ioutil.NopCloser(r)
+	if codec != nil {
+		decompressor, ok := codec.(Decompressor)
+		if !ok {
+			return nil, "", errors.New("compression codec does not support decompression")
+		}
+		var err error
+		if rc, err = decompressor.NewReader(r); err != nil {
+			return nil, "", fmt.Errorf("opening decompressor: %w", err)
+		}
+		name = strings.TrimSuffix(name, "."+codec.Extension())
+	}
+
+	return rc, name, nil
+}
+
+// parserFor returns the configured parser, or else the parser of the built-in format with the given
+// extension, if any.
+func (a *Archiver) parserFor(extension string) ParserFunc {
+	if a.parser != nil {
+		return a.parser
+	}
+
+	return parsers[strings.TrimPrefix(extension, ".")]
+}
+
+// encrypted reports whether object metadata describes an object encrypted using WithEncryption.
+func encrypted(metadata map[string]string) bool {
+	for k := range metadata {
+		if strings.EqualFold(strings.ReplaceAll(k, "_", "-"), MetadataEncryption) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// detectCodec returns the built-in codec of an object's content encoding or key extension, if any.
+func detectCodec(key, encoding string) Codec {
+	for _, codec := range codecs {
+		if encoding == codec.ContentEncoding() || strings.HasSuffix(key, "."+codec.Extension()) {
+			return codec
+		}
+	}
+
+	return nil
+}
+
+// copyIn reports whether rows can be copied into the database with COPY FROM STDIN.
+func (a *Archiver) copyIn() bool {
+	_, ok := a.dialect.(postgresDialect)
+	return ok && strings.Contains(driverPath(a.db), "lib/pq")
+}
+
+// prepareTable creates the table from its schema if given and the table does not exist, and validates
+// the restored columns against the database catalog, returning the catalog's names of the columns.
+func (a *Archiver) prepareTable(ctx context.Context, tx *sql.Tx, table string, columns []string, schema *Schema) ([]string, error) {
+	known, err := a.catalog(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+
+	if schema != nil && len(known) == 0 {
+		if _, err := tx.ExecContext(ctx, schema.createQuery(a.dialect, table)); err != nil {
+			return nil, fmt.Errorf("creating table: %w", err)
+		}
+		return columns, nil
+	}
+
+	if known == nil {
+		return columns, nil
+	}
+	if len(known) == 0 {
+		return nil, fmt.Errorf("table '%s' not found", table)
+	}
+
+	return resolveAll(known, table, columns)
+}
+
+// values returns a function returning the values of each parsed record, with null values restored.
+func (a *Archiver) values(parser Parser) func() ([]interface{}, error) {
+	null := a.null
+	if preserver, ok := parser.(NullPreserver); null == nil && !(ok && preserver.PreserveNull()) {
+		null = []byte{}
+	}
+
+	return func() ([]interface{}, error) {
+		record, err := parser.Parse()
+		if err == io.EOF {
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, fmt.Errorf("parsing record: %w", err)
+		}
+
+		values := make([]interface{}, len(record))
+		for i, v := range record {
+			if v != nil && (null == nil || string(v) != string(null)) {
+				values[i] = string(v)
+			}
+		}
+
+		return values, nil
+	}
+}
+
+// copyRows copies rows into a table with COPY FROM STDIN.
+func (a *Archiver) copyRows(ctx context.Context, tx *sql.Tx, table string, columns []string, next func() ([]interface{}, error)) (n int64, err error) {
+	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf("COPY %s (%s) FROM STDIN", a.dialect.Quote(table), a.quoteAll(columns)))
+	if err != nil {
+		return 0, fmt.Errorf("preparing copy: %w", err)
+	}
+	defer func() {
+		if e := stmt.Close(); e != nil && err == nil {
+			n, err = 0, fmt.Errorf("closing copy: %w", e)
+		}
+	}()
+
+	for {
+		values, err := next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+
+		if _, err := stmt.ExecContext(ctx, values...); err != nil {
+			return 0, fmt.Errorf("copying rows: %w", err)
+		}
+		n++
+	}
+
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return 0, fmt.Errorf("copying rows: %w", err)
+	}
+
+	return n, nil
+}
+
+// insertBatches inserts rows into a table in batches of multi-row INSERT statements.
+func (a *Archiver) insertBatches(ctx context.Context, tx *sql.Tx, table string, columns []string, next func() ([]interface{}, error)) (n int64, err error) {
+	size := defaultRestoreBatchSize
+	if len(columns) > 0 && maxRestoreParameters/len(columns) < size {
+		size = maxRestoreParameters / len(columns)
+	}
+	if size < 1 {
+		size = 1
+	}
+
+	var (
+		batch = make([]interface{}, 0, size*len(columns))
+		rows  int
+	)
+	flush := func() error {
+		if rows == 0 {
+			return nil
+		}
+		if _, err := tx.ExecContext(ctx, a.insertQuery(table, columns, rows), batch...); err != nil {
+			return fmt.Errorf("inserting rows: %w", err)
+		}
+		n += int64(rows)
+		batch, rows = batch[:0], 0
+		return nil
+	}
+
+	for {
+		values, err := next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+		if len(values) != len(columns) {
+			return 0, errors.New("record length does not match number of columns")
+		}
+
+		batch = append(batch, values...)
+		if rows++; rows == size {
+			if err := flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// insertQuery builds a statement inserting a batch of rows.
+func (a *Archiver) insertQuery(table string, columns []string, rows int) string {
+	terms := make([]string, rows)
+	for i := range terms {
+		placeholders := make([]string, len(columns))
+		for j := range columns {
+			placeholders[j] = a.dialect.Placeholder(i*len(columns) + j + 1)
+		}
+		terms[i] = strings.Join(placeholders, ", ")
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);", a.dialect.Quote(table), a.quoteAll(columns), strings.Join(terms, "), ("))
+}
+
+// quoteAll quotes and joins column names.
+func (a *Archiver) quoteAll(columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = a.dialect.Quote(column)
+	}
+
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,243 @@
+// +build unit
+
+package chiv_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gavincabbage.com/chiv"
+)
+
+func TestRestore(t *testing.T) {
+	db := newRestoreFixture(t)
+	defer db.Close()
+
+	wrapper := newKeyWrapper(t)
+	cases := []struct {
+		name      string
+		options   []chiv.Option
+		emptyNull bool
+	}{
+		{
+			name:      "csv",
+			emptyNull: true,
+		},
+		{
+			name:    "csv with null",
+			options: []chiv.Option{chiv.WithNull("NULL")},
+		},
+		{
+			name:    "json",
+			options: []chiv.Option{chiv.WithFormat(chiv.JSON)},
+		},
+		{
+			name:    "ndjson",
+			options: []chiv.Option{chiv.WithFormat(chiv.NDJSON)},
+		},
+		{
+			name:    "yaml",
+			options: []chiv.Option{chiv.WithFormat(chiv.YAML)},
+		},
+		{
+			name:      "gzip",
+			options:   []chiv.Option{chiv.WithCompression(chiv.Gzip, chiv.DefaultCompression)},
+			emptyNull: true,
+		},
+		{
+			name:    "zstd json",
+			options: []chiv.Option{chiv.WithFormat(chiv.JSON), chiv.WithCompression(chiv.Zstd, chiv.DefaultCompression)},
+		},
+		{
+			name:      "encrypted",
+			options:   []chiv.Option{chiv.WithEncryption(wrapper), chiv.WithCompression(chiv.Snappy, chiv.DefaultCompression)},
+			emptyNull: true,
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			key, store := archiveForRestore(t, db, test.options...)
+
+			_, err := db.Exec(`DROP TABLE IF EXISTS restored; CREATE TABLE restored (id INTEGER, name TEXT, score REAL);`)
+			require.NoError(t, err)
+
+			result, err := chiv.Restore(context.Background(), db, store, "bucket", key, "restored", test.options...)
+			require.NoError(t, err)
+			require.Equal(t, int64(4), result.Rows)
+			require.Equal(t, int64(len(store.objects[key])), result.Bytes)
+
+			expected := selectAll(t, db, "events")
+			if test.emptyNull {
+				expected[3][1] = sql.NullString{}
+			}
+			require.Equal(t, expected, selectAll(t, db, "restored"))
+		})
+	}
+}
+
+func TestRestoreBatches(t *testing.T) {
+	db := newRestoreFixture(t)
+	defer db.Close()
+
+	_, err := db.Exec(`
+		WITH RECURSIVE n(i) AS (SELECT 5 UNION ALL SELECT i + 1 FROM n WHERE i < 1000)
+		INSERT INTO events SELECT i, 'name', i / 2.0 FROM n;
+		CREATE TABLE restored (id INTEGER, name TEXT, score REAL);
+	`)
+	require.NoError(t, err)
+
+	key, store := archiveForRestore(t, db, chiv.WithNull("NULL"))
+	result, err := chiv.Restore(context.Background(), db, store, "bucket", key, "restored", chiv.WithNull("NULL"))
+	require.NoError(t, err)
+	require.Equal(t, int64(1000), result.Rows)
+	require.Equal(t, selectAll(t, db, "events"), selectAll(t, db, "restored"))
+}
+
+func TestRestoreCreateTable(t *testing.T) {
+	db := newRestoreFixture(t)
+	defer db.Close()
+
+	key, store := archiveForRestore(t, db)
+	store.objects[key+chiv.SchemaExtension] = []byte(`{
+		"table": "events",
+		"columns": [
+			{"name": "id", "database_type": "INTEGER", "nullable": false},
+			{"name": "name", "database_type": "TEXT"},
+			{"name": "score", "database_type": "REAL", "nullable": true}
+		]
+	}`)
+
+	result, err := chiv.Restore(context.Background(), db, store, "bucket", key, "created", chiv.WithCreateTable())
+	require.NoError(t, err)
+	require.Equal(t, int64(4), result.Rows)
+	expected := selectAll(t, db, "events")
+	expected[3][1] = sql.NullString{}
+	require.Equal(t, expected, selectAll(t, db, "created"))
+
+	var create string
+	require.NoError(t, db.QueryRow(`SELECT sql FROM sqlite_master WHERE name = 'created'`).Scan(&create))
+	require.Equal(t, `CREATE TABLE "created" ("id" INTEGER NOT NULL, "name" TEXT, "score" REAL)`, create)
+
+	result, err = chiv.Restore(context.Background(), db, store, "bucket", key, "created", chiv.WithCreateTable())
+	require.NoError(t, err)
+	require.Equal(t, int64(4), result.Rows)
+}
+
+func TestRestoreErrors(t *testing.T) {
+	db := newRestoreFixture(t)
+	defer db.Close()
+
+	key, store := archiveForRestore(t, db)
+	encryptedKey, encrypted := archiveForRestore(t, db, chiv.WithEncryption(newKeyWrapper(t)))
+	store.objects["events.txt"] = store.objects[key]
+	_, err := db.Exec(`CREATE TABLE other (id INTEGER)`)
+	require.NoError(t, err)
+
+	cases := []struct {
+		name     string
+		store    *objectStore
+		key      string
+		table    string
+		options  []chiv.Option
+		expected string
+	}{
+		{
+			name:     "missing object",
+			store:    store,
+			key:      "missing.csv",
+			table:    "events",
+			expected: "chiv: downloading 'missing.csv': object not found",
+		},
+		{
+			name:     "unknown format",
+			store:    store,
+			key:      "events.txt",
+			table:    "events",
+			expected: "chiv: downloading 'events.txt': unknown format of 'events.txt': configure a parser with WithParser",
+		},
+		{
+			name:     "encrypted",
+			store:    encrypted,
+			key:      encryptedKey,
+			table:    "events",
+			expected: "chiv: downloading 'events.csv': object is encrypted: restoring requires WithEncryption",
+		},
+		{
+			name:     "missing table",
+			store:    store,
+			key:      key,
+			table:    "missing",
+			expected: "chiv: restoring 'missing': table 'missing' not found",
+		},
+		{
+			name:     "unknown column",
+			store:    store,
+			key:      key,
+			table:    "other",
+			expected: "chiv: restoring 'other': column 'name' not found in table 'other'",
+		},
+		{
+			name:     "missing schema",
+			store:    store,
+			key:      key,
+			table:    "missing",
+			options:  []chiv.Option{chiv.WithCreateTable()},
+			expected: "chiv: restoring 'missing': reading schema sidecar: downloading 'events.csv.schema.json': object not found",
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := chiv.Restore(context.Background(), db, test.store, "bucket", test.key, test.table, test.options...)
+			require.EqualError(t, err, test.expected)
+		})
+	}
+}
+
+// newRestoreFixture opens a SQLite database with an events table to archive and restore.
+func newRestoreFixture(t *testing.T) *sql.DB {
+	db := newSQLite(t, `
+		CREATE TABLE events (id INTEGER, name TEXT, score REAL);
+		INSERT INTO events VALUES (1, 'first', 1.5), (2, NULL, 2), (3, 'third, with "quotes"', NULL), (4, '', -0.25);
+	`)
+
+	return db
+}
+
+// archiveForRestore archives the events table, returning its key and a store of the uploaded object.
+func archiveForRestore(t *testing.T, db *sql.DB, options ...chiv.Option) (string, *objectStore) {
+	uploader := &uploader{}
+	require.NoError(t, chiv.NewArchiver(db, uploader).Archive("events", "bucket", options...))
+
+	store := &objectStore{objects: make(map[string][]byte), metadata: uploader.uploadAttributes.Metadata}
+	for key, body := range uploader.uploads {
+		store.objects[key] = []byte(body)
+	}
+
+	return uploader.uploadKey, store
+}
+
+// selectAll returns the rows of a table ordered by id.
+func selectAll(t *testing.T, db *sql.DB, table string) [][]interface{} {
+	rows, err := db.Query(`SELECT id, name, score FROM ` + table + ` ORDER BY id`)
+	require.NoError(t, err)
+	defer rows.Close()
+
+	var all [][]interface{}
+	for rows.Next() {
+		var (
+			id    int64
+			name  sql.NullString
+			score sql.NullFloat64
+		)
+		require.NoError(t, rows.Scan(&id, &name, &score))
+		all = append(all, []interface{}{id, name, score})
+	}
+	require.NoError(t, rows.Err())
+
+	return all
+}
@@ -0,0 +1,128 @@
+package chiv
+
+import (
+	"sync"
+	"time"
+)
+
+// Result reports the rows archived and the objects uploaded by an archive.
+type Result struct {
+	// Rows is the number of rows archived.
+	Rows int64
+	// Bytes is the number of bytes formatted, before compression and encryption.
+	Bytes int64
+	// UploadedBytes is the number of bytes uploaded.
+	UploadedBytes int64
+	// Objects are the objects uploaded, in the order they were opened.
+	Objects []*ObjectResult
+	// Download is the time spent querying, scanning and formatting rows.
+	Download time.Duration
+	// Upload is the time from the first upload starting to the last completing.
+	// Uploads are streamed while rows are downloaded, so the phases overlap.
+	Upload time.Duration
+}
+
+// ObjectResult reports an object uploaded to S3.
+type ObjectResult struct {
+	Bucket        string
+	Key           string
+	Format        string
+	Location      string
+	VersionID     string
+	ETag          string
+	Rows          int64
+	Bytes         int64
+	UploadedBytes int64
+	Checksum      string
+}
+
+// results collects the Result of an archive from its concurrent downloads and uploads.
+type results struct {
+	rows       int64
+	bytes      int64
+	table      string
+	started    sync.Once
+	failed     sync.Once
+	mu         sync.Mutex
+	objects    []*ObjectResult
+	start      time.Time
+	downloadAt time.Time
+	uploadAt   time.Time
+	uploadedAt time.Time
+}
+
+func newResults(table string) *results {
+	return &results{table: table, start: time.Now()}
+}
+
+// add an object to the results.
+func (r *results) add(bucket, key, format string) *ObjectResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	o := &ObjectResult{Bucket: bucket, Key: key, Format: format}
+	r.objects = append(r.objects, o)
+	return o
+}
+
+// starting calls f once, when the first download of an archive starts.
+func (r *results) starting(f func()) {
+	r.started.Do(f)
+}
+
+// failing calls f once, when the first stage of an archive fails.
+func (r *results) failing(f func()) {
+	r.failed.Do(f)
+}
+
+// downloaded records a download completing.
+func (r *results) downloaded() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.downloadAt = time.Now()
+}
+
+// uploading records an upload starting.
+func (r *results) uploading() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.uploadAt.IsZero() {
+		r.uploadAt = time.Now()
+	}
+}
+
+// uploaded records an upload completing.
+func (r *results) uploaded(o *ObjectResult, info *ObjectInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if info != nil {
+		o.Location = info.Location
+		o.VersionID = info.VersionID
+		o.ETag = info.ETag
+	}
+	r.uploadedAt = time.Now()
+}
+
+// result totals the results of a completed archive.
+func (r *results) result() *Result {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := &Result{
+		Objects:  r.objects,
+		Download: r.downloadAt.Sub(r.start),
+	}
+	if !r.uploadAt.IsZero() {
+		result.Upload = r.uploadedAt.Sub(r.uploadAt)
+	}
+	for _, o := range r.objects {
+		result.Rows += o.Rows
+		result.Bytes += o.Bytes
+		result.UploadedBytes += o.UploadedBytes
+	}
+
+	return result
+}
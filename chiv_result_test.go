@@ -0,0 +1,101 @@
+// +build unit
+
+package chiv_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gavincabbage.com/chiv"
+)
+
+func TestArchiveRowsWithResult(t *testing.T) {
+	var (
+		rows = &rows{
+			columns: []string{"first_column", "second_column"},
+			scan:    [][]string{{"first", "1"}, {"second", "2"}, {"third", "3"}},
+		}
+		uploader = &uploader{}
+		subject  = chiv.NewArchiver(nil, uploader, chiv.WithFormat(writing))
+	)
+
+	result, err := subject.ArchiveRowsWithResult(rows, "bucket", chiv.WithMaxRowsPerObject(2), chiv.WithCompression(chiv.Gzip, chiv.DefaultCompression))
+	require.NoError(t, err)
+
+	require.Equal(t, int64(3), result.Rows)
+	require.Equal(t, int64(len("first,1\nsecond,2\nthird,3\n")), result.Bytes)
+	require.Equal(t, int64(len(uploader.uploads["table-00001.gz"])+len(uploader.uploads["table-00002.gz"])), result.UploadedBytes)
+	require.True(t, result.Download > 0)
+	require.True(t, result.Upload > 0)
+
+	require.Len(t, result.Objects, 2)
+	checksum := sha256.Sum256([]byte(uploader.uploads["table-00001.gz"]))
+	require.Equal(t, &chiv.ObjectResult{
+		Bucket:        "bucket",
+		Key:           "table-00001.gz",
+		Location:      "https://bucket.s3.amazonaws.com/table-00001.gz",
+		VersionID:     "version",
+		ETag:          `"etag"`,
+		Rows:          2,
+		Bytes:         int64(len("first,1\nsecond,2\n")),
+		UploadedBytes: int64(len(uploader.uploads["table-00001.gz"])),
+		Checksum:      hex.EncodeToString(checksum[:]),
+	}, result.Objects[0])
+	require.Equal(t, "table-00002.gz", result.Objects[1].Key)
+	require.Equal(t, int64(1), result.Objects[1].Rows)
+}
+
+func TestArchiveWithResult(t *testing.T) {
+	db := newSQLite(t, `
+		CREATE TABLE events (id INTEGER, name TEXT);
+		INSERT INTO events VALUES (1, 'first'), (2, 'second'), (3, 'third'), (4, 'fourth');
+	`)
+
+	cases := []struct {
+		name    string
+		options []chiv.Option
+		objects int
+	}{
+		{
+			name:    "single query",
+			objects: 1,
+		},
+		{
+			name:    "parallel parts",
+			options: []chiv.Option{chiv.WithParallelism(2, "id"), chiv.WithParallelParts()},
+			objects: 2,
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				uploader = &uploader{}
+				subject  = chiv.NewArchiver(db, uploader, chiv.WithFormat(writing))
+			)
+
+			result, err := subject.ArchiveWithResult("events", "bucket", test.options...)
+			require.NoError(t, err)
+
+			require.Equal(t, int64(4), result.Rows)
+			require.Equal(t, int64(len("1,first\n2,second\n3,third\n4,fourth\n")), result.Bytes)
+			require.Equal(t, result.Bytes, result.UploadedBytes)
+			require.Len(t, result.Objects, test.objects)
+			for _, o := range result.Objects {
+				require.Equal(t, int64(len(uploader.uploads[o.Key])), o.Bytes)
+				require.Equal(t, `"etag"`, o.ETag)
+			}
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		subject := chiv.NewArchiver(db, &uploader{})
+
+		result, err := subject.ArchiveWithResult("missing", "bucket")
+		require.EqualError(t, err, "chiv: querying 'missing': table 'missing' not found")
+		require.Nil(t, result)
+	})
+}
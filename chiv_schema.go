@@ -0,0 +1,255 @@
+package chiv
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"runtime/debug"
+	"strings"
+)
+
+// SchemaExtension is appended to the key of an archived object to form the key of its schema sidecar.
+const SchemaExtension = ".schema.json"
+
+// Schema describes the columns of an archived object, as stored in a schema sidecar.
+type Schema struct {
+	Table   string         `json:"table"`
+	Dialect string         `json:"dialect,omitempty"`
+	Rows    int64          `json:"rows"`
+	Version string         `json:"chiv_version,omitempty"`
+	Columns []SchemaColumn `json:"columns"`
+}
+
+// SchemaColumn describes an archived column. DatabaseType is the type name reported by the database driver,
+// and Type is that name normalized by the dialect, e.g. BOOLEAN for SQL Server's BIT. Properties reported by
+// the driver are included only if known, e.g. Nullable is nil if the nullability of the column is unknown.
+type SchemaColumn struct {
+	Name         string `json:"name"`
+	DatabaseType string `json:"database_type"`
+	Type         string `json:"type,omitempty"`
+	ScanType     string `json:"scan_type,omitempty"`
+	Nullable     *bool  `json:"nullable,omitempty"`
+	Length       *int64 `json:"length,omitempty"`
+	Precision    *int64 `json:"precision,omitempty"`
+	Scale        *int64 `json:"scale,omitempty"`
+}
+
+// newSchema describes the columns of an object archived from a table.
+func (a *Archiver) newSchema(table string, columns []Column, rows int64) *Schema {
+	schema := &Schema{
+		Table:   table,
+		Dialect: dialectName(a.dialect),
+		Rows:    rows,
+		Version: version(),
+		Columns: make([]SchemaColumn, len(columns)),
+	}
+
+	for i, column := range columns {
+		c := SchemaColumn{
+			Name:         column.Name(),
+			DatabaseType: column.DatabaseTypeName(),
+			Type:         column.DatabaseTypeName(),
+		}
+		if d, ok := column.(dialectColumn); ok {
+			c.DatabaseType = d.Column.DatabaseTypeName()
+		}
+		if t := column.ScanType(); t != nil {
+			c.ScanType = t.String()
+		}
+		if n, ok := column.(interface{ Nullable() (bool, bool) }); ok {
+			if nullable, ok := n.Nullable(); ok {
+				c.Nullable = &nullable
+			}
+		}
+		if l, ok := column.(interface{ Length() (int64, bool) }); ok {
+			if length, ok := l.Length(); ok {
+				c.Length = &length
+			}
+		}
+		if d, ok := column.(interface{ DecimalSize() (int64, int64, bool) }); ok {
+			if precision, scale, ok := d.DecimalSize(); ok {
+				c.Precision, c.Scale = &precision, &scale
+			}
+		}
+		schema.Columns[i] = c
+	}
+
+	return schema
+}
+
+// uploadSchema uploads the schema sidecar of an uploaded object.
+func (a *Archiver) uploadSchema(ctx context.Context, table string, columns []Column, object *ObjectResult) (err error) {
+	defer func() {
+		if err != nil {
+			a.fail(ctx, a.results.table, StageUpload, err)
+		}
+	}()
+
+	b, err := json.MarshalIndent(a.newSchema(table, columns, object.Rows), "", "  ")
+	if err != nil {
+		return errorf("uploading schema sidecar: %w", err)
+	}
+
+	attributes := &Attributes{ContentType: "application/json"}
+	if _, _, err := a.write(ctx, object.Bucket, object.Key+SchemaExtension, attributes, bytes.NewReader(b)); err != nil {
+		return errorf("uploading schema sidecar: %w", err)
+	}
+
+	return nil
+}
+
+// createTypes map normalized type names to the names each dialect creates columns with, where they differ.
+var createTypes = map[string]map[string]string{
+	"postgres": {
+		"TINYINT":  "SMALLINT",
+		"FLOAT":    "REAL",
+		"DOUBLE":   "DOUBLE PRECISION",
+		"BLOB":     "BYTEA",
+		"DATETIME": "TIMESTAMP",
+	},
+	"mysql": {
+		"TIMESTAMPTZ": "TIMESTAMP",
+		"UUID":        "CHAR(36)",
+		"JSONB":       "JSON",
+	},
+	"sqlserver": {
+		"BOOLEAN":     "BIT",
+		"FLOAT":       "REAL",
+		"DOUBLE":      "FLOAT",
+		"CHAR":        "NCHAR",
+		"VARCHAR":     "NVARCHAR",
+		"TEXT":        "NVARCHAR(MAX)",
+		"BLOB":        "VARBINARY(MAX)",
+		"TIMESTAMP":   "DATETIME2",
+		"TIMESTAMPTZ": "DATETIMEOFFSET",
+		"UUID":        "UNIQUEIDENTIFIER",
+	},
+	"clickhouse": {
+		"BOOLEAN":  "Bool",
+		"TINYINT":  "Int8",
+		"SMALLINT": "Int16",
+		"INTEGER":  "Int32",
+		"BIGINT":   "Int64",
+		"FLOAT":    "Float32",
+		"DOUBLE":   "Float64",
+		"CHAR":     "String",
+		"VARCHAR":  "String",
+		"TEXT":     "String",
+		"BLOB":     "String",
+	},
+}
+
+// unsizedTypes map the type names each dialect creates columns with to the unbounded types used instead if the
+// length of a column is unknown, where the dialect would otherwise reject the type or give it a length of one.
+var unsizedTypes = map[string]map[string]string{
+	"postgres": {
+		"CHAR":   "TEXT",
+		"BPCHAR": "TEXT",
+	},
+	"mysql": {
+		"CHAR":      "TEXT",
+		"VARCHAR":   "TEXT",
+		"BINARY":    "BLOB",
+		"VARBINARY": "BLOB",
+	},
+	"sqlserver": {
+		"CHAR":      "VARCHAR(MAX)",
+		"VARCHAR":   "VARCHAR(MAX)",
+		"NCHAR":     "NVARCHAR(MAX)",
+		"NVARCHAR":  "NVARCHAR(MAX)",
+		"BINARY":    "VARBINARY(MAX)",
+		"VARBINARY": "VARBINARY(MAX)",
+	},
+	"ansi": {
+		"CHAR":    "TEXT",
+		"VARCHAR": "TEXT",
+	},
+}
+
+// createOptions are appended to the statements creating tables in dialects requiring them, e.g. ClickHouse's
+// table engine.
+var createOptions = map[string]string{
+	"clickhouse": " ENGINE = MergeTree ORDER BY tuple()",
+}
+
+// createQuery builds a statement creating a table with the schema's columns. Columns are created with the
+// driver's type names if the schema was archived from the same dialect, and otherwise with their normalized
+// type names mapped to the dialect's. Lengths, precisions and scales are added to types without them, and
+// character and binary columns of unknown length are created with the dialect's unbounded types, e.g. TEXT
+// in MySQL and NVARCHAR(MAX) in SQL Server. Columns of unknown type are created as TEXT.
+func (s *Schema) createQuery(d Dialect, table string) string {
+	definitions := make([]string, len(s.Columns))
+	for i, column := range s.Columns {
+		t := column.DatabaseType
+		if column.Type == "" || s.Dialect != dialectName(d) {
+			normalized := column.Type
+			if normalized == "" {
+				normalized = column.DatabaseType
+			}
+			t = normalize(normalized, createTypes[dialectName(d)])
+		}
+
+		switch {
+		case t == "":
+			t = "TEXT"
+		case strings.Contains(t, "("):
+		case column.Precision != nil && *column.Precision > 0 && column.Scale != nil:
+			t = fmt.Sprintf("%s(%d,%d)", t, *column.Precision, *column.Scale)
+		case column.Length != nil && *column.Length > 0 && *column.Length < math.MaxInt32:
+			t = fmt.Sprintf("%s(%d)", t, *column.Length)
+		default:
+			if unsized, ok := unsizedTypes[dialectName(d)][strings.ToUpper(t)]; ok {
+				t = unsized
+			}
+		}
+
+		definitions[i] = fmt.Sprintf("%s %s", d.Quote(column.Name), t)
+		if column.Nullable != nil && !*column.Nullable {
+			definitions[i] += " NOT NULL"
+		}
+	}
+
+	return fmt.Sprintf("CREATE TABLE %s (%s)%s;", d.Quote(table), strings.Join(definitions, ", "), createOptions[dialectName(d)])
+}
+
+// dialectName returns the name of a dialect recorded in schema sidecars.
+func dialectName(d Dialect) string {
+	switch d.(type) {
+	case postgresDialect:
+		return "postgres"
+	case mysqlDialect:
+		return "mysql"
+	case sqliteDialect:
+		return "sqlite"
+	case sqlServerDialect:
+		return "sqlserver"
+	case clickHouseDialect:
+		return "clickhouse"
+	case ansiDialect:
+		return "ansi"
+	}
+
+	return fmt.Sprintf("%T", d)
+}
+
+// version returns the version of the chiv module in the running binary, if known.
+func version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	const path = "gavincabbage.com/chiv"
+	if info.Main.Path == path {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == path {
+			return dep.Version
+		}
+	}
+
+	return ""
+}
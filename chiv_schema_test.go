@@ -0,0 +1,194 @@
+// +build unit
+
+package chiv_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gavincabbage.com/chiv"
+)
+
+func TestSchemaSidecar(t *testing.T) {
+	db := newRestoreFixture(t)
+	defer db.Close()
+
+	uploader := &uploader{}
+	require.NoError(t, chiv.NewArchiver(db, uploader).Archive("events", "bucket", chiv.WithSchemaSidecar()))
+	require.Len(t, uploader.uploads, 2)
+
+	var (
+		schema   chiv.Schema
+		nullable = true
+		length   = int64(9223372036854775807)
+	)
+	require.NoError(t, json.Unmarshal([]byte(uploader.uploads["events.csv"+chiv.SchemaExtension]), &schema))
+	require.Equal(t, chiv.Schema{
+		Table:   "events",
+		Dialect: "sqlite",
+		Rows:    4,
+		Version: "(devel)",
+		Columns: []chiv.SchemaColumn{
+			{Name: "id", DatabaseType: "INTEGER", Type: "INTEGER", ScanType: "int64", Nullable: &nullable},
+			{Name: "name", DatabaseType: "TEXT", Type: "TEXT", ScanType: "string", Nullable: &nullable, Length: &length},
+			{Name: "score", DatabaseType: "REAL", Type: "DOUBLE", ScanType: "float64", Nullable: &nullable},
+		},
+	}, schema)
+
+	store := &objectStore{objects: make(map[string][]byte)}
+	for key, body := range uploader.uploads {
+		store.objects[key] = []byte(body)
+	}
+	result, err := chiv.Restore(context.Background(), db, store, "bucket", "events.csv", "created", chiv.WithCreateTable())
+	require.NoError(t, err)
+	require.Equal(t, int64(4), result.Rows)
+
+	var create string
+	require.NoError(t, db.QueryRow(`SELECT sql FROM sqlite_master WHERE name = 'created'`).Scan(&create))
+	require.Equal(t, `CREATE TABLE "created" ("id" INTEGER, "name" TEXT, "score" REAL)`, create)
+}
+
+func TestSchemaSidecarDialects(t *testing.T) {
+	cases := []struct {
+		name     string
+		dialect  chiv.Dialect
+		sidecar  string
+		expected string
+	}{
+		{
+			name:     "postgres from sqlite",
+			dialect:  chiv.Postgres,
+			sidecar:  `{"dialect": "sqlite", "columns": [{"name": "score", "database_type": "REAL", "type": "DOUBLE"}, {"name": "paid", "database_type": "BOOLEAN", "type": "BOOLEAN"}]}`,
+			expected: `CREATE TABLE "created" ("score" DOUBLE PRECISION, "paid" BOOLEAN);`,
+		},
+		{
+			name:     "postgres from postgres",
+			dialect:  chiv.Postgres,
+			sidecar:  `{"dialect": "postgres", "columns": [{"name": "score", "database_type": "FLOAT8", "type": "DOUBLE"}, {"name": "paid", "database_type": "BOOL", "type": "BOOLEAN"}]}`,
+			expected: `CREATE TABLE "created" ("score" FLOAT8, "paid" BOOL);`,
+		},
+		{
+			name:     "sql server from postgres",
+			dialect:  chiv.SQLServer,
+			sidecar:  `{"dialect": "postgres", "columns": [{"name": "score", "database_type": "FLOAT8", "type": "DOUBLE"}, {"name": "paid", "database_type": "BOOL", "type": "BOOLEAN"}]}`,
+			expected: `CREATE TABLE [created] ([score] FLOAT, [paid] BIT);`,
+		},
+		{
+			name:     "normalized types only",
+			dialect:  chiv.Postgres,
+			sidecar:  `{"dialect": "postgres", "columns": [{"name": "score", "database_type": "DOUBLE"}, {"name": "paid", "database_type": "BOOLEAN"}]}`,
+			expected: `CREATE TABLE "created" ("score" DOUBLE PRECISION, "paid" BOOLEAN);`,
+		},
+		{
+			name:     "postgres without lengths",
+			dialect:  chiv.Postgres,
+			sidecar:  `{"dialect": "mysql", "columns": [{"name": "code", "database_type": "CHAR", "type": "CHAR"}, {"name": "name", "database_type": "VARCHAR", "type": "VARCHAR"}]}`,
+			expected: `CREATE TABLE "created" ("code" TEXT, "name" VARCHAR);`,
+		},
+		{
+			name:     "mysql without lengths",
+			dialect:  chiv.MySQL,
+			sidecar:  `{"dialect": "postgres", "columns": [{"name": "code", "database_type": "BPCHAR", "type": "CHAR"}, {"name": "name", "database_type": "VARCHAR", "type": "VARCHAR"}]}`,
+			expected: "CREATE TABLE `created` (`code` TEXT, `name` TEXT);",
+		},
+		{
+			name:     "mysql from mysql without lengths",
+			dialect:  chiv.MySQL,
+			sidecar:  `{"dialect": "mysql", "columns": [{"name": "name", "database_type": "VARCHAR", "type": "VARCHAR"}, {"name": "hash", "database_type": "VARBINARY", "type": "VARBINARY"}]}`,
+			expected: "CREATE TABLE `created` (`name` TEXT, `hash` BLOB);",
+		},
+		{
+			name:     "mysql with lengths",
+			dialect:  chiv.MySQL,
+			sidecar:  `{"dialect": "postgres", "columns": [{"name": "code", "database_type": "BPCHAR", "type": "CHAR", "length": 2}, {"name": "name", "database_type": "VARCHAR", "type": "VARCHAR", "length": 20}]}`,
+			expected: "CREATE TABLE `created` (`code` CHAR(2), `name` VARCHAR(20));",
+		},
+		{
+			name:     "sql server without lengths",
+			dialect:  chiv.SQLServer,
+			sidecar:  `{"dialect": "mysql", "columns": [{"name": "code", "database_type": "CHAR", "type": "CHAR"}, {"name": "name", "database_type": "VARCHAR", "type": "VARCHAR"}]}`,
+			expected: `CREATE TABLE [created] ([code] NVARCHAR(MAX), [name] NVARCHAR(MAX));`,
+		},
+		{
+			name:     "sql server with lengths",
+			dialect:  chiv.SQLServer,
+			sidecar:  `{"dialect": "mysql", "columns": [{"name": "name", "database_type": "VARCHAR", "type": "VARCHAR", "length": 20}]}`,
+			expected: `CREATE TABLE [created] ([name] NVARCHAR(20));`,
+		},
+		{
+			name:     "clickhouse without lengths",
+			dialect:  chiv.ClickHouse,
+			sidecar:  `{"dialect": "mysql", "columns": [{"name": "id", "database_type": "BIGINT", "type": "BIGINT"}, {"name": "name", "database_type": "VARCHAR", "type": "VARCHAR"}]}`,
+			expected: "CREATE TABLE `created` (`id` Int64, `name` String) ENGINE = MergeTree ORDER BY tuple();",
+		},
+		{
+			name:     "ansi without lengths",
+			sidecar:  `{"dialect": "mysql", "columns": [{"name": "name", "database_type": "VARCHAR", "type": "VARCHAR"}]}`,
+			expected: `CREATE TABLE "created" ("name" TEXT);`,
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			f := &fixture{}
+			db := newFixture(t, f)
+			defer db.Close()
+
+			store := &objectStore{objects: map[string][]byte{
+				"events.csv":                        []byte("score,paid\n1.5,true\n"),
+				"events.csv" + chiv.SchemaExtension: []byte(test.sidecar),
+			}}
+			options := []chiv.Option{chiv.WithCreateTable()}
+			if test.dialect != nil {
+				options = append(options, chiv.WithDialect(test.dialect))
+			}
+			_, err := chiv.Restore(context.Background(), db, store, "bucket", "events.csv", "created", options...)
+			require.NoError(t, err)
+			require.Contains(t, f.queries, test.expected)
+		})
+	}
+}
+
+func TestSchemaSidecarPartitions(t *testing.T) {
+	db := newRestoreFixture(t)
+	defer db.Close()
+
+	uploader := &uploader{}
+	require.NoError(t, chiv.NewArchiver(db, uploader).Archive("events", "bucket", chiv.WithSchemaSidecar(), chiv.WithMaxRowsPerObject(3)))
+	require.Len(t, uploader.uploads, 4)
+
+	for key, rows := range map[string]int64{"events-00001.csv": 3, "events-00002.csv": 1} {
+		var schema chiv.Schema
+		require.NoError(t, json.Unmarshal([]byte(uploader.uploads[key+chiv.SchemaExtension]), &schema))
+		require.Equal(t, rows, schema.Rows)
+		require.Len(t, schema.Columns, 3)
+	}
+}
+
+func TestSchemaSidecarError(t *testing.T) {
+	db := newRestoreFixture(t)
+	defer db.Close()
+
+	uploader := &sidecarUploader{}
+	err := chiv.NewArchiver(db, uploader).Archive("events", "bucket", chiv.WithSchemaSidecar())
+	require.EqualError(t, err, "chiv: uploading schema sidecar: failed")
+}
+
+// sidecarUploader fails uploads of schema sidecars.
+type sidecarUploader struct {
+	uploader
+}
+
+func (u *sidecarUploader) Upload(ctx context.Context, bucket, key string, attributes *chiv.Attributes, r io.Reader) (*chiv.ObjectInfo, error) {
+	if strings.HasSuffix(key, chiv.SchemaExtension) {
+		return nil, errors.New("failed")
+	}
+
+	return u.uploader.Upload(ctx, bucket, key, attributes, r)
+}
@@ -0,0 +1,205 @@
+package chiv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Sink writes archived objects to a storage backend, e.g. S3, the local filesystem or another object store.
+// Sinks are bound to their destination, so the bucket given to Archive is only reported in results.
+type Sink interface {
+	// Open an object for writing. The object is written once the writer is closed, unless the context is
+	// canceled first, in which case the object must be discarded.
+	Open(ctx context.Context, key string) (io.WriteCloser, error)
+}
+
+// AttributeSink is a Sink that stores attributes with the objects it writes. Objects encrypted using
+// WithEncryption can only be written to an AttributeSink, which stores their encryption metadata.
+type AttributeSink interface {
+	Sink
+	// OpenWithAttributes opens an object for writing, like Open, with the given attributes.
+	OpenWithAttributes(ctx context.Context, key string, attributes *Attributes) (io.WriteCloser, error)
+}
+
+// Attributes of an object written to a Sink. Empty attributes are unset.
+type Attributes struct {
+	ContentType     string
+	ContentEncoding string
+	Metadata        map[string]string
+}
+
+// ObjectInfo describes an object written to a Sink, as reported in its ObjectResult.
+type ObjectInfo struct {
+	Location  string
+	VersionID string
+	ETag      string
+}
+
+// InfoWriter is a writer opened by a Sink that describes its object once closed.
+type InfoWriter interface {
+	io.WriteCloser
+	Info() ObjectInfo
+}
+
+// UploadFunc uploads an object read from r until EOF, e.g. with an SDK's streaming upload, and describes it.
+type UploadFunc func(ctx context.Context, key string, attributes *Attributes, r io.Reader) (*ObjectInfo, error)
+
+type uploadSink struct {
+	upload UploadFunc
+}
+
+// NewUploadSink returns an AttributeSink uploading each object with an UploadFunc as it is written.
+func NewUploadSink(upload UploadFunc) Sink {
+	return &uploadSink{upload: upload}
+}
+
+// Open an object, starting its upload.
+func (s *uploadSink) Open(ctx context.Context, key string) (io.WriteCloser, error) {
+	return s.OpenWithAttributes(ctx, key, &Attributes{})
+}
+
+// OpenWithAttributes opens an object, starting its upload with the given attributes.
+func (s *uploadSink) OpenWithAttributes(ctx context.Context, key string, attributes *Attributes) (io.WriteCloser, error) {
+	r, w := io.Pipe()
+	u := &uploadWriter{PipeWriter: w, done: make(chan struct{})}
+	go func() {
+		defer close(u.done)
+		u.info, u.err = s.upload(ctx, key, attributes, r)
+		if u.err == nil {
+			u.err = ctx.Err()
+		}
+		_ = r.CloseWithError(u.err)
+	}()
+
+	return u, nil
+}
+
+// uploadWriter writes an object to its upload through a pipe.
+type uploadWriter struct {
+	*io.PipeWriter
+	done chan struct{}
+	info *ObjectInfo
+	err  error
+}
+
+// Close the object, waiting for its upload to complete.
+func (w *uploadWriter) Close() error {
+	_ = w.PipeWriter.Close()
+	<-w.done
+
+	return w.err
+}
+
+// Info describes the uploaded object.
+func (w *uploadWriter) Info() ObjectInfo {
+	if w.info == nil {
+		return ObjectInfo{}
+	}
+
+	return *w.info
+}
+
+// NewUploaderSink returns an AttributeSink uploading objects to an S3 bucket.
+// Archives are written to a bucket through an uploader sink by default.
+func NewUploaderSink(uploader Uploader, bucket string) Sink {
+	return NewUploadSink(func(ctx context.Context, key string, attributes *Attributes, r io.Reader) (*ObjectInfo, error) {
+		return uploader.Upload(ctx, bucket, key, attributes, r)
+	})
+}
+
+type fileSink struct {
+	dir string
+}
+
+// NewFileSink returns a Sink writing objects to files under a directory, at paths given by their keys.
+// Each object is written to a temporary file in its directory, which is created if needed, and renamed
+// into place once complete.
+func NewFileSink(dir string) Sink {
+	return &fileSink{dir: dir}
+}
+
+// Open a temporary file for an object.
+func (s *fileSink) Open(ctx context.Context, key string) (io.WriteCloser, error) {
+	name, err := filePath(s.dir, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Chmod(0644); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return nil, err
+	}
+
+	return &fileWriter{File: f, ctx: ctx, name: name}, nil
+}
+
+// fileWriter writes an object to a temporary file.
+type fileWriter struct {
+	*os.File
+	ctx  context.Context
+	name string
+}
+
+// Close the temporary file, renaming it into place unless the object was canceled.
+func (w *fileWriter) Close() error {
+	err := w.File.Close()
+	if err == nil {
+		err = w.ctx.Err()
+	}
+	if err == nil {
+		err = os.Rename(w.File.Name(), w.name)
+	}
+	if err != nil {
+		_ = os.Remove(w.File.Name())
+	}
+
+	return err
+}
+
+// Info describes the written file.
+func (w *fileWriter) Info() ObjectInfo {
+	name, err := filepath.Abs(w.name)
+	if err != nil {
+		name = w.name
+	}
+
+	return ObjectInfo{Location: (&url.URL{Scheme: "file", Path: filepath.ToSlash(name)}).String()}
+}
+
+// filePath joins a directory and an object key, failing if the key would escape the directory.
+func filePath(dir, key string) (string, error) {
+	name := filepath.Join(dir, filepath.FromSlash(key))
+	rel, err := filepath.Rel(dir, name)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid key '%s'", key)
+	}
+
+	return name, nil
+}
+
+// openObject opens an object in a sink, with attributes if the sink stores them.
+func openObject(ctx context.Context, sink Sink, key string, attributes *Attributes) (io.WriteCloser, error) {
+	if s, ok := sink.(AttributeSink); ok {
+		return s.OpenWithAttributes(ctx, key, attributes)
+	}
+	if len(attributes.Metadata) > 0 {
+		return nil, errors.New("sink does not store object metadata")
+	}
+
+	return sink.Open(ctx, key)
+}
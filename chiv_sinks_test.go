@@ -0,0 +1,116 @@
+// +build unit
+
+package chiv_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gavincabbage.com/chiv"
+)
+
+func TestFileSink(t *testing.T) {
+	db := newRestoreFixture(t)
+	defer db.Close()
+
+	dir, err := ioutil.TempDir("", "chiv")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	subject := chiv.NewArchiver(db, nil, chiv.WithSink(chiv.NewFileSink(dir)))
+	result, err := subject.ArchiveWithResult("events", "", chiv.WithKeyTemplate("{{.Table}}/{{.Part}}.csv"), chiv.WithMaxRowsPerObject(3), chiv.WithSchemaSidecar())
+	require.NoError(t, err)
+
+	require.Len(t, result.Objects, 2)
+	require.Equal(t, "events/1.csv", result.Objects[0].Key)
+	require.Equal(t, "file://"+filepath.ToSlash(filepath.Join(dir, "events", "1.csv")), result.Objects[0].Location)
+
+	files, err := filepath.Glob(filepath.Join(dir, "events", "*"))
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.Join(dir, "events", "1.csv"),
+		filepath.Join(dir, "events", "1.csv.schema.json"),
+		filepath.Join(dir, "events", "2.csv"),
+		filepath.Join(dir, "events", "2.csv.schema.json"),
+	}, files)
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "events", "2.csv"))
+	require.NoError(t, err)
+	require.Equal(t, "id,name,score\n4,,-0.25\n", string(b))
+	require.Equal(t, int64(len(b)), result.Objects[1].UploadedBytes)
+
+	temps, err := filepath.Glob(filepath.Join(dir, "events", ".*"))
+	require.NoError(t, err)
+	require.Empty(t, temps)
+}
+
+func TestFileSinkErrors(t *testing.T) {
+	db := newRestoreFixture(t)
+	defer db.Close()
+
+	dir, err := ioutil.TempDir("", "chiv")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	subject := chiv.NewArchiver(db, nil, chiv.WithSink(chiv.NewFileSink(dir)))
+
+	err = subject.Archive("events", "", chiv.WithFormat(failing))
+	require.EqualError(t, err, "chiv: downloading: formatting row: formatting")
+
+	err = subject.Archive("events", "", chiv.WithKey("../events.csv"))
+	require.EqualError(t, err, "chiv: uploading: invalid key '../events.csv'")
+
+	err = subject.Archive("events", "", chiv.WithEncryption(newKeyWrapper(t)))
+	require.EqualError(t, err, "chiv: uploading: sink does not store object metadata")
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestUploadSink(t *testing.T) {
+	var (
+		attributes *chiv.Attributes
+		uploaded   []byte
+		sink       = chiv.NewUploadSink(func(ctx context.Context, key string, a *chiv.Attributes, r io.Reader) (*chiv.ObjectInfo, error) {
+			attributes = a
+			b, err := ioutil.ReadAll(r)
+			if err != nil {
+				return nil, err
+			}
+			if key == "failing" {
+				return nil, errors.New("uploading")
+			}
+			uploaded = b
+			return &chiv.ObjectInfo{Location: "location/" + key, ETag: "etag"}, nil
+		})
+		scanned = &rows{
+			columns: []string{"first_column", "second_column"},
+			scan:    [][]string{{"first", "1"}, {"second", "2"}},
+		}
+	)
+
+	subject := chiv.NewArchiver(nil, nil, chiv.WithSink(sink), chiv.WithFormat(writing), chiv.WithCompression(chiv.Gzip, chiv.DefaultCompression))
+	result, err := subject.ArchiveRowsWithResult(scanned, "bucket")
+	require.NoError(t, err)
+	require.Equal(t, &chiv.Attributes{ContentEncoding: "gzip"}, attributes)
+	require.Equal(t, int64(len(uploaded)), result.UploadedBytes)
+	require.Equal(t, "bucket", result.Objects[0].Bucket)
+	require.Equal(t, "location/table.gz", result.Objects[0].Location)
+	require.Equal(t, "etag", result.Objects[0].ETag)
+
+	scanned = &rows{columns: []string{"first_column"}, scan: [][]string{{"first"}}}
+	subject = chiv.NewArchiver(nil, nil, chiv.WithSink(sink), chiv.WithFormat(writing), chiv.WithKey("failing"))
+	require.EqualError(t, subject.ArchiveRows(scanned, "bucket"), "chiv: uploading: uploading")
+
+	scanned = &rows{columns: []string{"first_column"}, scan: [][]string{{"first"}}}
+	subject = chiv.NewArchiver(nil, nil, chiv.WithFormat(writing))
+	require.EqualError(t, subject.ArchiveRows(scanned, "bucket"), "chiv: uploading: no uploader or sink configured")
+}
@@ -0,0 +1,110 @@
+package chiv
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+)
+
+var snapshotID = regexp.MustCompile(`^[0-9A-Fa-f-]+$`)
+
+// ArchiveSnapshot archives the tables matching the given patterns to S3 from a single read-only
+// transaction, so that related tables are archived consistently as of the same point in time.
+// Patterns are as for ArchiveTables. With WithTableConcurrency and Postgres, tables are archived
+// concurrently from transactions sharing an exported snapshot; otherwise they are archived in turn.
+// Snapshots cannot be combined with WithParallelism.
+func (a *Archiver) ArchiveSnapshot(patterns []string, bucket string, options ...Option) error {
+	return a.ArchiveSnapshotWithContext(context.Background(), patterns, bucket, options...)
+}
+
+// ArchiveSnapshotWithContext is like ArchiveSnapshot, with context.
+func (a *Archiver) ArchiveSnapshotWithContext(ctx context.Context, patterns []string, bucket string, options ...Option) (err error) {
+	b := *a
+	for _, option := range options {
+		option(&b)
+	}
+
+	if b.parallelism > 1 {
+		// Parallel queries would hold concurrent result sets open on the snapshot's transaction.
+		return errorf("snapshot: parallelism cannot be used with snapshots")
+	}
+
+	beginner, ok := b.db.(Beginner)
+	if !ok {
+		return errorf("snapshot: database does not support transactions")
+	}
+	opts := b.dialect.Snapshot()
+	if opts == nil {
+		return errorf("snapshot: database dialect does not support snapshots")
+	}
+
+	tx, err := beginner.BeginTx(ctx, opts)
+	if err != nil {
+		return errorf("snapshot: beginning transaction: %w", err)
+	}
+	defer func() {
+		if e := tx.Rollback(); e != nil && e != sql.ErrTxDone && err == nil {
+			err = errorf("snapshot: ending transaction: %w", e)
+		}
+	}()
+
+	s := b
+	s.db = tx
+	tables, err := s.tables(ctx, patterns)
+	if err != nil {
+		return errorf("listing tables: %w", err)
+	}
+	if err := b.distinctKeys(len(tables)); err != nil {
+		return err
+	}
+
+	dbs := []Database{tx}
+	if _, ok := b.dialect.(postgresDialect); ok && b.concurrency > 1 && len(tables) > 1 {
+		n := b.concurrency
+		if n > len(tables) {
+			n = len(tables)
+		}
+		if dbs, err = b.shareSnapshot(ctx, beginner, opts, tx, n); err != nil {
+			return errorf("snapshot: %w", err)
+		}
+		defer func() {
+			for _, db := range dbs {
+				_ = db.(*sql.Tx).Rollback()
+			}
+		}()
+	}
+
+	return s.archiveTables(ctx, dbs, tables, bucket)
+}
+
+// shareSnapshot exports the snapshot of a Postgres transaction and begins n transactions importing it.
+func (a *Archiver) shareSnapshot(ctx context.Context, beginner Beginner, opts *sql.TxOptions, tx *sql.Tx, n int) ([]Database, error) {
+	var id string
+	if err := tx.QueryRowContext(ctx, "SELECT pg_export_snapshot();").Scan(&id); err != nil {
+		return nil, fmt.Errorf("exporting snapshot: %w", err)
+	}
+	if !snapshotID.MatchString(id) {
+		return nil, fmt.Errorf("invalid snapshot ID '%s'", id)
+	}
+
+	dbs := make([]Database, 0, n)
+	for i := 0; i < n; i++ {
+		shared, err := beginner.BeginTx(ctx, opts)
+		if err == nil {
+			_, err = shared.ExecContext(ctx, fmt.Sprintf("SET TRANSACTION SNAPSHOT '%s';", id))
+			if err != nil {
+				_ = shared.Rollback()
+			}
+		}
+		if err != nil {
+			for _, db := range dbs {
+				_ = db.(*sql.Tx).Rollback()
+			}
+			return nil, fmt.Errorf("importing snapshot: %w", err)
+		}
+		dbs = append(dbs, shared)
+	}
+
+	return dbs, nil
+}
@@ -0,0 +1,86 @@
+// +build unit
+
+package chiv_test
+
+import (
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gavincabbage.com/chiv"
+)
+
+func TestArchiveSnapshot(t *testing.T) {
+	cases := []struct {
+		name     string
+		archive  func(*chiv.Archiver, []string) error
+		expected map[string]string
+	}{
+		{
+			name: "snapshot",
+			archive: func(a *chiv.Archiver, tables []string) error {
+				return a.ArchiveSnapshot(tables, "bucket")
+			},
+			expected: map[string]string{"orders": "1,1\n", "order_items": "1,1\n"},
+		},
+		{
+			name: "no snapshot",
+			archive: func(a *chiv.Archiver, tables []string) error {
+				return a.ArchiveTables(tables, "bucket")
+			},
+			expected: map[string]string{"orders": "1,1\n", "order_items": "1,1\n2,2\n"},
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			db := newSQLite(t, `
+				PRAGMA journal_mode=WAL;
+				CREATE TABLE orders (id INTEGER, item INTEGER);
+				CREATE TABLE order_items (id INTEGER, item INTEGER);
+				INSERT INTO orders VALUES (1, 1);
+				INSERT INTO order_items VALUES (1, 1);
+			`)
+
+			// Write to a table while the first is being archived.
+			var once sync.Once
+			writeOnce := func(w io.Writer, columns []chiv.Column) chiv.Formatter {
+				once.Do(func() {
+					_, err := db.Exec(`INSERT INTO order_items VALUES (2, 2);`)
+					require.NoError(t, err)
+				})
+				return writing(w, columns)
+			}
+
+			var (
+				uploader = &uploader{}
+				subject  = chiv.NewArchiver(db, uploader, chiv.WithFormat(writeOnce))
+			)
+
+			require.NoError(t, test.archive(subject, []string{"orders", "order_items"}))
+			require.Equal(t, test.expected, uploader.uploads)
+		})
+	}
+}
+
+func TestArchiveSnapshotUnsupported(t *testing.T) {
+	db := newFixture(t, &fixture{})
+	defer db.Close()
+
+	subject := chiv.NewArchiver(db, &uploader{}, chiv.WithDialect(chiv.ClickHouse))
+	require.EqualError(t, subject.ArchiveSnapshot([]string{"events"}, "bucket"),
+		"chiv: snapshot: database dialect does not support snapshots")
+}
+
+func TestArchiveSnapshotParallelism(t *testing.T) {
+	f := &fixture{}
+	db := newFixture(t, f)
+	defer db.Close()
+
+	subject := chiv.NewArchiver(db, &uploader{}, chiv.WithDialect(chiv.Postgres))
+	require.EqualError(t, subject.ArchiveSnapshot([]string{"events"}, "bucket", chiv.WithParallelism(4, "id")),
+		"chiv: snapshot: parallelism cannot be used with snapshots")
+	require.Empty(t, f.queries)
+}
@@ -0,0 +1,264 @@
+package chiv
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+const defaultTableConcurrency = 1
+
+// TableError is an error archiving one of multiple tables.
+type TableError struct {
+	Table string
+	Err   error
+}
+
+func (e *TableError) Error() string {
+	return fmt.Sprintf("'%s': %v", e.Table, e.Err)
+}
+
+func (e *TableError) Unwrap() error {
+	return e.Err
+}
+
+// TablesError aggregates the errors archiving multiple tables, ordered by table name.
+type TablesError []*TableError
+
+func (e TablesError) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+
+	return fmt.Sprintf("chiv: archiving tables: %s", strings.Join(messages, "; "))
+}
+
+// ArchiveSchema archives every table of a database schema to S3, each under its own key.
+// An empty schema selects the connection's default schema. Tables are archived concurrently
+// up to the limit set by WithTableConcurrency, and failing tables do not stop the others.
+func (a *Archiver) ArchiveSchema(schema, bucket string, options ...Option) error {
+	return a.ArchiveSchemaWithContext(context.Background(), schema, bucket, options...)
+}
+
+// ArchiveSchemaWithContext is like ArchiveSchema, with context.
+func (a *Archiver) ArchiveSchemaWithContext(ctx context.Context, schema, bucket string, options ...Option) error {
+	pattern := "*"
+	if schema != "" {
+		pattern = schema + ".*"
+	}
+
+	return a.ArchiveTablesWithContext(ctx, []string{pattern}, bucket, options...)
+}
+
+// ArchiveTables archives the tables matching the given patterns to S3, each under its own key.
+// Patterns are table names, optionally schema-qualified, that may contain path.Match wildcards
+// in the table name, e.g. "reporting.daily_*". Patterns prefixed with ! exclude matching tables.
+// Tables are archived as by ArchiveSchema.
+func (a *Archiver) ArchiveTables(patterns []string, bucket string, options ...Option) error {
+	return a.ArchiveTablesWithContext(context.Background(), patterns, bucket, options...)
+}
+
+// ArchiveTablesWithContext is like ArchiveTables, with context.
+func (a *Archiver) ArchiveTablesWithContext(ctx context.Context, patterns []string, bucket string, options ...Option) error {
+	b := *a
+	for _, option := range options {
+		option(&b)
+	}
+
+	tables, err := b.tables(ctx, patterns)
+	if err != nil {
+		return errorf("listing tables: %w", err)
+	}
+	if err := b.distinctKeys(len(tables)); err != nil {
+		return err
+	}
+
+	n := b.concurrency
+	if n < 1 || n > len(tables) {
+		n = len(tables)
+	}
+	dbs := make([]Database, n)
+	for i := range dbs {
+		dbs[i] = b.db
+	}
+
+	return b.archiveTables(ctx, dbs, tables, bucket)
+}
+
+// distinctKeys checks that the configured key or key template gives each of the given number of
+// tables its own objects, so tables don't overwrite each other.
+func (a *Archiver) distinctKeys(tables int) error {
+	if tables < 2 {
+		return nil
+	}
+	if a.key != "" {
+		return errorf("archiving %d tables to the same key '%s'", tables, a.key)
+	}
+	if a.keyTemplate == "" {
+		return nil
+	}
+
+	t, err := template.New("key").Funcs(keyFuncs).Option("missingkey=error").Parse(a.keyTemplate)
+	if err != nil {
+		return errorf("parsing key template: %w", err)
+	}
+	if ok, err := varies(t, KeyData{Table: "a"}, KeyData{Table: "b"}); err != nil {
+		return errorf("parsing key template: %w", err)
+	} else if !ok {
+		return errorf("archiving %d tables to the same keys: template must include {{.Table}}", tables)
+	}
+
+	return nil
+}
+
+// archiveTables archives tables concurrently, one at a time from each of the given databases,
+// collecting the errors of any that fail.
+func (a *Archiver) archiveTables(ctx context.Context, dbs []Database, tables []string, bucket string) error {
+	var (
+		queue = make(chan string)
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		errs  TablesError
+	)
+
+	for _, db := range dbs {
+		b := *a
+		b.db = db
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for table := range queue {
+				if err := b.ArchiveWithContext(ctx, table, bucket); err != nil {
+					mu.Lock()
+					errs = append(errs, &TableError{Table: table, Err: err})
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, table := range tables {
+		select {
+		case queue <- table:
+		case <-ctx.Done():
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return errorf("archiving tables: %w", err)
+	}
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Table < errs[j].Table })
+		return errs
+	}
+
+	return nil
+}
+
+// tables resolves table patterns to the names of the tables to archive, listing the tables of
+// a schema only when one of its patterns contains wildcards.
+func (a *Archiver) tables(ctx context.Context, patterns []string) ([]string, error) {
+	var (
+		tables   []string
+		seen     = make(map[string]bool)
+		listed   = make(map[string][]string)
+		excludes []string
+	)
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "!") {
+			excludes = append(excludes, strings.TrimPrefix(pattern, "!"))
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern '%s': %w", pattern, err)
+		}
+
+		candidates := []string{pattern}
+		if strings.ContainsAny(pattern, `*?[\`) {
+			schema, name := "", pattern
+			if i := strings.LastIndex(pattern, "."); i >= 0 {
+				schema, name = pattern[:i], pattern[i+1:]
+			}
+
+			names, ok := listed[schema]
+			if !ok {
+				var err error
+				if names, err = a.listTables(ctx, schema); err != nil {
+					return nil, err
+				}
+				listed[schema] = names
+			}
+
+			candidates = nil
+			for _, table := range names {
+				if matched, _ := path.Match(name, table); matched {
+					if schema != "" {
+						table = schema + "." + table
+					}
+					candidates = append(candidates, table)
+				}
+			}
+		}
+
+		for _, table := range candidates {
+			if !seen[table] {
+				seen[table] = true
+				tables = append(tables, table)
+			}
+		}
+	}
+
+	out := tables[:0]
+	for _, table := range tables {
+		if !excluded(table, excludes) {
+			out = append(out, table)
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no tables match %s", strings.Join(patterns, ", "))
+	}
+
+	return out, nil
+}
+
+func excluded(table string, excludes []string) bool {
+	for _, exclude := range excludes {
+		if matched, _ := path.Match(exclude, table); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// listTables lists the tables of a schema from the database catalog.
+func (a *Archiver) listTables(ctx context.Context, schema string) ([]string, error) {
+	query, args := a.dialect.Tables(schema)
+	if query == "" {
+		return nil, fmt.Errorf("listing tables is not supported by the database dialect")
+	}
+
+	rows, err := a.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+
+	return tables, rows.Err()
+}
@@ -0,0 +1,129 @@
+// +build unit
+
+package chiv_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gavincabbage.com/chiv"
+)
+
+func TestArchiveTables(t *testing.T) {
+	db := newSQLite(t, `
+		CREATE TABLE daily_sales (id INTEGER, total INTEGER);
+		CREATE TABLE daily_visits (id INTEGER, total INTEGER);
+		CREATE TABLE monthly_sales (id INTEGER, total INTEGER);
+		CREATE TABLE users (name TEXT);
+		INSERT INTO daily_sales VALUES (1, 10);
+		INSERT INTO daily_visits VALUES (2, 20);
+		INSERT INTO monthly_sales VALUES (3, 30);
+		INSERT INTO users VALUES ('first');
+	`)
+
+	cases := []struct {
+		name     string
+		patterns []string
+		schema   *string
+		expected map[string]string
+		err      string
+	}{
+		{
+			name:     "schema",
+			schema:   new(string),
+			expected: map[string]string{"daily_sales": "1,10\n", "daily_visits": "2,20\n", "monthly_sales": "3,30\n", "users": "first\n"},
+		},
+		{
+			name:     "qualified schema",
+			schema:   func(s string) *string { return &s }("main"),
+			expected: map[string]string{"main.daily_sales": "1,10\n", "main.daily_visits": "2,20\n", "main.monthly_sales": "3,30\n", "main.users": "first\n"},
+		},
+		{
+			name:     "patterns",
+			patterns: []string{"daily_*", "*_sales"},
+			expected: map[string]string{"daily_sales": "1,10\n", "daily_visits": "2,20\n", "monthly_sales": "3,30\n"},
+		},
+		{
+			name:     "excludes",
+			patterns: []string{"*", "!daily_*"},
+			expected: map[string]string{"monthly_sales": "3,30\n", "users": "first\n"},
+		},
+		{
+			name:     "literal names",
+			patterns: []string{"users", "daily_sales", "users"},
+			expected: map[string]string{"daily_sales": "1,10\n", "users": "first\n"},
+		},
+		{
+			name:     "no match",
+			patterns: []string{"weekly_*"},
+			err:      "chiv: listing tables: no tables match weekly_*",
+		},
+		{
+			name:     "invalid pattern",
+			patterns: []string{"daily_["},
+			err:      "chiv: listing tables: invalid pattern 'daily_[': syntax error in pattern",
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				uploader = &uploader{}
+				subject  = chiv.NewArchiver(db, uploader, chiv.WithFormat(writing), chiv.WithTableConcurrency(3))
+			)
+
+			var err error
+			if test.schema != nil {
+				err = subject.ArchiveSchema(*test.schema, "bucket")
+			} else {
+				err = subject.ArchiveTables(test.patterns, "bucket")
+			}
+			if test.err != "" {
+				require.EqualError(t, err, test.err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, test.expected, uploader.uploads)
+		})
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		var (
+			uploader = &uploader{}
+			subject  = chiv.NewArchiver(db, uploader, chiv.WithFormat(writing), chiv.WithTableConcurrency(2))
+		)
+
+		err := subject.ArchiveTables([]string{"*"}, "bucket", chiv.WithColumns("total"))
+		require.EqualError(t, err, "chiv: archiving tables: 'users': chiv: querying 'users': column 'total' not found in table 'users'")
+
+		var errs chiv.TablesError
+		require.True(t, errors.As(err, &errs))
+		require.Equal(t, "users", errs[0].Table)
+		require.Equal(t, map[string]string{"daily_sales": "10\n", "daily_visits": "20\n", "monthly_sales": "30\n"}, uploader.uploads)
+	})
+
+	t.Run("same key", func(t *testing.T) {
+		subject := chiv.NewArchiver(db, &uploader{}, chiv.WithKey("archive.csv"))
+
+		err := subject.ArchiveTables([]string{"daily_*"}, "bucket")
+		require.EqualError(t, err, "chiv: archiving 2 tables to the same key 'archive.csv'")
+	})
+
+	t.Run("same key template", func(t *testing.T) {
+		subject := chiv.NewArchiver(db, &uploader{}, chiv.WithKeyTemplate("{{.RunID}}.{{.Extension}}"))
+
+		err := subject.ArchiveTables([]string{"daily_*"}, "bucket")
+		require.EqualError(t, err, "chiv: archiving 2 tables to the same keys: template must include {{.Table}}")
+	})
+
+	t.Run("key template", func(t *testing.T) {
+		uploader := &uploader{}
+		subject := chiv.NewArchiver(db, uploader, chiv.WithFormat(writing), chiv.WithKeyTemplate("{{upper .Table}}"))
+
+		require.NoError(t, subject.ArchiveTables([]string{"daily_*"}, "bucket"))
+		require.Equal(t, map[string]string{"DAILY_SALES": "1,10\n", "DAILY_VISITS": "2,20\n"}, uploader.uploads)
+	})
+}
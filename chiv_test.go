@@ -3,14 +3,18 @@
 package chiv_test
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
-
 	"github.com/stretchr/testify/require"
 
 	"gavincabbage.com/chiv"
@@ -218,6 +222,151 @@ func TestArchiveRows(t *testing.T) {
 	}
 }
 
+func TestArchiveRowsSplit(t *testing.T) {
+	cases := []struct {
+		name     string
+		scan     [][]string
+		options  []chiv.Option
+		expected map[string]string
+	}{
+		{
+			name: "no rows",
+			options: []chiv.Option{
+				chiv.WithMaxRowsPerObject(2),
+			},
+			expected: map[string]string{
+				"table-00001": "",
+			},
+		},
+		{
+			name: "max rows",
+			scan: [][]string{{"first", "1"}, {"second", "2"}, {"third", "3"}, {"fourth", "4"}, {"fifth", "5"}},
+			options: []chiv.Option{
+				chiv.WithMaxRowsPerObject(2),
+			},
+			expected: map[string]string{
+				"table-00001": "first,1\nsecond,2\n",
+				"table-00002": "third,3\nfourth,4\n",
+				"table-00003": "fifth,5\n",
+			},
+		},
+		{
+			name: "max rows exact",
+			scan: [][]string{{"first", "1"}, {"second", "2"}},
+			options: []chiv.Option{
+				chiv.WithMaxRowsPerObject(2),
+			},
+			expected: map[string]string{
+				"table-00001": "first,1\nsecond,2\n",
+			},
+		},
+		{
+			name: "max bytes",
+			scan: [][]string{{"first", "1"}, {"second", "2"}, {"third", "3"}},
+			options: []chiv.Option{
+				chiv.WithMaxBytesPerObject(10),
+			},
+			expected: map[string]string{
+				"table-00001": "first,1\nsecond,2\n",
+				"table-00002": "third,3\n",
+			},
+		},
+		{
+			name: "extension and key",
+			scan: [][]string{{"first", "1"}, {"second", "2"}},
+			options: []chiv.Option{
+				chiv.WithMaxRowsPerObject(1),
+				chiv.WithKey("2019/september/archive.csv.gz"),
+			},
+			expected: map[string]string{
+				"2019/september/archive-00001.csv.gz": "first,1\n",
+				"2019/september/archive-00002.csv.gz": "second,2\n",
+			},
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				rows = &rows{
+					columns: []string{"first_column", "second_column"},
+					scan:    test.scan,
+				}
+				uploader = &uploader{}
+				options  = append(test.options, chiv.WithFormat(writing))
+			)
+
+			require.NoError(t, chiv.ArchiveRows(rows, uploader, "bucket", options...))
+			require.Equal(t, test.expected, uploader.uploads)
+		})
+	}
+}
+
+func TestArchiveQuery(t *testing.T) {
+	cases := []struct {
+		name     string
+		options  []chiv.Option
+		expected string
+		args     []interface{}
+	}{
+		{
+			name:     "all rows",
+			expected: `SELECT * FROM "events";`,
+			args:     []interface{}{},
+		},
+		{
+			name: "columns",
+			options: []chiv.Option{
+				chiv.WithColumns("id", "name"),
+			},
+			expected: `SELECT "id", "name" FROM "events";`,
+			args:     []interface{}{},
+		},
+		{
+			name: "where",
+			options: []chiv.Option{
+				chiv.WithWhere("id > ? AND name <> '?'", 10),
+			},
+			expected: `SELECT * FROM "events" WHERE (id > ? AND name <> '?');`,
+			args:     []interface{}{int64(10)},
+		},
+		{
+			name: "order by and limit",
+			options: []chiv.Option{
+				chiv.WithOrderBy("name DESC", "id"),
+				chiv.WithLimit(100),
+			},
+			expected: `SELECT * FROM "events" ORDER BY name DESC, id LIMIT ?;`,
+			args:     []interface{}{int64(100)},
+		},
+		{
+			name: "where order by and limit",
+			options: []chiv.Option{
+				chiv.WithWhere("name = ? OR name = ?", "first", "second"),
+				chiv.WithOrderBy("id"),
+				chiv.WithLimit(1),
+			},
+			expected: `SELECT * FROM "events" WHERE (name = ? OR name = ?) ORDER BY id LIMIT ?;`,
+			args:     []interface{}{"first", "second", int64(1)},
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			f := &fixture{
+				columns: []string{"id", "name"},
+			}
+			db := newFixture(t, f)
+			defer db.Close()
+
+			options := append(test.options, chiv.WithFormat(writing))
+			require.NoError(t, chiv.Archive(db, &uploader{}, "events", "bucket", options...))
+			require.Equal(t, []string{test.expected}, f.queries)
+			require.Equal(t, test.args, f.args[0])
+		})
+	}
+}
+
 type rows struct {
 	columns []string
 	scan    [][]string
@@ -255,20 +404,46 @@ func (r *rows) Err() error {
 }
 
 type uploader struct {
-	uploadKey string
-	uploadErr error
+	mu               sync.Mutex
+	uploadKey        string
+	uploadBody       []byte
+	uploadAttributes *chiv.Attributes
+	uploads          map[string]string
+	uploadErr        error
 }
 
-func (u *uploader) UploadWithContext(ctx aws.Context, input *s3manager.UploadInput, opts ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
-	p := make([]byte, 1)
+func (u *uploader) Upload(_ context.Context, bucket, key string, attributes *chiv.Attributes, r io.Reader) (*chiv.ObjectInfo, error) {
+	var (
+		p    = make([]byte, 1)
+		body []byte
+	)
 	for {
-		if _, err := input.Body.Read(p); err != nil {
+		n, err := r.Read(p)
+		body = append(body, p[:n]...)
+		if err != nil {
 			break
 		}
 	}
 
-	u.uploadKey = *input.Key
-	return nil, u.uploadErr
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.uploads == nil {
+		u.uploads = make(map[string]string)
+	}
+	u.uploads[key] = string(body)
+	u.uploadKey = key
+	u.uploadBody = body
+	u.uploadAttributes = attributes
+	if u.uploadErr != nil {
+		return nil, u.uploadErr
+	}
+
+	return &chiv.ObjectInfo{
+		Location:  "https://" + bucket + ".s3.amazonaws.com/" + key,
+		VersionID: "version",
+		ETag:      `"etag"`,
+	}, nil
 }
 
 func format(f chiv.Formatter) chiv.FormatterFunc {
@@ -307,6 +482,27 @@ func (f *formatter) Close() error {
 	return f.closeErr
 }
 
+type writingFormatter struct {
+	w io.Writer
+}
+
+func (f *writingFormatter) Open() error {
+	return nil
+}
+
+func (f *writingFormatter) Format(record [][]byte) error {
+	_, err := f.w.Write(append(bytes.Join(record, []byte(",")), '\n'))
+	return err
+}
+
+func (f *writingFormatter) Close() error {
+	return nil
+}
+
+func writing(w io.Writer, _ []chiv.Column) chiv.Formatter {
+	return &writingFormatter{w: w}
+}
+
 type extensionFormatter struct {
 	*formatter
 }
@@ -314,3 +510,175 @@ type extensionFormatter struct {
 func (f *extensionFormatter) Extension() string {
 	return "ext"
 }
+
+func init() {
+	sql.Register("fake", &fakeDriver{})
+}
+
+var fixtures sync.Map
+
+// fixture is a table served by the fake database/sql driver, registered under its DSN.
+type fixture struct {
+	columns []string
+	types   []string
+	rows    [][]interface{}
+
+	// affected overrides the rows affected by each statement, which defaults to its argument count.
+	affected int64
+	// respond overrides the columns and rows returned for the queries it reports, e.g. catalog queries.
+	respond func(query string, args []interface{}) ([]string, [][]interface{}, bool)
+
+	mu         sync.Mutex
+	queries    []string
+	args       [][]interface{}
+	committed  bool
+	rolledBack bool
+}
+
+// newSQLite opens a SQLite database in a temporary directory, executing the given schema.
+func newSQLite(t *testing.T, schema string) *sql.DB {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "chiv")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	db, err := sql.Open("sqlite", filepath.Join(dir, "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(schema)
+	require.NoError(t, err)
+
+	return db
+}
+
+func newFixture(t *testing.T, f *fixture) *sql.DB {
+	t.Helper()
+
+	fixtures.Store(t.Name(), f)
+	db, err := sql.Open("fake", t.Name())
+	require.NoError(t, err)
+
+	return db
+}
+
+func (f *fixture) record(query string, args []driver.NamedValue) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	values := make([]interface{}, len(args))
+	for i := range args {
+		values[i] = args[i].Value
+	}
+	f.queries = append(f.queries, query)
+	f.args = append(f.args, values)
+}
+
+type fakeDriver struct{}
+
+func (*fakeDriver) Open(name string) (driver.Conn, error) {
+	f, ok := fixtures.Load(name)
+	if !ok {
+		return nil, errors.New("unknown fixture")
+	}
+
+	return &fakeConn{f.(*fixture)}, nil
+}
+
+type fakeConn struct {
+	fixture *fixture
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return &fakeTx{fixture: c.fixture}, nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.fixture.record(query, args)
+	if c.fixture.affected > 0 {
+		return driver.RowsAffected(c.fixture.affected), nil
+	}
+
+	return driver.RowsAffected(len(args)), nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.fixture.record(query, args)
+	if c.fixture.respond != nil {
+		values := make([]interface{}, len(args))
+		for i := range args {
+			values[i] = args[i].Value
+		}
+		if columns, rows, ok := c.fixture.respond(query, values); ok {
+			return &fakeRows{fixture: &fixture{columns: columns, rows: rows}}, nil
+		}
+	}
+
+	return &fakeRows{fixture: c.fixture}, nil
+}
+
+type fakeTx struct {
+	fixture *fixture
+}
+
+func (tx *fakeTx) Commit() error {
+	tx.fixture.mu.Lock()
+	defer tx.fixture.mu.Unlock()
+
+	tx.fixture.committed = true
+	return nil
+}
+
+func (tx *fakeTx) Rollback() error {
+	tx.fixture.mu.Lock()
+	defer tx.fixture.mu.Unlock()
+
+	tx.fixture.rolledBack = true
+	return nil
+}
+
+type fakeRows struct {
+	fixture *fixture
+	ndx     int
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.fixture.columns
+}
+
+func (r *fakeRows) ColumnTypeDatabaseTypeName(i int) string {
+	if i < len(r.fixture.types) {
+		return r.fixture.types[i]
+	}
+
+	return "TEXT"
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.ndx >= len(r.fixture.rows) {
+		return io.EOF
+	}
+
+	for i, v := range r.fixture.rows[r.ndx] {
+		if s, ok := v.(string); ok {
+			v = []byte(s)
+		}
+		dest[i] = v
+	}
+
+	r.ndx++
+	return nil
+}
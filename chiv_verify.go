@@ -0,0 +1,193 @@
+package chiv
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+)
+
+// Object metadata describing a verified archive.
+const (
+	MetadataChecksum = "chiv-sha256"
+	MetadataRows     = "chiv-rows"
+)
+
+// maxCopySize is the size of the largest object S3 copies in a single request.
+const maxCopySize = 5 << 30
+
+// Verification is a method of verifying uploaded objects.
+type Verification int
+
+// Verification methods.
+const (
+	// VerifySize compares the size of each uploaded object, as reported by S3, to the bytes uploaded.
+	VerifySize Verification = iota
+	// VerifyContent downloads each uploaded object again, comparing its size and checksum to those uploaded
+	// and, if its format can be parsed, the rows it contains to those archived.
+	VerifyContent
+)
+
+// stored describes an object as stored in S3. Its checksum is empty and its rows negative if unknown.
+type stored struct {
+	size       int64
+	checksum   string
+	rows       int64
+	attributes Attributes
+}
+
+// verify an uploaded object, failing if it does not match the data uploaded. Verified objects are
+// annotated with their checksum and rows if configured, unless too large to copy in place.
+func (a *Archiver) verify(ctx context.Context, object *ObjectResult) (err error) {
+	defer func() {
+		if err != nil {
+			a.fail(ctx, a.results.table, StageVerify, err)
+		}
+	}()
+
+	var s *stored
+	switch a.verifyBy {
+	case VerifySize:
+		s, err = a.head(ctx, object)
+	case VerifyContent:
+		s, err = a.reread(ctx, object)
+	default:
+		err = fmt.Errorf("unknown verification %d", a.verifyBy)
+	}
+	if err != nil {
+		return errorf("verifying '%s': %w", object.Key, err)
+	}
+
+	if s.size != object.UploadedBytes {
+		return errorf("verifying '%s': uploaded %d bytes, found %d", object.Key, object.UploadedBytes, s.size)
+	}
+	if s.rows >= 0 && s.rows != object.Rows {
+		return errorf("verifying '%s': archived %d rows, found %d", object.Key, object.Rows, s.rows)
+	}
+	if s.checksum != "" && s.checksum != object.Checksum {
+		return errorf("verifying '%s': uploaded checksum %s, found %s", object.Key, object.Checksum, s.checksum)
+	}
+
+	if !a.annotation || object.UploadedBytes > maxCopySize {
+		return nil
+	}
+	if err := a.annotate(ctx, object, s); err != nil {
+		return errorf("verifying '%s': adding metadata: %w", object.Key, err)
+	}
+
+	return nil
+}
+
+// head describes an object by its metadata.
+func (a *Archiver) head(ctx context.Context, object *ObjectResult) (*stored, error) {
+	size, attributes, err := a.verifier.Stat(ctx, object.Bucket, object.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &stored{size: size, rows: -1}
+	if attributes != nil {
+		s.attributes = *attributes
+	}
+
+	return s, nil
+}
+
+// reread describes an object by downloading it again, checksumming it and counting its rows
+// if its format can be parsed.
+func (a *Archiver) reread(ctx context.Context, object *ObjectResult) (*stored, error) {
+	r, attributes, err := a.verifier.Download(ctx, object.Bucket, object.Key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var (
+		h    = sha256.New()
+		body = &readCounter{Reader: io.TeeReader(r, h)}
+		s    = &stored{rows: -1}
+	)
+	if attributes != nil {
+		s.attributes = *attributes
+	}
+	if parse := a.parserFor(object.Format); parse != nil {
+		if s.rows, err = a.count(ctx, body, object.Key, &s.attributes, parse); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := io.Copy(ioutil.Discard, body); err != nil {
+		return nil, fmt.Errorf("downloading: %w", err)
+	}
+
+	s.size = body.n
+	s.checksum = hex.EncodeToString(h.Sum(nil))
+	return s, nil
+}
+
+// count the rows of a downloaded object.
+func (a *Archiver) count(ctx context.Context, body io.Reader, key string, attributes *Attributes, parse ParserFunc) (int64, error) {
+	r, _, err := a.decode(ctx, body, key, attributes)
+	if err != nil {
+		return 0, err
+	}
+	// The reader is closed before the rest of the body is read, as decompressors may read concurrently.
+	defer r.Close()
+
+	parser := parse(r)
+	defer func() {
+		if parser != nil {
+			_ = parser.Close()
+		}
+	}()
+	if _, err := parser.Open(); err != nil {
+		return 0, fmt.Errorf("opening parser: %w", err)
+	}
+
+	var rows int64
+	for {
+		if _, err := parser.Parse(); err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, fmt.Errorf("parsing: %w", err)
+		}
+		rows++
+	}
+
+	err = parser.Close()
+	parser = nil
+	if err != nil {
+		return 0, fmt.Errorf("closing parser: %w", err)
+	}
+
+	return rows, nil
+}
+
+// annotate an object with its checksum and rows, replacing its metadata. The checksum is that of the
+// downloaded object if it was downloaded again, and otherwise that of the uploaded bytes.
+func (a *Archiver) annotate(ctx context.Context, object *ObjectResult, s *stored) error {
+	attributes := s.attributes
+	attributes.Metadata = make(map[string]string, len(s.attributes.Metadata)+2)
+	for k, v := range s.attributes.Metadata {
+		attributes.Metadata[k] = v
+	}
+	attributes.Metadata[MetadataChecksum] = object.Checksum
+	if s.checksum != "" {
+		attributes.Metadata[MetadataChecksum] = s.checksum
+	}
+	attributes.Metadata[MetadataRows] = strconv.FormatInt(object.Rows, 10)
+
+	info, err := a.verifier.Annotate(ctx, object.Bucket, object.Key, &attributes)
+	if err != nil {
+		return err
+	}
+
+	object.VersionID = info.VersionID
+	if info.ETag != "" {
+		object.ETag = info.ETag
+	}
+
+	return nil
+}
@@ -0,0 +1,217 @@
+// +build unit
+
+package chiv_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"gavincabbage.com/chiv"
+)
+
+func TestVerify(t *testing.T) {
+	db := newRestoreFixture(t)
+	defer db.Close()
+
+	cases := []struct {
+		name         string
+		verification chiv.Verification
+		options      []chiv.Option
+		key          string
+	}{
+		{
+			name:         "size",
+			verification: chiv.VerifySize,
+			key:          "events.csv",
+		},
+		{
+			name:         "content",
+			verification: chiv.VerifyContent,
+			key:          "events.csv",
+		},
+		{
+			name:         "content gzip json",
+			verification: chiv.VerifyContent,
+			options:      []chiv.Option{chiv.WithFormat(chiv.JSON), chiv.WithCompression(chiv.Gzip, gzip.DefaultCompression)},
+			key:          "events.json.gz",
+		},
+		{
+			name:         "content encrypted",
+			verification: chiv.VerifyContent,
+			options:      []chiv.Option{chiv.WithFormat(chiv.NDJSON), chiv.WithEncryption(newKeyWrapper(t))},
+			key:          "events.jsonl",
+		},
+		{
+			name:         "content without parser",
+			verification: chiv.VerifyContent,
+			options:      []chiv.Option{chiv.WithFormat(chiv.Parquet)},
+			key:          "events.parquet",
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				uploader = &uploader{}
+				verifier = &verifier{uploader: uploader}
+				options  = append([]chiv.Option{chiv.WithVerify(verifier, test.verification), chiv.WithAnnotation()}, test.options...)
+			)
+			result, err := chiv.NewArchiver(db, uploader, options...).ArchiveWithResult("events", "bucket")
+			require.NoError(t, err)
+			require.Len(t, result.Objects, 1)
+
+			checksum := sha256.Sum256([]byte(uploader.uploads[test.key]))
+			object := result.Objects[0]
+			require.Equal(t, hex.EncodeToString(checksum[:]), object.Checksum)
+			require.Equal(t, "copied", object.ETag)
+
+			require.Equal(t, test.key, verifier.copied)
+			require.Equal(t, uploader.uploadAttributes.ContentEncoding, verifier.annotated.ContentEncoding)
+			require.Equal(t, object.Checksum, verifier.annotated.Metadata[chiv.MetadataChecksum])
+			require.Equal(t, "4", verifier.annotated.Metadata[chiv.MetadataRows])
+			for k, v := range uploader.uploadAttributes.Metadata {
+				require.Equal(t, v, verifier.annotated.Metadata[k])
+			}
+		})
+	}
+}
+
+func TestVerifyWithoutAnnotation(t *testing.T) {
+	db := newRestoreFixture(t)
+	defer db.Close()
+
+	for _, verification := range []chiv.Verification{chiv.VerifySize, chiv.VerifyContent} {
+		var (
+			uploader = &uploader{}
+			verifier = &verifier{uploader: uploader}
+		)
+		result, err := chiv.NewArchiver(db, uploader, chiv.WithVerify(verifier, verification)).ArchiveWithResult("events", "bucket")
+		require.NoError(t, err)
+		require.Len(t, result.Objects, 1)
+		require.Empty(t, verifier.copied)
+		require.NotEqual(t, "copied", result.Objects[0].ETag)
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	db := newRestoreFixture(t)
+	defer db.Close()
+
+	cases := []struct {
+		name         string
+		verification chiv.Verification
+		corrupt      func([]byte) []byte
+		err          error
+		expected     string
+	}{
+		{
+			name:         "size truncated",
+			verification: chiv.VerifySize,
+			corrupt:      func(b []byte) []byte { return b[:len(b)-1] },
+			expected:     "chiv: verifying 'events.csv': uploaded 68 bytes, found 67",
+		},
+		{
+			name:         "content truncated",
+			verification: chiv.VerifyContent,
+			corrupt:      func(b []byte) []byte { return b[:len(b)-1] },
+			expected:     "chiv: verifying 'events.csv': uploaded 68 bytes, found 67",
+		},
+		{
+			name:         "content checksum",
+			verification: chiv.VerifyContent,
+			corrupt:      func(b []byte) []byte { return bytes.Replace(b, []byte("first"), []byte("fIrst"), 1) },
+			expected:     "chiv: verifying 'events.csv': uploaded checksum ",
+		},
+		{
+			name:         "content rows",
+			verification: chiv.VerifyContent,
+			corrupt: func(b []byte) []byte {
+				return bytes.Replace(b, []byte("1,first,1.5\n2,,2"), []byte("1,\"irst,1.5\n2\",2"), 1)
+			},
+			expected: "chiv: verifying 'events.csv': archived 4 rows, found 3",
+		},
+		{
+			name:         "content parsing",
+			verification: chiv.VerifyContent,
+			corrupt:      func(b []byte) []byte { return bytes.Replace(b, []byte("first"), []byte("fir,t"), 1) },
+			expected:     "chiv: verifying 'events.csv': parsing: record on line 2: wrong number of fields",
+		},
+		{
+			name:         "head",
+			verification: chiv.VerifySize,
+			err:          chiv.ErrNotFound,
+			expected:     "chiv: verifying 'events.csv': object not found",
+		},
+		{
+			name:         "unknown verification",
+			verification: chiv.Verification(-1),
+			expected:     "chiv: verifying 'events.csv': unknown verification -1",
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				uploader = &uploader{}
+				verifier = &verifier{uploader: uploader, corrupt: test.corrupt, err: test.err}
+				subject  = chiv.NewArchiver(db, uploader, chiv.WithVerify(verifier, test.verification), chiv.WithAnnotation())
+			)
+			err := subject.Archive("events", "bucket")
+			require.Error(t, err)
+			require.Contains(t, err.Error(), test.expected)
+			require.Empty(t, verifier.copied)
+		})
+	}
+}
+
+// verifier reads back objects uploaded by an uploader, optionally corrupting them.
+type verifier struct {
+	uploader  *uploader
+	corrupt   func([]byte) []byte
+	err       error
+	copied    string
+	annotated *chiv.Attributes
+}
+
+func (v *verifier) object(key string) []byte {
+	v.uploader.mu.Lock()
+	defer v.uploader.mu.Unlock()
+
+	b := []byte(v.uploader.uploads[key])
+	if v.corrupt != nil {
+		b = v.corrupt(b)
+	}
+
+	return b
+}
+
+func (v *verifier) Download(_ context.Context, _, key string) (io.ReadCloser, *chiv.Attributes, error) {
+	if v.err != nil {
+		return nil, nil, v.err
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(v.object(key))), v.uploader.uploadAttributes, nil
+}
+
+func (v *verifier) Stat(_ context.Context, _, key string) (int64, *chiv.Attributes, error) {
+	if v.err != nil {
+		return 0, nil, v.err
+	}
+
+	return int64(len(v.object(key))), v.uploader.uploadAttributes, nil
+}
+
+func (v *verifier) Annotate(_ context.Context, _, key string, attributes *chiv.Attributes) (*chiv.ObjectInfo, error) {
+	v.copied = key
+	v.annotated = attributes
+
+	return &chiv.ObjectInfo{ETag: "copied"}, nil
+}
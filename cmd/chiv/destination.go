@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"gavincabbage.com/chiv"
+	"gavincabbage.com/chiv/awsv2"
+	"gavincabbage.com/chiv/sink/azure"
+	"gavincabbage.com/chiv/sink/gcs"
+	chivsftp "gavincabbage.com/chiv/sink/sftp"
+)
+
+// destination is where archives are written, parsed from the bucket flag.
+type destination struct {
+	scheme string
+	bucket string
+	url    *url.URL
+}
+
+// parseDestination parses an S3 bucket name or a destination URL.
+func parseDestination(raw string) (destination, error) {
+	if !strings.Contains(raw, "://") {
+		return destination{scheme: "s3", bucket: raw}, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return destination{}, fmt.Errorf("parsing destination: %w", err)
+	}
+
+	switch u.Scheme {
+	case "s3", "gs":
+		if u.Host == "" || strings.Trim(u.Path, "/") != "" {
+			return destination{}, fmt.Errorf("destination '%s' must name only a bucket", raw)
+		}
+		if u.Scheme == "s3" {
+			return destination{scheme: "s3", bucket: u.Host}, nil
+		}
+	case "az":
+		if container := strings.Trim(u.Path, "/"); u.Host == "" || container == "" || strings.Contains(container, "/") {
+			return destination{}, fmt.Errorf("destination '%s' must name an account and container", raw)
+		}
+	case "sftp":
+		if u.Host == "" {
+			return destination{}, fmt.Errorf("destination '%s' must name a host", raw)
+		}
+	case "file":
+		if u.Host != "" || u.Path == "" {
+			return destination{}, fmt.Errorf("destination '%s' must be an absolute path", raw)
+		}
+	default:
+		return destination{}, fmt.Errorf("unknown destination scheme '%s'", u.Scheme)
+	}
+
+	redacted := *u
+	if _, ok := u.User.Password(); ok {
+		redacted.User = url.User(u.User.Username())
+	}
+
+	return destination{scheme: u.Scheme, bucket: strings.TrimSuffix(redacted.String(), "/"), url: u}, nil
+}
+
+// sink opens a sink writing to a destination, uploading to S3 with the given client, which is only
+// required for S3 destinations.
+func (d destination) sink(ctx context.Context, client *s3.Client) (chiv.Sink, error) {
+	switch d.scheme {
+	case "s3":
+		return awsv2.NewSink(manager.NewUploader(client), d.bucket), nil
+	case "gs":
+		client, err := storage.NewClient(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("opening Google Cloud Storage client: %w", err)
+		}
+		return gcs.NewSink(client.Bucket(d.url.Host)), nil
+	case "az":
+		container, err := newContainerURL(d.url.Host, strings.Trim(d.url.Path, "/"))
+		if err != nil {
+			return nil, err
+		}
+		return azure.NewSink(container), nil
+	case "sftp":
+		client, err := newSFTPClient(d.url)
+		if err != nil {
+			return nil, err
+		}
+		dir := d.url.Path
+		if dir == "" {
+			dir = "."
+		}
+		return chivsftp.NewSink(client, dir), nil
+	case "file":
+		return chiv.NewFileSink(filepath.FromSlash(d.url.Path)), nil
+	}
+
+	return nil, fmt.Errorf("unknown destination scheme '%s'", d.scheme)
+}
+
+// newContainerURL returns the URL of an Azure Blob Storage container, authenticating with the account key in
+// AZURE_STORAGE_KEY or the connection string in AZURE_STORAGE_CONNECTION_STRING.
+func newContainerURL(account, container string) (azblob.ContainerURL, error) {
+	key := os.Getenv("AZURE_STORAGE_KEY")
+	endpoint := fmt.Sprintf("https://%s.blob.core.windows.net", account)
+	if connection := os.Getenv("AZURE_STORAGE_CONNECTION_STRING"); connection != "" {
+		for _, part := range strings.Split(connection, ";") {
+			pair := strings.SplitN(part, "=", 2)
+			if len(pair) != 2 {
+				continue
+			}
+			switch pair[0] {
+			case "AccountKey":
+				key = pair[1]
+			case "BlobEndpoint":
+				endpoint = strings.TrimSuffix(pair[1], "/")
+			}
+		}
+	}
+	if key == "" {
+		return azblob.ContainerURL{}, fmt.Errorf("az destinations require AZURE_STORAGE_KEY or AZURE_STORAGE_CONNECTION_STRING")
+	}
+
+	credential, err := azblob.NewSharedKeyCredential(account, key)
+	if err != nil {
+		return azblob.ContainerURL{}, fmt.Errorf("opening Azure credential: %w", err)
+	}
+	u, err := url.Parse(endpoint + "/" + container)
+	if err != nil {
+		return azblob.ContainerURL{}, fmt.Errorf("parsing Azure endpoint: %w", err)
+	}
+
+	return azblob.NewContainerURL(*u, azblob.NewPipeline(credential, azblob.PipelineOptions{})), nil
+}
+
+// newSFTPClient connects to an SFTP server, authenticating with the URL's password or the SSH agent and
+// checking the host key against ~/.ssh/known_hosts.
+func newSFTPClient(u *url.URL) (*sftp.Client, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("finding known hosts: %w", err)
+	}
+	hostKeys, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, fmt.Errorf("reading known hosts: %w", err)
+	}
+
+	config := &ssh.ClientConfig{User: u.User.Username(), HostKeyCallback: hostKeys}
+	if config.User == "" {
+		config.User = os.Getenv("USER")
+	}
+	if password, ok := u.User.Password(); ok {
+		config.Auth = append(config.Auth, ssh.Password(password))
+	}
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to SSH agent: %w", err)
+		}
+		config.Auth = append(config.Auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "22")
+	}
+	conn, err := ssh.Dial("tcp", host, config)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to SFTP server: %w", err)
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("opening SFTP session: %w", err)
+	}
+
+	return client, nil
+}
@@ -1,20 +1,28 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
+	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/urfave/cli"
 
 	"gavincabbage.com/chiv"
+	"gavincabbage.com/chiv/awsv2"
 
+	_ "github.com/ClickHouse/clickhouse-go"
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
+	_ "github.com/microsoft/go-mssqldb"
+	_ "modernc.org/sqlite"
 )
 
 var version string
@@ -24,29 +32,27 @@ func main() {
 		Name:      "chiv",
 		HelpName:  "chiv",
 		Usage:     "Archive relational data to Amazon S3",
-		UsageText: "chiv [flags...]",
+		UsageText: "chiv [flags...]\n   chiv restore [flags...]",
 		HideHelp:  true,
 		Action:    run,
+		Commands:  []cli.Command{restoreCommand},
 		Flags: []cli.Flag{
 			cli.StringFlag{
-				Name:     "database, d",
-				Usage:    "database connection string",
-				EnvVar:   "DATABASE_URL",
-				Required: true,
+				Name:   "database, d",
+				Usage:  "database connection string, required",
+				EnvVar: "DATABASE_URL",
 			},
-			cli.StringFlag{
-				Name:     "table, t",
-				Usage:    "database table to archive",
-				Required: true,
+			cli.StringSliceFlag{
+				Name:  "table, t",
+				Usage: "database tables to archive, comma-separated, with optional wildcards and ! exclusions, e.g. 'reporting.*', required",
 			},
 			cli.StringFlag{
-				Name:     "bucket, b",
-				Usage:    "upload S3 bucket name",
-				Required: true,
+				Name:  "bucket, b",
+				Usage: "upload S3 bucket name or destination URL: s3://bucket, gs://bucket, az://account/container, sftp://user@host/path or file:///path, required",
 			},
 			cli.StringFlag{
 				Name:     "driver, r",
-				Usage:    "database driver type: postgres or mysql",
+				Usage:    "database driver type: postgres, mysql, sqlite, sqlserver or clickhouse",
 				Required: false,
 				Value:    "postgres",
 			},
@@ -56,7 +62,7 @@ func main() {
 			},
 			cli.StringFlag{
 				Name:     "format, f",
-				Usage:    "upload format: csv, yaml or json",
+				Usage:    "upload format: csv, yaml, json, ndjson, parquet or avro",
 				Value:    "csv",
 				Required: false,
 			},
@@ -64,6 +70,10 @@ func main() {
 				Name:  "key, k",
 				Usage: "upload key",
 			},
+			cli.StringFlag{
+				Name:  "key-template",
+				Usage: "upload key template, e.g. '{{.Table}}/{{.Time | date \"2006/01/02\"}}/{{.RunID}}.{{.Extension}}'",
+			},
 			cli.StringFlag{
 				Name:  "extension, e",
 				Usage: "upload extension",
@@ -72,6 +82,109 @@ func main() {
 				Name:  "null, n",
 				Usage: "upload null value",
 			},
+			cli.StringFlag{
+				Name:  "compress, z",
+				Usage: "upload compression codec: gzip, zstd, bzip2, lz4 or snappy",
+			},
+			cli.IntFlag{
+				Name:  "compress-level",
+				Usage: "upload compression level, codec default if unset",
+				Value: chiv.DefaultCompression,
+			},
+			cli.Int64Flag{
+				Name:  "max-rows",
+				Usage: "split uploads into objects of at most this many rows",
+			},
+			cli.Int64Flag{
+				Name:  "max-bytes",
+				Usage: "split uploads into objects of approximately this many bytes",
+			},
+			cli.StringSliceFlag{
+				Name:  "partition-by",
+				Usage: "database columns to partition uploads by, comma-separated",
+			},
+			cli.IntFlag{
+				Name:  "max-open-partitions",
+				Usage: "maximum number of partitions uploaded concurrently",
+			},
+			cli.StringFlag{
+				Name:  "incremental, i",
+				Usage: "archive incrementally by this ever-increasing column",
+			},
+			cli.StringFlag{
+				Name:  "checkpoints",
+				Usage: "incremental checkpoint file path or s3://bucket/key",
+				Value: "chiv_checkpoints.json",
+			},
+			cli.StringFlag{
+				Name:  "where, w",
+				Usage: "archive only rows matching this condition, e.g. \"created_at < '2020-01-01'\"",
+			},
+			cli.StringSliceFlag{
+				Name:  "order-by",
+				Usage: "order archived rows by these columns, comma-separated, e.g. \"created_at DESC\"",
+			},
+			cli.Int64Flag{
+				Name:  "limit",
+				Usage: "archive at most this many rows",
+			},
+			cli.Int64Flag{
+				Name:  "batch-size",
+				Usage: "read tables in batches of this many rows, each by its own query, requires --batch-keys",
+			},
+			cli.StringSliceFlag{
+				Name:  "batch-keys",
+				Usage: "unique key columns ordering batched reads, comma-separated",
+			},
+			cli.IntFlag{
+				Name:  "parallelism",
+				Usage: "archive each table with this many concurrent queries, requires --split-column",
+			},
+			cli.StringFlag{
+				Name:  "split-column",
+				Usage: "integer column dividing tables into ranges for parallel queries, or ctid for Postgres 14 or later",
+			},
+			cli.BoolFlag{
+				Name:  "parallel-parts",
+				Usage: "upload each range of a parallel archive to its own objects",
+			},
+			cli.StringSliceFlag{
+				Name:  "purge",
+				Usage: "delete archived rows by these unique key columns, comma-separated, after uploading",
+			},
+			cli.BoolFlag{
+				Name:  "confirm-purge",
+				Usage: "confirm deleting archived rows, required with --purge",
+			},
+			cli.BoolFlag{
+				Name:  "snapshot",
+				Usage: "archive all tables from a single consistent snapshot",
+			},
+			cli.IntFlag{
+				Name:  "table-concurrency",
+				Usage: "maximum number of tables archived concurrently",
+			},
+			cli.StringFlag{
+				Name:  "encryption-key-file",
+				Usage: "encrypt uploads using the 256-bit key encryption key in this file",
+			},
+			cli.StringFlag{
+				Name:  "verify",
+				Usage: "verify uploads by their size, or by downloading them again to compare their checksums and rows: size or content",
+			},
+			cli.BoolFlag{
+				Name:  "annotate",
+				Usage: "annotate verified uploads with their checksum and rows by copying them in place, requires --verify",
+			},
+			cli.BoolFlag{
+				Name:  "schema-sidecar",
+				Usage: "upload a schema sidecar describing the archived columns next to each object",
+			},
+			cli.StringFlag{
+				Name:  "output, o",
+				Usage: "print the result of archiving a single table as text or json",
+				Value: "text",
+			},
 			cli.BoolFlag{
 				Name:  "help, h",
 				Usage: "show usage details",
@@ -87,11 +200,17 @@ func main() {
 }
 
 type config struct {
-	url     string
-	table   string
-	bucket  string
-	driver  string
-	options []chiv.Option
+	url         string
+	tables      []string
+	destination destination
+	driver      string
+	incremental string
+	checkpoints string
+	purge       []string
+	snapshot    bool
+	verify      string
+	output      string
+	options     []chiv.Option
 }
 
 func run(ctx *cli.Context) (err error) {
@@ -105,33 +224,226 @@ func run(ctx *cli.Context) (err error) {
 		return cli.ShowAppHelp(ctx)
 	}
 
-	config := from(ctx)
+	if err := required(ctx); err != nil {
+		return err
+	}
+
+	config, err := from(ctx)
+	if err != nil {
+		return err
+	}
 
 	db, err := sql.Open(config.driver, config.url)
 	if err != nil {
 		return fmt.Errorf("opening database connection: %w", err)
 	}
 
-	awsSession, err := session.NewSessionWithOptions(session.Options{
-		Config:            *aws.NewConfig(),
-		Profile:           os.Getenv("AWS_PROFILE"),
-		SharedConfigState: session.SharedConfigEnable,
-	})
+	// AWS configuration is only loaded for S3 destinations and checkpoints, so other destinations don't require it.
+	var (
+		checkpoints, parseErr = url.Parse(config.checkpoints)
+		s3Checkpoints         = config.incremental != "" && parseErr == nil && checkpoints.Scheme == "s3"
+		client                *s3.Client
+	)
+	if config.destination.scheme == "s3" || s3Checkpoints {
+		if client, err = newS3Client(); err != nil {
+			return err
+		}
+	}
+
+	sink, err := config.destination.sink(context.Background(), client)
 	if err != nil {
-		return fmt.Errorf("opening AWS session: %w", err)
+		return err
 	}
-	client := s3.New(awsSession)
-	uploader := s3manager.NewUploaderWithClient(client)
+	config.options = append(config.options, chiv.WithSink(sink))
 
-	return chiv.Archive(db, uploader, config.table, config.bucket, config.options...)
+	if config.verify != "" {
+		config.options = append(config.options, chiv.WithVerify(awsv2.NewClient(client), verifications[config.verify]))
+	}
+
+	if config.incremental != "" {
+		var store chiv.CheckpointStore
+		if s3Checkpoints {
+			store = chiv.NewS3CheckpointStore(awsv2.NewClient(client), checkpoints.Host, strings.TrimPrefix(checkpoints.Path, "/"))
+		} else {
+			store = chiv.NewFileCheckpointStore(config.checkpoints)
+		}
+		config.options = append(config.options, chiv.WithIncremental(config.incremental, store))
+	}
+
+	if len(config.purge) > 0 {
+		result, err := chiv.NewArchiver(db, nil).ArchiveAndPurge(config.tables[0], config.destination.bucket, config.purge, config.options...)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("archived %d rows, deleted %d rows\n", result.Archived, result.Deleted)
+		return nil
+	}
+
+	if config.snapshot {
+		return chiv.NewArchiver(db, nil).ArchiveSnapshot(config.tables, config.destination.bucket, config.options...)
+	}
+
+	if single(config.tables) {
+		result, err := chiv.NewArchiver(db, nil).ArchiveWithResult(config.tables[0], config.destination.bucket, config.options...)
+		if err != nil {
+			return err
+		}
+		return printResult(os.Stdout, result, config.output)
+	}
+
+	return chiv.NewArchiver(db, nil).ArchiveTables(config.tables, config.destination.bucket, config.options...)
 }
 
-func from(ctx *cli.Context) config {
+// printResult prints the result of an archive as text or JSON.
+func printResult(w io.Writer, result *chiv.Result, output string) error {
+	if output == "json" {
+		type object struct {
+			Bucket        string `json:"bucket"`
+			Key           string `json:"key"`
+			Format        string `json:"format,omitempty"`
+			Location      string `json:"location"`
+			VersionID     string `json:"version_id,omitempty"`
+			ETag          string `json:"etag"`
+			Rows          int64  `json:"rows"`
+			Bytes         int64  `json:"bytes"`
+			UploadedBytes int64  `json:"uploaded_bytes"`
+			Checksum      string `json:"sha256"`
+		}
+		out := struct {
+			Rows          int64    `json:"rows"`
+			Bytes         int64    `json:"bytes"`
+			UploadedBytes int64    `json:"uploaded_bytes"`
+			Download      float64  `json:"download_seconds"`
+			Upload        float64  `json:"upload_seconds"`
+			Objects       []object `json:"objects"`
+		}{
+			Rows:          result.Rows,
+			Bytes:         result.Bytes,
+			UploadedBytes: result.UploadedBytes,
+			Download:      result.Download.Seconds(),
+			Upload:        result.Upload.Seconds(),
+			Objects:       make([]object, len(result.Objects)),
+		}
+		for i, o := range result.Objects {
+			out.Objects[i] = object(*o)
+		}
+		return json.NewEncoder(w).Encode(out)
+	}
+
+	fmt.Fprintf(w, "archived %d rows, %d bytes, %d bytes uploaded, download %s, upload %s\n",
+		result.Rows, result.Bytes, result.UploadedBytes, result.Download.Round(time.Millisecond), result.Upload.Round(time.Millisecond))
+	for _, o := range result.Objects {
+		name := fmt.Sprintf("s3://%s/%s", o.Bucket, o.Key)
+		if strings.Contains(o.Bucket, "://") {
+			name = o.Bucket + "/" + o.Key
+		}
+		fmt.Fprintf(w, "%s: %d rows, %d bytes, %d bytes uploaded, etag %s", name, o.Rows, o.Bytes, o.UploadedBytes, o.ETag)
+		if o.VersionID != "" {
+			fmt.Fprintf(w, ", version %s", o.VersionID)
+		}
+		fmt.Fprintf(w, ", location %s\n", o.Location)
+	}
+
+	return nil
+}
+
+// codecs maps compression flag values to codecs.
+var codecs = map[string]chiv.Codec{
+	"gzip":   chiv.Gzip,
+	"zstd":   chiv.Zstd,
+	"bzip2":  chiv.Bzip2,
+	"lz4":    chiv.LZ4,
+	"snappy": chiv.Snappy,
+}
+
+// verifications maps verify flag values to verification methods.
+var verifications = map[string]chiv.Verification{
+	"size":    chiv.VerifySize,
+	"content": chiv.VerifyContent,
+}
+
+// required checks the required flags of the archive command, as cli would if it did not also require them of subcommands.
+func required(ctx *cli.Context) error {
+	var missing []string
+	if ctx.String("database") == "" {
+		missing = append(missing, "database")
+	}
+	if len(ctx.StringSlice("table")) == 0 {
+		missing = append(missing, "table")
+	}
+	if ctx.String("bucket") == "" {
+		missing = append(missing, "bucket")
+	}
+
+	switch len(missing) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("Required flag %q not set", missing[0])
+	default:
+		return fmt.Errorf("Required flags %q not set", strings.Join(missing, ", "))
+	}
+}
+
+// newS3Client loads AWS configuration from the environment and shared configuration, returning an S3 client.
+func newS3Client() (*s3.Client, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS configuration: %w", err)
+	}
+
+	return s3.NewFromConfig(cfg), nil
+}
+
+// single reports whether tables names a single table rather than multiple tables or patterns.
+func single(tables []string) bool {
+	return len(tables) == 1 && !strings.ContainsAny(tables[0], "*?[\\!")
+}
+
+func from(ctx *cli.Context) (config, error) {
 	cfg := config{
-		url:    ctx.String("database"),
-		table:  ctx.String("table"),
-		bucket: ctx.String("bucket"),
-		driver: ctx.String("driver"),
+		url:         ctx.String("database"),
+		tables:      ctx.StringSlice("table"),
+		driver:      ctx.String("driver"),
+		incremental: ctx.String("incremental"),
+		checkpoints: ctx.String("checkpoints"),
+		purge:       ctx.StringSlice("purge"),
+		snapshot:    ctx.Bool("snapshot"),
+		verify:      ctx.String("verify"),
+		output:      ctx.String("output"),
+	}
+
+	destination, err := parseDestination(ctx.String("bucket"))
+	if err != nil {
+		return cfg, err
+	}
+	cfg.destination = destination
+
+	if _, ok := verifications[cfg.verify]; !ok && cfg.verify != "" {
+		return cfg, fmt.Errorf("unknown verification '%s'", cfg.verify)
+	}
+	if cfg.verify != "" && destination.scheme != "s3" {
+		return cfg, fmt.Errorf("--verify requires an S3 destination")
+	}
+	if ctx.Bool("annotate") {
+		if cfg.verify == "" {
+			return cfg, fmt.Errorf("--annotate requires --verify")
+		}
+		cfg.options = append(cfg.options, chiv.WithAnnotation())
+	}
+
+	if cfg.output != "text" && cfg.output != "json" {
+		return cfg, fmt.Errorf("unknown output '%s'", cfg.output)
+	}
+
+	if len(cfg.purge) > 0 && !ctx.Bool("confirm-purge") {
+		return cfg, fmt.Errorf("--purge deletes archived rows and requires --confirm-purge")
+	}
+	if len(cfg.purge) > 0 && !single(cfg.tables) {
+		return cfg, fmt.Errorf("--purge requires a single table")
+	}
+	if len(cfg.purge) > 0 && cfg.snapshot {
+		return cfg, fmt.Errorf("--purge cannot be used with --snapshot")
 	}
 
 	if columns := ctx.StringSlice("columns"); columns != nil {
@@ -139,12 +451,19 @@ func from(ctx *cli.Context) config {
 	}
 
 	var m = map[string]chiv.FormatterFunc{
-		"csv":  chiv.CSV,
-		"yaml": chiv.YAML,
-		"json": chiv.JSON,
+		"csv":     chiv.CSV,
+		"yaml":    chiv.YAML,
+		"json":    chiv.JSON,
+		"ndjson":  chiv.NDJSON,
+		"parquet": chiv.Parquet,
+		"avro":    chiv.Avro,
 	}
 	if format := ctx.String("format"); format != "" {
-		cfg.options = append(cfg.options, chiv.WithFormat(m[format]))
+		f, ok := m[format]
+		if !ok {
+			return cfg, fmt.Errorf("unknown format '%s'", format)
+		}
+		cfg.options = append(cfg.options, chiv.WithFormat(f))
 	}
 
 	if key := ctx.String("key"); key != "" {
@@ -153,9 +472,91 @@ func from(ctx *cli.Context) config {
 		cfg.options = append(cfg.options, chiv.WithExtension(extension))
 	}
 
+	if template := ctx.String("key-template"); template != "" {
+		cfg.options = append(cfg.options, chiv.WithKeyTemplate(template))
+	}
+
 	if null := ctx.String("null"); null != "" {
 		cfg.options = append(cfg.options, chiv.WithNull(null))
 	}
 
-	return cfg
+	if codec := ctx.String("compress"); codec != "" {
+		c, ok := codecs[codec]
+		if !ok {
+			return cfg, fmt.Errorf("unknown compression codec '%s'", codec)
+		}
+		cfg.options = append(cfg.options, chiv.WithCompression(c, ctx.Int("compress-level")))
+	}
+
+	if n := ctx.Int64("max-rows"); n > 0 {
+		cfg.options = append(cfg.options, chiv.WithMaxRowsPerObject(n))
+	}
+
+	if n := ctx.Int64("max-bytes"); n > 0 {
+		cfg.options = append(cfg.options, chiv.WithMaxBytesPerObject(n))
+	}
+
+	if columns := ctx.StringSlice("partition-by"); columns != nil {
+		cfg.options = append(cfg.options, chiv.WithPartitionBy(columns...))
+	}
+
+	if n := ctx.Int("max-open-partitions"); n > 0 {
+		cfg.options = append(cfg.options, chiv.WithMaxOpenPartitions(n))
+	}
+
+	if where := ctx.String("where"); where != "" {
+		cfg.options = append(cfg.options, chiv.WithWhere(where))
+	}
+
+	if columns := ctx.StringSlice("order-by"); columns != nil {
+		cfg.options = append(cfg.options, chiv.WithOrderBy(columns...))
+	}
+
+	if n := ctx.Int64("limit"); n > 0 {
+		cfg.options = append(cfg.options, chiv.WithLimit(n))
+	}
+
+	if n := ctx.Int64("batch-size"); n > 0 {
+		keys := ctx.StringSlice("batch-keys")
+		if len(keys) == 0 {
+			return cfg, fmt.Errorf("--batch-size requires --batch-keys")
+		}
+		cfg.options = append(cfg.options, chiv.WithBatchSize(n, keys...))
+	}
+
+	if n := ctx.Int("parallelism"); n > 1 {
+		if cfg.snapshot {
+			return cfg, fmt.Errorf("--parallelism cannot be used with --snapshot")
+		}
+		column := ctx.String("split-column")
+		if column == "" {
+			return cfg, fmt.Errorf("--parallelism requires --split-column")
+		}
+		cfg.options = append(cfg.options, chiv.WithParallelism(n, column))
+		if ctx.Bool("parallel-parts") {
+			cfg.options = append(cfg.options, chiv.WithParallelParts())
+		}
+	}
+
+	if n := ctx.Int("table-concurrency"); n > 0 {
+		cfg.options = append(cfg.options, chiv.WithTableConcurrency(n))
+	}
+
+	if terminal(os.Stderr) {
+		cfg.options = append(cfg.options, chiv.WithHooks(newProgress(os.Stderr).hooks()))
+	}
+
+	if path := ctx.String("encryption-key-file"); path != "" {
+		wrapper, err := chiv.NewFileKeyWrapper(path)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.options = append(cfg.options, chiv.WithEncryption(wrapper))
+	}
+
+	if ctx.Bool("schema-sidecar") {
+		cfg.options = append(cfg.options, chiv.WithSchemaSidecar())
+	}
+
+	return cfg, nil
 }
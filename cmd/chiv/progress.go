@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"gavincabbage.com/chiv"
+)
+
+// progress renders a line showing the rows and bytes archived from each table as it is archived.
+type progress struct {
+	mu     sync.Mutex
+	w      io.Writer
+	starts map[string]time.Time
+}
+
+func newProgress(w io.Writer) *progress {
+	return &progress{
+		w:      w,
+		starts: make(map[string]time.Time),
+	}
+}
+
+func (p *progress) hooks() chiv.Hooks {
+	return chiv.Hooks{
+		OnStart: func(_ context.Context, table string, _ []chiv.Column) {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+
+			p.starts[table] = time.Now()
+		},
+		OnRow: func(_ context.Context, table string, rows, bytes int64) {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+
+			p.render(table, rows, bytes, "")
+		},
+		OnComplete: func(_ context.Context, table string, result *chiv.Result) {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+
+			p.render(table, result.Rows, result.Bytes, "\n")
+			delete(p.starts, table)
+		},
+		OnError: func(_ context.Context, table string, _ chiv.Stage, _ error) {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+
+			if _, ok := p.starts[table]; ok {
+				fmt.Fprint(p.w, "\r\033[K")
+				delete(p.starts, table)
+			}
+		},
+	}
+}
+
+// render the progress line of a table, replacing the current line.
+func (p *progress) render(table string, rows, bytes int64, end string) {
+	var rate float64
+	if elapsed := time.Since(p.starts[table]).Seconds(); elapsed > 0 {
+		rate = float64(rows) / elapsed
+	}
+
+	fmt.Fprintf(p.w, "\r\033[K%s: %d rows, %.0f rows/s, %s%s", table, rows, rate, humanize(bytes), end)
+}
+
+// humanize formats a number of bytes in binary units, e.g. 1.5 MiB.
+func humanize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// terminal reports whether a file is a terminal.
+func terminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
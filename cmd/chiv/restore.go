@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/urfave/cli"
+
+	"gavincabbage.com/chiv"
+	"gavincabbage.com/chiv/awsv2"
+)
+
+var restoreCommand = cli.Command{
+	Name:      "restore",
+	Usage:     "Restore an archived object from Amazon S3 into a database table",
+	UsageText: "chiv restore [flags...]",
+	HideHelp:  true,
+	Action:    restore,
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:     "database, d",
+			Usage:    "database connection string",
+			EnvVar:   "DATABASE_URL",
+			Required: true,
+		},
+		cli.StringFlag{
+			Name:     "table, t",
+			Usage:    "database table to restore into",
+			Required: true,
+		},
+		cli.StringFlag{
+			Name:     "bucket, b",
+			Usage:    "S3 bucket name",
+			Required: true,
+		},
+		cli.StringFlag{
+			Name:     "key, k",
+			Usage:    "key of the archived object",
+			Required: true,
+		},
+		cli.StringFlag{
+			Name:  "driver, r",
+			Usage: "database driver type: postgres, mysql, sqlite, sqlserver or clickhouse",
+			Value: "postgres",
+		},
+		cli.StringFlag{
+			Name:  "format, f",
+			Usage: "archive format: csv, yaml, json or ndjson, detected from the key if unset",
+		},
+		cli.StringFlag{
+			Name:  "null, n",
+			Usage: "archived null value",
+		},
+		cli.StringFlag{
+			Name:  "compress, z",
+			Usage: "archive compression codec: gzip, zstd, bzip2, lz4 or snappy, detected if unset",
+		},
+		cli.StringFlag{
+			Name:  "encryption-key-file",
+			Usage: "decrypt the archive using the 256-bit key encryption key in this file",
+		},
+		cli.BoolFlag{
+			Name:  "create-table",
+			Usage: "create the table from the archive's schema sidecar if it does not exist",
+		},
+		cli.BoolFlag{
+			Name:  "help, h",
+			Usage: "show usage details",
+		},
+	},
+}
+
+func restore(ctx *cli.Context) (err error) {
+	defer func() {
+		if err != nil {
+			err = cli.NewExitError(err, 1)
+		}
+	}()
+
+	if ctx.Bool("help") {
+		return cli.ShowCommandHelp(ctx, "restore")
+	}
+
+	options, err := restoreOptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	db, err := sql.Open(ctx.String("driver"), ctx.String("database"))
+	if err != nil {
+		return fmt.Errorf("opening database connection: %w", err)
+	}
+	defer db.Close()
+
+	client, err := newS3Client()
+	if err != nil {
+		return err
+	}
+
+	result, err := chiv.Restore(context.Background(), db, awsv2.NewClient(client), ctx.String("bucket"), ctx.String("key"), ctx.String("table"), options...)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored %d rows, %d bytes downloaded\n", result.Rows, result.Bytes)
+
+	return nil
+}
+
+func restoreOptions(ctx *cli.Context) ([]chiv.Option, error) {
+	var options []chiv.Option
+
+	var m = map[string]chiv.ParserFunc{
+		"csv":    chiv.ParseCSV,
+		"yaml":   chiv.ParseYAML,
+		"json":   chiv.ParseJSON,
+		"ndjson": chiv.ParseNDJSON,
+	}
+	if format := ctx.String("format"); format != "" {
+		p, ok := m[format]
+		if !ok {
+			return nil, fmt.Errorf("unknown format '%s'", format)
+		}
+		options = append(options, chiv.WithParser(p))
+	}
+
+	if null := ctx.String("null"); null != "" {
+		options = append(options, chiv.WithNull(null))
+	}
+
+	if codec := ctx.String("compress"); codec != "" {
+		c, ok := codecs[codec]
+		if !ok {
+			return nil, fmt.Errorf("unknown compression codec '%s'", codec)
+		}
+		options = append(options, chiv.WithCompression(c, chiv.DefaultCompression))
+	}
+
+	if path := ctx.String("encryption-key-file"); path != "" {
+		wrapper, err := chiv.NewFileKeyWrapper(path)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, chiv.WithEncryption(wrapper))
+	}
+
+	if ctx.Bool("create-table") {
+		options = append(options, chiv.WithCreateTable())
+	}
+
+	return options, nil
+}
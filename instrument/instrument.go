@@ -0,0 +1,254 @@
+// Package instrument records Prometheus metrics and OpenTelemetry traces of chiv archives.
+//
+// Create an Instrumentation, register it with a Prometheus registerer and configure an Archiver with its hooks.
+//
+//	i := instrument.New(nil)
+//	prometheus.MustRegister(i)
+//
+//	chiv.Archive(db, uploader, "table", "bucket", chiv.WithHooks(i.Hooks()))
+package instrument
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"gavincabbage.com/chiv"
+)
+
+const (
+	namespace  = "chiv"
+	tracerName = "gavincabbage.com/chiv/instrument"
+)
+
+// Span attribute keys.
+const (
+	TableKey  = attribute.Key("chiv.table")
+	BucketKey = attribute.Key("chiv.bucket")
+	FormatKey = attribute.Key("chiv.format")
+	KeyKey    = attribute.Key("chiv.key")
+)
+
+// Instrumentation records metrics and traces of archives through chiv hooks. It is a prometheus.Collector
+// of the following metrics, each labeled by table:
+//
+//	chiv_rows_total                 rows archived
+//	chiv_bytes_total                bytes formatted, before compression and encryption
+//	chiv_uploaded_bytes_total       bytes uploaded
+//	chiv_archive_duration_seconds   duration of successful archives
+//	chiv_errors_total               failed archives, also labeled by the stage that failed
+//
+// Rows and bytes are counted as each object is uploaded. Spans named chiv.query, chiv.download and
+// chiv.upload are started around the stages of each archive, as children of any span in its context.
+// Database queries are made in the context of the chiv.query span, and objects are uploaded in the
+// context of their chiv.upload spans, so spans of instrumented drivers and clients are their children.
+//
+// Archives are tracked by table, so concurrent archives of the same table should use separate
+// Instrumentation.
+type Instrumentation struct {
+	rows     *prometheus.CounterVec
+	bytes    *prometheus.CounterVec
+	uploaded *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	errors   *prometheus.CounterVec
+	tracer   trace.Tracer
+
+	mu       sync.Mutex
+	archives map[string]*archive
+}
+
+// archive is the state of an instrumented archive of a table.
+type archive struct {
+	start    time.Time
+	bucket   string
+	query    trace.Span
+	download trace.Span
+	uploads  map[string]trace.Span
+}
+
+// New creates Instrumentation starting spans from the given provider, or the global provider if nil.
+func New(provider trace.TracerProvider) *Instrumentation {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+
+	return &Instrumentation{
+		rows: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "rows_total",
+			Help:      "Rows archived.",
+		}, []string{"table"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "bytes_total",
+			Help:      "Bytes formatted, before compression and encryption.",
+		}, []string{"table"}),
+		uploaded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "uploaded_bytes_total",
+			Help:      "Bytes uploaded.",
+		}, []string{"table"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "archive_duration_seconds",
+			Help:      "Duration of successful archives.",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 4, 10),
+		}, []string{"table"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "errors_total",
+			Help:      "Failed archives by the stage that failed.",
+		}, []string{"table", "stage"}),
+		tracer:   provider.Tracer(tracerName),
+		archives: make(map[string]*archive),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (i *Instrumentation) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range i.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (i *Instrumentation) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range i.collectors() {
+		c.Collect(ch)
+	}
+}
+
+func (i *Instrumentation) collectors() []prometheus.Collector {
+	return []prometheus.Collector{i.rows, i.bytes, i.uploaded, i.duration, i.errors}
+}
+
+// Hooks returns hooks instrumenting archives, to configure with chiv.WithHooks.
+func (i *Instrumentation) Hooks() chiv.Hooks {
+	return chiv.Hooks{
+		OnQuery:         i.onQuery,
+		OnStart:         i.onStart,
+		OnFormatterOpen: i.onFormatterOpen,
+		OnUploadPart:    i.onUploadPart,
+		OnComplete:      i.onComplete,
+		OnError:         i.onError,
+	}
+}
+
+func (i *Instrumentation) onQuery(ctx context.Context, table, bucket string) context.Context {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	a := &archive{start: time.Now(), bucket: bucket, uploads: make(map[string]trace.Span)}
+	ctx, a.query = i.tracer.Start(ctx, "chiv.query", trace.WithAttributes(TableKey.String(table), BucketKey.String(bucket)))
+	i.archives[table] = a
+
+	return ctx
+}
+
+func (i *Instrumentation) onStart(ctx context.Context, table string, _ []chiv.Column) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	a := i.archive(table)
+	if a.query != nil {
+		a.query.End()
+	}
+	_, a.download = i.tracer.Start(ctx, "chiv.download", trace.WithAttributes(i.attributes(table, a)...))
+}
+
+func (i *Instrumentation) onFormatterOpen(ctx context.Context, table, key string) context.Context {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	a := i.archive(table)
+	ctx, a.uploads[key] = i.tracer.Start(ctx, "chiv.upload", trace.WithAttributes(append(i.attributes(table, a), KeyKey.String(key))...))
+
+	return ctx
+}
+
+func (i *Instrumentation) onUploadPart(_ context.Context, table string, object *chiv.ObjectResult) {
+	i.rows.WithLabelValues(table).Add(float64(object.Rows))
+	i.bytes.WithLabelValues(table).Add(float64(object.Bytes))
+	i.uploaded.WithLabelValues(table).Add(float64(object.UploadedBytes))
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	a := i.archive(table)
+	if a.download != nil {
+		a.download.SetAttributes(FormatKey.String(object.Format))
+	}
+	if span, ok := a.uploads[object.Key]; ok {
+		span.SetAttributes(BucketKey.String(object.Bucket), FormatKey.String(object.Format))
+		span.End()
+		delete(a.uploads, object.Key)
+	}
+}
+
+func (i *Instrumentation) onComplete(_ context.Context, table string, _ *chiv.Result) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	a := i.archive(table)
+	i.duration.WithLabelValues(table).Observe(time.Since(a.start).Seconds())
+	i.end(table, a, nil)
+}
+
+func (i *Instrumentation) onError(_ context.Context, table string, stage chiv.Stage, err error) {
+	i.errors.WithLabelValues(table, string(stage)).Inc()
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if a, ok := i.archives[table]; ok {
+		i.end(table, a, err)
+	}
+}
+
+// archive returns the archive of a table, creating it if the table was not queried, e.g. when archiving rows.
+func (i *Instrumentation) archive(table string) *archive {
+	a, ok := i.archives[table]
+	if !ok {
+		a = &archive{start: time.Now(), uploads: make(map[string]trace.Span)}
+		i.archives[table] = a
+	}
+
+	return a
+}
+
+// attributes of the spans of an archive.
+func (i *Instrumentation) attributes(table string, a *archive) []attribute.KeyValue {
+	attributes := []attribute.KeyValue{TableKey.String(table)}
+	if a.bucket != "" {
+		attributes = append(attributes, BucketKey.String(a.bucket))
+	}
+
+	return attributes
+}
+
+// end the open spans of an archive, recording the error if any, and stop tracking it.
+func (i *Instrumentation) end(table string, a *archive, err error) {
+	spans := []trace.Span{a.query, a.download}
+	for _, span := range a.uploads {
+		spans = append(spans, span)
+	}
+
+	for _, span := range spans {
+		if span == nil || !span.IsRecording() {
+			continue
+		}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+
+	delete(i.archives, table)
+}
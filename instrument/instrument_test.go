@@ -0,0 +1,184 @@
+// +build unit
+
+package instrument_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	_ "modernc.org/sqlite"
+
+	"gavincabbage.com/chiv"
+	"gavincabbage.com/chiv/instrument"
+)
+
+func TestInstrumentation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chiv")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db, err := sql.Open("sqlite", filepath.Join(dir, "test.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE TABLE events (id INTEGER, name TEXT);
+		INSERT INTO events VALUES (1, 'first'), (2, 'second'), (3, 'third');
+	`)
+	require.NoError(t, err)
+
+	cases := []struct {
+		name     string
+		table    string
+		options  []chiv.Option
+		uploader *uploader
+		spans    []string
+		status   map[string]codes.Code
+		metrics  string
+	}{
+		{
+			name:     "success",
+			table:    "events",
+			options:  []chiv.Option{chiv.WithMaxRowsPerObject(2)},
+			uploader: &uploader{},
+			spans:    []string{"chiv.download", "chiv.query", "chiv.upload", "chiv.upload"},
+			status:   map[string]codes.Code{"chiv.query": codes.Unset, "chiv.download": codes.Unset, "chiv.upload": codes.Unset},
+			metrics: `
+				# HELP chiv_bytes_total Bytes formatted, before compression and encryption.
+				# TYPE chiv_bytes_total counter
+				chiv_bytes_total{table="events"} 41
+				# HELP chiv_rows_total Rows archived.
+				# TYPE chiv_rows_total counter
+				chiv_rows_total{table="events"} 3
+				# HELP chiv_uploaded_bytes_total Bytes uploaded.
+				# TYPE chiv_uploaded_bytes_total counter
+				chiv_uploaded_bytes_total{table="events"} 41
+			`,
+		},
+		{
+			name:     "query error",
+			table:    "missing",
+			uploader: &uploader{},
+			spans:    []string{"chiv.query"},
+			status:   map[string]codes.Code{"chiv.query": codes.Error},
+			metrics: `
+				# HELP chiv_errors_total Failed archives by the stage that failed.
+				# TYPE chiv_errors_total counter
+				chiv_errors_total{stage="query",table="missing"} 1
+			`,
+		},
+		{
+			name:     "upload error",
+			table:    "events",
+			uploader: &uploader{err: errors.New("uploading")},
+			spans:    []string{"chiv.download", "chiv.query", "chiv.upload"},
+			status:   map[string]codes.Code{"chiv.query": codes.Unset, "chiv.download": codes.Error, "chiv.upload": codes.Error},
+			metrics: `
+				# HELP chiv_errors_total Failed archives by the stage that failed.
+				# TYPE chiv_errors_total counter
+				chiv_errors_total{stage="upload",table="events"} 1
+			`,
+		},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				recorder = tracetest.NewSpanRecorder()
+				provider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+				subject  = instrument.New(provider)
+				options  = append(test.options, chiv.WithHooks(subject.Hooks()))
+				database = &database{DB: db}
+			)
+
+			err := chiv.Archive(database, test.uploader, test.table, "bucket", options...)
+			require.Equal(t, test.uploader.err == nil && test.table == "events", err == nil)
+
+			spans := recorder.Ended()
+			names := make([]string, len(spans))
+			for i, span := range spans {
+				names[i] = span.Name()
+				require.Equal(t, test.status[span.Name()], span.Status().Code, span.Name())
+
+				attributes := attribute.NewSet(span.Attributes()...)
+				table, _ := attributes.Value(instrument.TableKey)
+				require.Equal(t, test.table, table.AsString())
+				bucket, _ := attributes.Value(instrument.BucketKey)
+				require.Equal(t, "bucket", bucket.AsString())
+				if span.Name() == "chiv.upload" && err == nil {
+					format, _ := attributes.Value(instrument.FormatKey)
+					require.Equal(t, "csv", format.AsString())
+				}
+
+				switch span.Name() {
+				case "chiv.query":
+					require.NotEmpty(t, database.spans)
+					for _, parent := range database.spans {
+						require.Equal(t, span.SpanContext().SpanID(), parent)
+					}
+				case "chiv.upload":
+					require.Contains(t, test.uploader.spans, span.SpanContext().SpanID())
+				}
+			}
+			sort.Strings(names)
+			require.Equal(t, test.spans, names)
+
+			require.NoError(t, testutil.CollectAndCompare(subject, strings.NewReader(test.metrics),
+				"chiv_rows_total", "chiv_bytes_total", "chiv_uploaded_bytes_total", "chiv_errors_total"))
+			require.Equal(t, err == nil, testutil.CollectAndCount(subject, "chiv_archive_duration_seconds") == 1)
+		})
+	}
+}
+
+// database records the spans of the contexts tables are queried with.
+type database struct {
+	*sql.DB
+	mu    sync.Mutex
+	spans []trace.SpanID
+}
+
+func (d *database) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	d.mu.Lock()
+	d.spans = append(d.spans, trace.SpanContextFromContext(ctx).SpanID())
+	d.mu.Unlock()
+
+	return d.DB.QueryContext(ctx, query, args...)
+}
+
+// uploader records the spans of the contexts objects are uploaded with.
+type uploader struct {
+	err   error
+	mu    sync.Mutex
+	spans []trace.SpanID
+}
+
+func (u *uploader) Upload(ctx context.Context, bucket, key string, _ *chiv.Attributes, r io.Reader) (*chiv.ObjectInfo, error) {
+	u.mu.Lock()
+	u.spans = append(u.spans, trace.SpanContextFromContext(ctx).SpanID())
+	u.mu.Unlock()
+
+	if _, err := ioutil.ReadAll(r); err != nil {
+		return nil, err
+	}
+	if u.err != nil {
+		return nil, u.err
+	}
+
+	return &chiv.ObjectInfo{Location: "https://" + bucket + ".s3.amazonaws.com/" + key}, nil
+}
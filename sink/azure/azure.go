@@ -0,0 +1,60 @@
+// Package azure writes chiv archives to Azure Blob Storage.
+//
+// Configure an Archiver with a sink writing to a container.
+//
+//	credential, _ := azblob.NewSharedKeyCredential(account, key)
+//	u, _ := url.Parse("https://account.blob.core.windows.net/container")
+//	container := azblob.NewContainerURL(*u, azblob.NewPipeline(credential, azblob.PipelineOptions{}))
+//	chiv.Archive(db, nil, "table", "container", chiv.WithSink(azure.NewSink(container)))
+package azure
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+
+	"gavincabbage.com/chiv"
+)
+
+// NewSink returns a chiv.AttributeSink writing objects to block blobs in an Azure Blob Storage container.
+// Blobs are streamed in blocks, committed once their writers are closed. Azure requires metadata names to be
+// identifiers, so hyphens in names are replaced by underscores, e.g. chiv_encryption, which chiv.Decrypt accepts.
+func NewSink(container azblob.ContainerURL) chiv.Sink {
+	return chiv.NewUploadSink(func(ctx context.Context, key string, attributes *chiv.Attributes, r io.Reader) (*chiv.ObjectInfo, error) {
+		blob := container.NewBlockBlobURL(key)
+		response, err := azblob.UploadStreamToBlockBlob(ctx, r, blob, azblob.UploadStreamToBlockBlobOptions{
+			BlobHTTPHeaders: azblob.BlobHTTPHeaders{
+				ContentType:     attributes.ContentType,
+				ContentEncoding: attributes.ContentEncoding,
+			},
+			Metadata: metadata(attributes.Metadata),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		u := blob.URL()
+		info := &chiv.ObjectInfo{Location: u.String(), ETag: string(response.ETag())}
+		if versioned, ok := response.(interface{ VersionID() string }); ok {
+			info.VersionID = versioned.VersionID()
+		}
+
+		return info, nil
+	})
+}
+
+// metadata replaces hyphens in metadata names with underscores.
+func metadata(m map[string]string) azblob.Metadata {
+	if m == nil {
+		return nil
+	}
+
+	out := make(azblob.Metadata, len(m))
+	for k, v := range m {
+		out[strings.ReplaceAll(k, "-", "_")] = v
+	}
+
+	return out
+}
@@ -0,0 +1,133 @@
+// +build unit
+
+package azure_test
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/stretchr/testify/require"
+
+	"gavincabbage.com/chiv"
+	"gavincabbage.com/chiv/sink/azure"
+)
+
+func TestSink(t *testing.T) {
+	server := newServer()
+	defer server.Close()
+
+	u, err := url.Parse(server.URL + "/container")
+	require.NoError(t, err)
+	container := azblob.NewContainerURL(*u, azblob.NewPipeline(azblob.NewAnonymousCredential(), azblob.PipelineOptions{}))
+	subject := azure.NewSink(container).(chiv.AttributeSink)
+
+	w, err := subject.OpenWithAttributes(context.Background(), "table.csv.gz", &chiv.Attributes{
+		ContentType:     "text/csv",
+		ContentEncoding: "gzip",
+		Metadata:        map[string]string{"chiv-key": "key"},
+	})
+	require.NoError(t, err)
+	_, err = w.Write([]byte("first,1\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	require.Equal(t, blob{
+		data:            "first,1\n",
+		contentType:     "text/csv",
+		contentEncoding: "gzip",
+		metadata:        map[string]string{"chiv_key": "key"},
+	}, server.blobs["/container/table.csv.gz"])
+	require.Equal(t, chiv.ObjectInfo{
+		Location:  server.URL + "/container/table.csv.gz",
+		VersionID: "version",
+		ETag:      `"etag"`,
+	}, w.(chiv.InfoWriter).Info())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w, err = subject.Open(ctx, "canceled.csv")
+	require.NoError(t, err)
+	_, _ = w.Write([]byte("first,1\n"))
+	cancel()
+	require.Error(t, w.Close())
+	require.NotContains(t, server.blobs, "/container/canceled.csv")
+}
+
+type blob struct {
+	data            string
+	contentType     string
+	contentEncoding string
+	metadata        map[string]string
+}
+
+// server fakes block blob uploads to Azure Blob Storage.
+type server struct {
+	*httptest.Server
+	mu     sync.Mutex
+	blocks map[string]string
+	blobs  map[string]blob
+}
+
+func newServer() *server {
+	s := &server{blocks: make(map[string]string), blobs: make(map[string]blob)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func (s *server) handle(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var b blob
+	switch query := r.URL.Query(); {
+	case r.Method == http.MethodPut && query.Get("comp") == "block":
+		s.blocks[query.Get("blockid")] = string(body)
+		w.WriteHeader(http.StatusCreated)
+		return
+	case r.Method == http.MethodPut && query.Get("comp") == "blocklist":
+		var list struct {
+			Latest []string `xml:"Latest"`
+		}
+		if err := xml.Unmarshal(body, &list); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, id := range list.Latest {
+			b.data += s.blocks[id]
+		}
+	case r.Method == http.MethodPut:
+		b.data = string(body)
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+
+	b.contentType = r.Header.Get("x-ms-blob-content-type")
+	b.contentEncoding = r.Header.Get("x-ms-blob-content-encoding")
+	for k := range r.Header {
+		if name := strings.ToLower(k); strings.HasPrefix(name, "x-ms-meta-") {
+			if b.metadata == nil {
+				b.metadata = make(map[string]string)
+			}
+			b.metadata[strings.TrimPrefix(name, "x-ms-meta-")] = r.Header.Get(k)
+		}
+	}
+	s.blobs[r.URL.Path] = b
+
+	w.Header().Set("ETag", `"etag"`)
+	w.Header().Set("x-ms-version-id", "version")
+	w.WriteHeader(http.StatusCreated)
+}
@@ -0,0 +1,61 @@
+// Package gcs writes chiv archives to Google Cloud Storage.
+//
+// Configure an Archiver with a sink writing to a bucket.
+//
+//	client, _ := storage.NewClient(ctx)
+//	chiv.Archive(db, nil, "table", "bucket", chiv.WithSink(gcs.NewSink(client.Bucket("bucket"))))
+package gcs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"cloud.google.com/go/storage"
+
+	"gavincabbage.com/chiv"
+)
+
+type sink struct {
+	bucket *storage.BucketHandle
+}
+
+// NewSink returns a chiv.AttributeSink writing objects to a Google Cloud Storage bucket.
+// Objects are discarded if their writes are canceled.
+func NewSink(bucket *storage.BucketHandle) chiv.Sink {
+	return &sink{bucket: bucket}
+}
+
+// Open an object for writing.
+func (s *sink) Open(ctx context.Context, key string) (io.WriteCloser, error) {
+	return s.OpenWithAttributes(ctx, key, &chiv.Attributes{})
+}
+
+// OpenWithAttributes opens an object for writing with the given attributes.
+func (s *sink) OpenWithAttributes(ctx context.Context, key string, attributes *chiv.Attributes) (io.WriteCloser, error) {
+	w := s.bucket.Object(key).NewWriter(ctx)
+	w.ContentType = attributes.ContentType
+	w.ContentEncoding = attributes.ContentEncoding
+	w.Metadata = attributes.Metadata
+
+	return &writer{Writer: w}, nil
+}
+
+type writer struct {
+	*storage.Writer
+}
+
+// Info describes the written object.
+func (w *writer) Info() chiv.ObjectInfo {
+	attrs := w.Attrs()
+	if attrs == nil {
+		return chiv.ObjectInfo{}
+	}
+
+	return chiv.ObjectInfo{
+		Location:  fmt.Sprintf("gs://%s/%s", attrs.Bucket, attrs.Name),
+		VersionID: strconv.FormatInt(attrs.Generation, 10),
+		ETag:      attrs.Etag,
+	}
+}
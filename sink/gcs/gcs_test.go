@@ -0,0 +1,126 @@
+// +build unit
+
+package gcs_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/option"
+
+	"gavincabbage.com/chiv"
+	"gavincabbage.com/chiv/sink/gcs"
+)
+
+func TestSink(t *testing.T) {
+	server := newServer()
+	defer server.Close()
+
+	client, err := storage.NewClient(context.Background(), option.WithEndpoint(server.URL+"/storage/v1/"), option.WithoutAuthentication())
+	require.NoError(t, err)
+	defer client.Close()
+
+	subject := gcs.NewSink(client.Bucket("bucket")).(chiv.AttributeSink)
+
+	w, err := subject.OpenWithAttributes(context.Background(), "table.csv.gz", &chiv.Attributes{
+		ContentType:     "text/csv",
+		ContentEncoding: "gzip",
+		Metadata:        map[string]string{"chiv-key": "key"},
+	})
+	require.NoError(t, err)
+	_, err = w.Write([]byte("first,1\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	require.Equal(t, "first,1\n", server.objects["table.csv.gz"].data)
+	require.Equal(t, object{
+		Bucket:          "bucket",
+		Name:            "table.csv.gz",
+		ContentType:     "text/csv",
+		ContentEncoding: "gzip",
+		Metadata:        map[string]string{"chiv-key": "key"},
+		Generation:      "1",
+		Etag:            "etag-1",
+		data:            "first,1\n",
+	}, server.objects["table.csv.gz"])
+	require.Equal(t, chiv.ObjectInfo{Location: "gs://bucket/table.csv.gz", VersionID: "1", ETag: "etag-1"}, w.(chiv.InfoWriter).Info())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w, err = subject.Open(ctx, "canceled.csv")
+	require.NoError(t, err)
+	_, _ = w.Write([]byte("first,1\n"))
+	cancel()
+	require.Error(t, w.Close())
+	require.NotContains(t, server.objects, "canceled.csv")
+}
+
+type object struct {
+	Bucket          string            `json:"bucket"`
+	Name            string            `json:"name"`
+	ContentType     string            `json:"contentType,omitempty"`
+	ContentEncoding string            `json:"contentEncoding,omitempty"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	Generation      string            `json:"generation"`
+	Etag            string            `json:"etag"`
+	data            string
+}
+
+// server fakes multipart uploads to Google Cloud Storage.
+type server struct {
+	*httptest.Server
+	mu      sync.Mutex
+	objects map[string]object
+}
+
+func newServer() *server {
+	s := &server{objects: make(map[string]object)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.upload))
+	return s
+}
+
+func (s *server) upload(w http.ResponseWriter, r *http.Request) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var (
+		o      object
+		reader = multipart.NewReader(r.Body, params["boundary"])
+	)
+	part, err := reader.NextPart()
+	if err == nil {
+		err = json.NewDecoder(part).Decode(&o)
+	}
+	if err == nil {
+		part, err = reader.NextPart()
+	}
+	var data []byte
+	if err == nil {
+		data, err = ioutil.ReadAll(part)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o.data = string(data)
+	o.Generation = fmt.Sprint(len(s.objects) + 1)
+	o.Etag = "etag-" + o.Generation
+	s.objects[o.Name] = o
+	_ = json.NewEncoder(w).Encode(o)
+}
@@ -0,0 +1,102 @@
+// Package sftp writes chiv archives to a remote filesystem over SFTP.
+//
+// Configure an Archiver with a sink writing under a remote directory.
+//
+//	conn, _ := ssh.Dial("tcp", "host:22", config)
+//	client, _ := sftp.NewClient(conn)
+//	chiv.Archive(db, nil, "table", "", chiv.WithSink(chivsftp.NewSink(client, "/archives")))
+package sftp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/pkg/sftp"
+
+	"gavincabbage.com/chiv"
+)
+
+type sink struct {
+	client *sftp.Client
+	dir    string
+}
+
+// NewSink returns a chiv.Sink writing objects to files under a remote directory, at paths given by their keys.
+// Each object is written to a temporary file in its directory, which is created if needed, and renamed into
+// place once complete. The server must support the posix-rename@openssh.com extension.
+func NewSink(client *sftp.Client, dir string) chiv.Sink {
+	return &sink{client: client, dir: dir}
+}
+
+// Open a temporary file for an object.
+func (s *sink) Open(ctx context.Context, key string) (io.WriteCloser, error) {
+	if !validKey(key) {
+		return nil, fmt.Errorf("invalid key '%s'", key)
+	}
+
+	name := path.Join(s.dir, key)
+	if err := s.client.MkdirAll(path.Dir(name)); err != nil {
+		return nil, err
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	temp := path.Join(path.Dir(name), fmt.Sprintf(".%s.%s", path.Base(name), hex.EncodeToString(suffix)))
+
+	f, err := s.client.Create(temp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &writer{File: f, client: s.client, ctx: ctx, name: name}, nil
+}
+
+// writer writes an object to a temporary file.
+type writer struct {
+	*sftp.File
+	client *sftp.Client
+	ctx    context.Context
+	name   string
+}
+
+// Close the temporary file, renaming it into place unless the object was canceled.
+func (w *writer) Close() error {
+	err := w.File.Close()
+	if err == nil {
+		err = w.ctx.Err()
+	}
+	if err == nil {
+		err = w.client.PosixRename(w.File.Name(), w.name)
+	}
+	if err != nil {
+		_ = w.client.Remove(w.File.Name())
+	}
+
+	return err
+}
+
+// Info describes the written file.
+func (w *writer) Info() chiv.ObjectInfo {
+	return chiv.ObjectInfo{Location: w.name}
+}
+
+// validKey reports whether a key names a file within the sink's directory.
+func validKey(key string) bool {
+	if key == "" || strings.HasSuffix(key, "/") {
+		return false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == ".." {
+			return false
+		}
+	}
+
+	return true
+}
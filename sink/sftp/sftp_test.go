@@ -0,0 +1,77 @@
+// +build unit
+
+package sftp_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/require"
+
+	"gavincabbage.com/chiv"
+	chivsftp "gavincabbage.com/chiv/sink/sftp"
+)
+
+func TestSink(t *testing.T) {
+	client := newClient(t)
+	subject := chivsftp.NewSink(client, "/archives")
+
+	w, err := subject.Open(context.Background(), "tables/table.csv")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("first,1\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.Equal(t, chiv.ObjectInfo{Location: "/archives/tables/table.csv"}, w.(chiv.InfoWriter).Info())
+
+	f, err := client.Open("/archives/tables/table.csv")
+	require.NoError(t, err)
+	b, err := ioutil.ReadAll(f)
+	require.NoError(t, err)
+	require.Equal(t, "first,1\n", string(b))
+	require.NoError(t, f.Close())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w, err = subject.Open(ctx, "tables/canceled.csv")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("first,1\n"))
+	require.NoError(t, err)
+	cancel()
+	require.ErrorIs(t, w.Close(), context.Canceled)
+
+	_, err = client.Stat("/archives/tables/canceled.csv")
+	require.ErrorIs(t, err, os.ErrNotExist)
+	files, err := client.ReadDir("/archives/tables")
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	for _, key := range []string{"", "tables/", "../table.csv", "tables/../../table.csv"} {
+		_, err = subject.Open(context.Background(), key)
+		require.EqualError(t, err, "invalid key '"+key+"'")
+	}
+}
+
+// newClient connects a client to an in-memory SFTP server.
+func newClient(t *testing.T) *sftp.Client {
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+
+	server := sftp.NewRequestServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{serverReader, serverWriter}, sftp.InMemHandler())
+	go func() { _ = server.Serve() }()
+
+	client, err := sftp.NewClientPipe(clientReader, clientWriter)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = clientReader.Close()
+		_ = clientWriter.Close()
+		_ = client.Close()
+	})
+
+	return client
+}